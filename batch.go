@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cornejong/gowaveform/waveform"
+)
+
+// batchJob is one input file of a batch and the output it renders to
+type batchJob struct {
+	input, output string
+	err           error
+}
+
+// expandInputs turns the arguments of a batch into input files. Directories
+// contribute the audio files directly inside them, and arguments naming no
+// file are expanded as glob patterns, for shells that leave them alone.
+func expandInputs(args []string) ([]string, error) {
+	var inputs []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		switch {
+		case err == nil && info.IsDir():
+			entries, err := os.ReadDir(arg)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				if !entry.IsDir() && waveform.DetectFormat(entry.Name()) != waveform.FormatUnknown {
+					inputs = append(inputs, filepath.Join(arg, entry.Name()))
+				}
+			}
+		case err == nil:
+			inputs = append(inputs, arg)
+		default:
+			matches, globErr := filepath.Glob(arg)
+			if globErr != nil || len(matches) == 0 {
+				// Let the file fail on its own, like any other bad input
+				inputs = append(inputs, arg)
+				continue
+			}
+			sort.Strings(matches)
+			inputs = append(inputs, matches...)
+		}
+	}
+	return inputs, nil
+}
+
+// outputName fills in pattern for input: {name} is its base name without the
+// extension and {dir} the directory it is in
+func outputName(pattern, input string) string {
+	base := filepath.Base(input)
+	return strings.NewReplacer(
+		"{name}", strings.TrimSuffix(base, filepath.Ext(base)),
+		"{dir}", filepath.Dir(input),
+	).Replace(pattern)
+}
+
+// runBatch renders every input to the output pattern names for it, working on
+// up to jobs files at once, and reports how each file went once all are done.
+// A bad file doesn't stop the others. It returns the number of files that
+// failed.
+func runBatch(inputs []string, pattern string, jobs int, config *waveform.Config) int {
+	batch := make([]batchJob, len(inputs))
+	claimed := make(map[string]string, len(inputs))
+	for i, input := range inputs {
+		batch[i] = batchJob{input: input, output: outputName(pattern, input)}
+		// Two inputs rendering to the same file would overwrite each other
+		if first, ok := claimed[batch[i].output]; ok {
+			batch[i].err = fmt.Errorf("output %s is already written for %s", batch[i].output, first)
+			continue
+		}
+		claimed[batch[i].output] = input
+	}
+
+	// Every file decodes in a goroutine of its own; the bars of each are
+	// computed on the worker pool the library shares between them
+	queue := make(chan *batchJob)
+	var wg sync.WaitGroup
+	for range max(jobs, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				job.err = generate(job.input, job.output, config)
+			}
+		}()
+	}
+	for i := range batch {
+		if batch[i].err == nil {
+			queue <- &batch[i]
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	failed := 0
+	for _, job := range batch {
+		if job.err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "FAILED %s: %v\n", job.input, job.err)
+		} else {
+			fmt.Fprintf(os.Stderr, "ok     %s -> %s\n", job.input, job.output)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d waveforms generated\n", len(batch)-failed, len(batch))
+	return failed
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/cornejong/gowaveform/waveform"
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+)
+
+// writeTone writes a short 440 Hz tone as a 16-bit mono WAV file
+func writeTone(t *testing.T, path string) {
+	t.Helper()
+
+	buf := &audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: 1, SampleRate: 8000},
+		SourceBitDepth: 16,
+		Data:           make([]int, 8000),
+	}
+	for i := range buf.Data {
+		buf.Data[i] = int(10000 * math.Sin(2*math.Pi*440*float64(i)/8000))
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	enc := wav.NewEncoder(f, 8000, 16, 1, 1)
+	if err := enc.Write(buf); err != nil {
+		t.Fatalf("Failed to write %s: %v", filepath.Base(path), err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Failed to finish %s: %v", filepath.Base(path), err)
+	}
+}
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.wav", "a.flac", "notes.txt", "c.mp3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Directories are expanded one level deep only
+	if err := os.MkdirAll(filepath.Join(dir, "nested"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "nested", "d.wav"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	in := func(name string) string { return filepath.Join(dir, name) }
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"directory", []string{dir}, []string{in("a.flac"), in("b.wav"), in("c.mp3")}},
+		{"file", []string{in("notes.txt")}, []string{in("notes.txt")}},
+		{"glob", []string{in("*.wav")}, []string{in("b.wav")}},
+		{"sorted glob", []string{in("[bc].*")}, []string{in("b.wav"), in("c.mp3")}},
+		{"missing", []string{in("gone.wav")}, []string{in("gone.wav")}},
+		{"mixed", []string{in("c.mp3"), filepath.Join(dir, "nested")}, []string{in("c.mp3"), in("nested/d.wav")}},
+	} {
+		got, err := expandInputs(tc.args)
+		if err != nil {
+			t.Fatalf("%s: expandInputs failed: %v", tc.name, err)
+		}
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, input, want string
+	}{
+		{"{name}.svg", "music/song.mp3", "song.svg"},
+		{"{dir}/{name}.png", "music/song.mp3", "music/song.png"},
+		{"out/{name}-wave.json", "live.set.wav", "out/live.set-wave.json"},
+		{"{dir}/{name}.svg", "song.flac", "./song.svg"},
+		{"fixed.svg", "music/song.mp3", "fixed.svg"},
+	} {
+		if got := outputName(tc.pattern, tc.input); got != tc.want {
+			t.Errorf("outputName(%q, %q) = %q, expected %q", tc.pattern, tc.input, got, tc.want)
+		}
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	dir := t.TempDir()
+	good, other := filepath.Join(dir, "good.wav"), filepath.Join(dir, "other.wav")
+	writeTone(t, good)
+	writeTone(t, other)
+	// Not audio at all, whatever the extension says
+	bad := filepath.Join(dir, "bad.wav")
+	if err := os.WriteFile(bad, []byte("not audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// The same name as good.wav once the extension is dropped
+	clash := filepath.Join(dir, "sub", "good.wav")
+	if err := os.MkdirAll(filepath.Dir(clash), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTone(t, clash)
+
+	for _, tc := range []struct {
+		name    string
+		inputs  []string
+		pattern string
+		failed  int
+		written []string
+	}{
+		{"all good", []string{good, other}, "{dir}/{name}.svg", 0, []string{"good.svg", "other.svg"}},
+		// A bad file fails on its own, the others are still written
+		{"continue on error", []string{bad, good}, "{dir}/{name}.svg", 1, []string{"good.svg"}},
+		// The second input to claim an output is refused rather than overwriting the first
+		{"name collision", []string{good, clash}, filepath.Join(dir, "{name}.svg"), 1, []string{"good.svg"}},
+	} {
+		for _, name := range []string{"good.svg", "other.svg", "bad.svg"} {
+			os.Remove(filepath.Join(dir, name))
+		}
+
+		if failed := runBatch(tc.inputs, tc.pattern, 2, waveform.DefaultConfig()); failed != tc.failed {
+			t.Errorf("%s: expected %d failed, got %d", tc.name, tc.failed, failed)
+		}
+		for _, name := range tc.written {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Errorf("%s: expected %s to be written: %v", tc.name, name, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, "bad.svg")); err == nil {
+			t.Errorf("%s: expected no output for the bad file", tc.name)
+		}
+	}
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/cornejong/gowaveform/waveform"
 )
@@ -14,17 +19,78 @@ var (
 	outputHeight = flag.Int("height", 80, "Total SVG height in pixels")
 	bars         = flag.Int("bars", 100, "Number of bars in waveform")
 	barSpacing   = flag.Int("spacing", 2, "Space between bars")
+	barRatio     = flag.Float64("barratio", 0, "Bar width as a fraction of its slot, replacing -spacing (0 means use -spacing)")
+	minHeight    = flag.Float64("minheight", 3.0, "Least height in pixels a bar reaches out from the midline (negative for none, letting silence vanish)")
 	barColor     = flag.String("color", "#3B82F6", "Bar color (hex)")
 	cornerRadius = flag.Float64("radius", 8.0, "Bar corner radius")
 	concurrent   = flag.Bool("concurrent", true, "Use concurrent processing for large files")
-	calcMode     = flag.String("mode", "dynamic", "Calculation mode: 'rms', 'lufs', 'peak', 'vu', 'dynamic', 'smooth'")
+	calcMode     = flag.String("mode", "dynamic", "Calculation mode: 'rms', 'lufs', 'peak', 'vu', 'dynamic', 'smooth', 'mad', 'onset', 'lufs-true'")
+	perBarFade   = flag.Bool("fade", false, "Fade each bar from solid at the midline to transparent at its tips")
+	roundTips    = flag.Bool("tips", false, "Round only the outer tips of each bar")
+	opacity      = flag.Bool("opacity", false, "Fade each bar to an opacity matching its amplitude")
+	minOpacity   = flag.Float64("minopacity", 0, "With -opacity, the opacity silent bars keep, 0..1")
+	precision    = flag.Int("precision", 0, "Decimal places for SVG coordinates (0 keeps full precision)")
+	meterColors  = flag.Bool("meter", false, "Color bars green/yellow/red by level like a broadcast meter")
+	skip         = flag.Float64("skip", 0, "Omit bars below this fraction of the loudest bar, leaving gaps (0 draws every bar)")
+	trim         = flag.Bool("trim", false, "Trim sustained silence from the start and end, keeping fade-ins")
+	replayGain   = flag.Bool("replaygain", false, "Apply the file's ReplayGain track gain before visualizing")
+	maxDuration  = flag.Duration("maxduration", 0, "Only decode this much audio from the start, e.g. 30s (0 decodes everything)")
+	overlap      = flag.Bool("overlap", false, "Let a negative -spacing widen bars so they overlap")
+	deviation    = flag.Bool("deviation", false, "Draw bars up or down by how far each section deviates from the average")
+	shadow       = flag.Bool("shadow", false, "Draw a blurred drop shadow beneath the bars")
+	stereo       = flag.Bool("stereo", false, "Draw the left channel in the top half and the right channel in the bottom half")
+	perChannel   = flag.Bool("perchannel", false, "With -stereo, scale each channel to its own loudest bar")
+	clips        = flag.Bool("clips", false, "Mark clipped stretches of audio with red bands")
+	loudness     = flag.Bool("loudness", false, "Trace the short-term loudness as a line over the bars")
+	channel      = flag.String("channel", "mix", "Channels to visualize: 'mix', 'left', 'right'")
+	timeAxis     = flag.String("timeaxis", "linear", "Bar layout along time: 'linear', or 'log' to widen the start and compress the end")
+	sampleRate   = flag.Int("samplerate", 0, "Resample audio to this rate in Hz before visualizing (0 keeps the source rate)")
+	propRadius   = flag.Bool("propradius", false, "Scale each bar's corner radius with its height, up to -radius")
+	streaming    = flag.Bool("stream", false, "Compute bars while decoding to keep memory low on long files")
+	mono         = flag.Bool("mono", false, "Mix audio down to mono, natively in the decoder where supported")
+	silenceColor = flag.String("silencecolor", "", "Color (hex) for silent bars, telling silence apart from quiet content")
+	barsPerSec   = flag.Float64("bps", 0, "Bars per second of audio, overriding -bars (0 uses -bars)")
+	autoWidth    = flag.Bool("autowidth", false, "With -bps, scale -width with the bar count, keeping width/bars pixels per bar")
+	butterfly    = flag.Bool("butterfly", false, "Decorative: draw the first half of the track above the midline and the second half below")
+	gradStart    = flag.String("gradstart", "", "Bar gradient color (hex) at the top edge; with -gradend, replaces -color")
+	gradEnd      = flag.String("gradend", "", "Bar gradient color (hex) at the bottom edge; with -gradstart, replaces -color")
+	background   = flag.String("background", "", "Background color (hex); empty keeps the background transparent")
+	bgRadius     = flag.Float64("bgradius", 0, "Corner radius of the -background for a card look")
+	loops        = flag.Bool("loops", false, "Mark the loop points of WAV files (smpl chunk)")
+	normalize    = flag.String("normalize", "perfile", "Amplitude filling the height: 'perfile' (loudest bar) or 'fixed' (-maxamp)")
+	maxAmplitude = flag.Float64("maxamp", 0, "With -normalize fixed, the peak on the scale of -mode that fills the height; vu, dynamic, lufs and lufs-true can exceed 1 (0 means 1)")
+	maxElements  = flag.Int("maxelements", 0, "Cap the bars drawn, max-pooling -bars down to this many (0 means no cap)")
+	ampScale     = flag.String("ampscale", "linear", "Amplitude scale: 'linear', or 'log' (dB) to bring out quiet detail")
+	dbFloor      = flag.Float64("dbfloor", -60, "With -ampscale log, the level in dB that maps to the minimum bar height")
+	gamma        = flag.Float64("gamma", 1.0, "Bend bar heights as level^gamma: below 1 lifts quiet detail, above 1 emphasizes peaks")
+	tooltips     = flag.Bool("tooltips", false, "Give each bar a hover tooltip with its time range and level")
+	title        = flag.String("title", "", "Accessible title of the SVG, announced by screen readers")
+	description  = flag.String("desc", "", "Accessible description of the SVG, read out after -title")
+	barClass     = flag.String("class", "", "CSS class set on every bar of the SVG")
+	svgID        = flag.String("id", "", "Id of the root <svg> element")
+	style        = flag.String("style", "bars", "Drawing style: 'bars', 'filled' (one shape tracing the peaks) or 'line'")
+	lineWidth    = flag.Float64("linewidth", 2, "Line width for -style line")
+	ampAxis      = flag.Bool("ampaxis", false, "Draw a dB axis with labelled ticks left of the waveform")
+	axisTicks    = flag.String("axisticks", "", "Comma-separated dB levels marked on -ampaxis, e.g. '0,-6,-12' (empty uses 0,-3,-6,-12,-18)")
+	startTime    = flag.Duration("start", 0, "Start of the stretch of audio to render, e.g. 1m30s")
+	endTime      = flag.Duration("end", 0, "End of the stretch of audio to render, e.g. 2m (0 runs to the end of the file)")
+	showProgress = flag.Bool("progress", false, "Print the progress of decoding and computing the bars to stderr")
+	align        = flag.String("align", "center", "Bar alignment: 'center', 'bottom' or 'top'")
+	orientation  = flag.String("orientation", "horizontal", "Orientation: 'horizontal' (time runs left to right) or 'vertical' (time runs top to bottom)")
+	scale        = flag.Float64("scale", 1.0, "Pixel scale for .png output, e.g. 2 for retina images")
+	animate      = flag.Int("animate", 0, "Draw the waveform in over this many milliseconds (0 disables animation)")
+	outFormat    = flag.String("format", "", "Output format: 'svg', 'png', 'json' or 'dat' (empty picks it from the output extension)")
+	outPattern   = flag.String("out", "", "Batch mode: render every input to this pattern, e.g. '{dir}/{name}.svg'")
+	jobs         = flag.Int("jobs", runtime.NumCPU(), "With -out, the number of files processed at once")
 )
 
 func main() {
 	flag.Parse()
 
-	if flag.NArg() < 2 {
-		log.Fatalf("Usage: %s [options] input.{mp3|wav|flac|ogg|aiff|opus} output.svg\n", os.Args[0])
+	if flag.NArg() < 2 && (*outPattern == "" || flag.NArg() < 1) {
+		log.Fatalf("Usage: %s [options] input.{mp3|wav|flac|ogg|aiff|opus} output.{svg|png|json|dat}\n"+
+			"       %s [options] -out pattern inputs...\n"+
+			"Use - as input or output to read from stdin or write SVG to stdout\n", os.Args[0], os.Args[0])
 	}
 
 	// Convert string mode to CalculationMode
@@ -42,34 +108,196 @@ func main() {
 		mode = waveform.ModeDynamic
 	case "smooth":
 		mode = waveform.ModeSmooth
+	case "mad":
+		mode = waveform.ModeMAD
+	case "onset":
+		mode = waveform.ModeOnset
+	case "lufs-true":
+		mode = waveform.ModeLUFSTrue
 	default:
-		log.Fatalf("Invalid mode '%s'. Valid modes are: rms, lufs, peak, vu, dynamic, smooth\n", *calcMode)
+		log.Fatalf("Invalid mode '%s'. Valid modes are: rms, lufs, peak, vu, dynamic, smooth, mad, onset, lufs-true\n", *calcMode)
 	}
 
-	inputFile := flag.Arg(0)
-	outputFile := flag.Arg(1)
+	var ticks []float64
+	if *axisTicks != "" {
+		for _, field := range strings.Split(*axisTicks, ",") {
+			level, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				log.Fatalf("Invalid -axisticks level '%s'\n", field)
+			}
+			ticks = append(ticks, level)
+		}
+	}
 
 	// Create configuration from CLI flags
 	config := &waveform.Config{
-		Width:        *outputWidth,
-		Height:       *outputHeight,
-		Bars:         *bars,
-		BarSpacing:   *barSpacing,
-		BarColor:     *barColor,
-		CornerRadius: *cornerRadius,
-		Concurrent:   *concurrent,
-		Mode:         mode,
-	}
-
-	// Generate waveform using the library
-	w, err := waveform.NewFromAudioFile(inputFile, config)
-	if err != nil {
-		log.Fatalf("Failed to read audio file: %v\n", err)
+		Width:               *outputWidth,
+		Height:              *outputHeight,
+		Bars:                *bars,
+		BarSpacing:          *barSpacing,
+		BarWidthRatio:       *barRatio,
+		MinBarHeight:        *minHeight,
+		BarColor:            *barColor,
+		CornerRadius:        *cornerRadius,
+		Concurrent:          *concurrent,
+		Mode:                mode,
+		PerBarFade:          *perBarFade,
+		RoundTipsOnly:       *roundTips,
+		OpacityByAmplitude:  *opacity,
+		MinOpacity:          *minOpacity,
+		MeterColors:         *meterColors,
+		SkipThreshold:       *skip,
+		TrimSilence:         *trim,
+		ApplyReplayGain:     *replayGain,
+		MaxDuration:         *maxDuration,
+		StartTime:           *startTime,
+		EndTime:             *endTime,
+		AllowOverlap:        *overlap,
+		DeviationView:       *deviation,
+		BaseShadow:          *shadow,
+		ShadowOffset:        2,
+		ShadowBlur:          2,
+		CoordinatePrecision: *precision,
+		StereoSplit:         *stereo,
+		PerChannelNormalize: *perChannel,
+		ClipOverlay:         *clips,
+		LoudnessCurve:       *loudness,
+		Channel:             waveform.Channel(*channel),
+		TimeAxis:            waveform.TimeAxis(*timeAxis),
+		TargetSampleRate:    *sampleRate,
+		ProportionalRadius:  *propRadius,
+		Streaming:           *streaming,
+		NativeMonoDecode:    *mono,
+		SilenceColor:        *silenceColor,
+		SilenceThreshold:    waveform.DefaultConfig().SilenceThreshold,
+		BarsPerSecond:       *barsPerSec,
+		AutoWidth:           *autoWidth,
+		Butterfly:           *butterfly,
+		Alignment:           waveform.Alignment(*align),
+		Orientation:         waveform.Orientation(*orientation),
+		GradientStart:       *gradStart,
+		GradientEnd:         *gradEnd,
+		BackgroundColor:     *background,
+		BackgroundRadius:    *bgRadius,
+		LoopMarkers:         *loops,
+		NormalizeMode:       waveform.NormalizeMode(*normalize),
+		MaxAmplitude:        *maxAmplitude,
+		MaxElements:         *maxElements,
+		AmplitudeScale:      waveform.AmplitudeScale(*ampScale),
+		DBFloor:             *dbFloor,
+		Gamma:               *gamma,
+		Tooltips:            *tooltips,
+		Title:               *title,
+		Description:         *description,
+		BarClass:            *barClass,
+		SVGID:               *svgID,
+		ShowAmplitudeAxis:   *ampAxis,
+		AmplitudeAxisTicks:  ticks,
+		Style:               waveform.Style(*style),
+		LineWidth:           *lineWidth,
+		Scale:               *scale,
+	}
+
+	if *outPattern != "" {
+		inputs, err := expandInputs(flag.Args())
+		if err != nil {
+			log.Fatalf("Failed to list input files: %v\n", err)
+		}
+		if failed := runBatch(inputs, *outPattern, *jobs, config); failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *showProgress {
+		config.ProgressFunc = func(fraction float64) {
+			fmt.Fprintf(os.Stderr, "\rProgress: %3.0f%%", fraction*100)
+			if fraction == 1 {
+				fmt.Fprintln(os.Stderr)
+			}
+		}
 	}
 
-	if err := w.WriteSVG(outputFile); err != nil {
-		log.Fatalf("Failed to write SVG: %v\n", err)
+	outputFile := flag.Arg(1)
+	if err := generate(flag.Arg(0), outputFile, config); err != nil {
+		log.Fatalf("%v\n", err)
 	}
 
 	log.Printf("Waveform generated using %s mode: %s\n", *calcMode, outputFile)
 }
+
+// outputFormat returns the format outputFile is written in: -format if given,
+// its extension otherwise. Stdout gets SVG unless -format says otherwise.
+func outputFormat(outputFile string) (string, error) {
+	format := strings.ToLower(*outFormat)
+	if format == "" && outputFile != "-" {
+		format = strings.ToLower(strings.TrimPrefix(filepath.Ext(outputFile), "."))
+	}
+	switch format {
+	case "":
+		if outputFile == "-" {
+			return "svg", nil
+		}
+		return "", fmt.Errorf("cannot tell the output format of %s without an extension; use .svg, .png, .json or .dat, or -format", outputFile)
+	case "svg", "png", "json", "dat":
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q; use svg, png, json or dat", format)
+	}
+}
+
+// generate renders the waveform of inputFile to outputFile in the format
+// outputFormat picks. "-" reads the audio from stdin or writes the output to
+// stdout.
+func generate(inputFile, outputFile string, config *waveform.Config) error {
+	// Check the format first, so a typo doesn't cost a decode
+	format, err := outputFormat(outputFile)
+	if err != nil {
+		return err
+	}
+
+	// .dat pairs are computed from the samples, which waveforms only keep on request
+	if format == "dat" {
+		datConfig := *config
+		datConfig.RetainSamples = true
+		config = &datConfig
+	}
+
+	var w *waveform.Waveform
+	if inputFile == "-" {
+		w, err = waveform.NewFromAudioReader(os.Stdin, config)
+	} else {
+		w, err = waveform.NewFromAudioFile(inputFile, config)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read audio file: %w", err)
+	}
+
+	var data []byte
+	switch {
+	case format == "png":
+		data, err = w.GeneratePNG()
+	case format == "json":
+		data, err = w.GenerateJSON()
+	case format == "dat":
+		data, err = w.GenerateDat()
+	case *animate > 0:
+		data, err = w.GenerateAnimatedSVG(*animate)
+	default:
+		data, err = w.GenerateSVG()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to generate %s: %w", strings.ToUpper(format), err)
+	}
+
+	// Writing to stdout keeps it clean for pipelines; the log goes to stderr
+	if outputFile == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(outputFile, data, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", strings.ToUpper(format), err)
+	}
+	return nil
+}
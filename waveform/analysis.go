@@ -0,0 +1,181 @@
+package waveform
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// signatureBits is the number of bits in a waveform signature
+	signatureBits = 64
+	// peakDBFloor is the level in dBFS PeaksDB and PeakDBFS report for silent
+	// bars, well below the quietest level 16-bit audio can hold
+	peakDBFloor = -120.0
+)
+
+// Signature returns a compact perceptual fingerprint of the waveform envelope.
+// The peaks are averaged into 65 segments and each bit records whether the
+// envelope rises from one segment to the next. Since only the shape matters the
+// result is independent of overall gain, and perceptually similar waveforms
+// differ in few bits (compare with bits.OnesCount64(a ^ b)).
+func (w *Waveform) Signature() uint64 {
+	segments := averageSegments(w.Peaks, signatureBits+1)
+	if segments == nil {
+		return 0
+	}
+
+	var sig uint64
+	for i := 0; i < signatureBits; i++ {
+		if segments[i+1] > segments[i] {
+			sig |= 1 << uint(i)
+		}
+	}
+	return sig
+}
+
+// averageSegments reduces peaks to n values by averaging the peaks in each segment.
+// Short inputs are stretched with linear interpolation instead.
+func averageSegments(peaks []float64, n int) []float64 {
+	if len(peaks) < n {
+		return resamplePeaks(peaks, n, InterpolationLinear)
+	}
+
+	out := make([]float64, n)
+	for i := range out {
+		start := i * len(peaks) / n
+		end := (i + 1) * len(peaks) / n
+
+		var sum float64
+		for _, peak := range peaks[start:end] {
+			sum += peak
+		}
+		out[i] = sum / float64(end-start)
+	}
+	return out
+}
+
+// AmplitudeHistogram counts the samples of the whole buffer by absolute amplitude.
+// The full scale range [0, 32768] is split into bins equal-width bins, so bin 0
+// holds the quietest samples and the last bin those at or near full scale.
+// Returns nil if bins is not positive.
+func AmplitudeHistogram(samples []int16, bins int) []int {
+	if bins <= 0 {
+		return nil
+	}
+
+	counts := make([]int, bins)
+	for _, s := range samples {
+		a := int(s)
+		if a < 0 {
+			a = -a
+		}
+		bin := a * bins / 32768
+		if bin >= bins {
+			// Only -32768 reaches past the top of the range
+			bin = bins - 1
+		}
+		counts[bin]++
+	}
+	return counts
+}
+
+// AmplitudeHistogram counts the decoded samples of the waveform by absolute
+// amplitude; see the package-level AmplitudeHistogram. Returns nil if the
+// waveform holds no samples; see Config.RetainSamples.
+func (w *Waveform) AmplitudeHistogram(bins int) []int {
+	if w.samples == nil {
+		return nil
+	}
+	return AmplitudeHistogram(w.samples, bins)
+}
+
+// Points returns the tip of each bar as [x, y] in SVG coordinates, with y
+// growing downwards, scaled and normalized the way GenerateSVG draws the bars.
+// This lets callers such as D3.js draw the waveform however they like. The tip
+// is the top centre of the bar, or its bottom centre with AlignTop; DeviationView
+// and StereoSplit are ignored. Vertical waveforms turn the tips with the bars,
+// to the middle of their right end, or their left end with AlignBottom.
+func (w *Waveform) Points() [][2]float64 {
+	if len(w.Peaks) == 0 {
+		return nil
+	}
+
+	config := layoutConfig(w.Config)
+	vertical := config.Orientation == OrientationVertical
+
+	height := float64(config.Height)
+	maxPeak := fullScale(peakMax(w.Peaks), config)
+
+	points := make([][2]float64, len(w.Peaks))
+	for i, peak := range w.Peaks {
+		x, span := barSpan(i, len(w.Peaks), config)
+		h := barHalfHeight(peak, maxPeak, config)
+
+		tip := height/2 - h
+		switch config.Alignment {
+		case AlignBottom:
+			tip = height - h*2
+		case AlignTop:
+			tip = h * 2
+		}
+		points[i] = [2]float64{x + barInnerWidth(span, config)/2, tip}
+		if vertical {
+			points[i] = [2]float64{height - tip, points[i][0]}
+		}
+	}
+	return points
+}
+
+// PeaksDB returns the level of each bar in dBFS, 20*log10 of its loudest
+// sample, whatever the calculation mode and NormalizeMode draw it at. Silent
+// bars are peakDBFloor, -120 dBFS, rather than -Inf. The levels come from the
+// retained samples, across every channel; see Config.RetainSamples. Without
+// them only ModePeak bars are sample peaks, those of the drawn channel or mix,
+// and other modes return an error.
+func (w *Waveform) PeaksDB() ([]float64, error) {
+	peaks, err := w.samplePeaks()
+	if err != nil {
+		return nil, err
+	}
+
+	levels := make([]float64, len(peaks))
+	for i, peak := range peaks {
+		levels[i] = toDBFS(peak)
+	}
+	return levels, nil
+}
+
+// PeakDBFS returns the level of the loudest bar in dBFS, see PeaksDB, for
+// flagging clipped or overly quiet recordings
+func (w *Waveform) PeakDBFS() (float64, error) {
+	peaks, err := w.samplePeaks()
+	if err != nil {
+		return 0, err
+	}
+	return toDBFS(peakMax(peaks)), nil
+}
+
+// samplePeaks returns the loudest sample of each bar on the 0..1 scale of
+// full scale, from the retained samples or else from ModePeak bars
+func (w *Waveform) samplePeaks() ([]float64, error) {
+	if w.samples != nil {
+		peaks := make([]float64, len(w.Peaks))
+		for i, pair := range minMaxPairs(w.samples, len(w.Peaks)) {
+			peaks[i] = math.Max(-float64(pair[0]), float64(pair[1])) / 32768
+		}
+		return peaks, nil
+	}
+
+	if w.Config != nil && w.Config.Mode == ModePeak {
+		return w.Peaks, nil
+	}
+	return nil, fmt.Errorf("only ModePeak bars are sample peaks; set Config.RetainSamples")
+}
+
+// toDBFS converts a peak relative to full scale to dBFS, floored at peakDBFloor
+func toDBFS(peak float64) float64 {
+	if peak <= 0 {
+		return peakDBFloor
+	}
+	return math.Max(20*math.Log10(peak), peakDBFloor)
+}
@@ -1,11 +1,15 @@
 package waveform
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-audio/aiff"
 	"github.com/go-audio/audio"
@@ -13,7 +17,10 @@ import (
 	"github.com/hajimehoshi/go-mp3"
 	"github.com/jfreymuth/oggvorbis"
 	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
 	"github.com/pion/opus"
+	"github.com/pion/opus/pkg/oggreader"
 )
 
 // AudioFormat represents the supported audio formats
@@ -61,7 +68,7 @@ func DetectFormat(filename string) AudioFormat {
 		return FormatFLAC
 	case ".ogg":
 		return FormatOGG
-	case ".aiff", ".aif":
+	case ".aiff", ".aif", ".aifc":
 		return FormatAIFF
 	case ".opus":
 		return FormatOpus
@@ -70,7 +77,78 @@ func DetectFormat(filename string) AudioFormat {
 	}
 }
 
-// AudioDecoder interface for unified audio decoding
+// magicSize is how many bytes DetectFormatReader inspects; enough for the
+// headers of every format, including the first packet of an Ogg stream
+const magicSize = 512
+
+// DetectFormatReader detects the audio format from the magic bytes at the
+// current position of r, which is restored afterwards. It returns
+// FormatUnknown when the content doesn't identify a supported format, e.g. an
+// Ogg stream of another codec or an MP3 without ID3 tag that doesn't start on a
+// frame.
+func DetectFormatReader(r io.ReadSeeker) AudioFormat {
+	pos, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return FormatUnknown
+	}
+	defer r.Seek(pos, io.SeekStart)
+
+	header := make([]byte, magicSize)
+	n, _ := io.ReadFull(r, header)
+	return detectMagic(header[:n])
+}
+
+// detectMagic identifies the format whose file begins with header
+func detectMagic(header []byte) AudioFormat {
+	switch {
+	case len(header) >= 12 && string(header[:4]) == "RIFF" && string(header[8:12]) == "WAVE":
+		return FormatWAV
+	case len(header) >= 12 && string(header[:4]) == "FORM" && (string(header[8:12]) == "AIFF" || string(header[8:12]) == "AIFC"):
+		return FormatAIFF
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return FormatFLAC
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return detectOggCodec(header)
+	case bytes.HasPrefix(header, []byte("ID3")):
+		return FormatMP3
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0 && header[1]&0x06 != 0:
+		// An MPEG audio frame sync; layer bits of 00 belong to AAC's ADTS instead
+		return FormatMP3
+	default:
+		return FormatUnknown
+	}
+}
+
+// detectOggCodec identifies the codec of an Ogg stream from the first packet
+// of its first page
+func detectOggCodec(page []byte) AudioFormat {
+	if len(page) < 27 {
+		return FormatUnknown
+	}
+	start := 27 + int(page[26])
+	if len(page) < start {
+		return FormatUnknown
+	}
+
+	packet := page[start:]
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")):
+		return FormatOGG
+	case bytes.HasPrefix(packet, []byte("OpusHead")):
+		return FormatOpus
+	default:
+		return FormatUnknown
+	}
+}
+
+// AudioDecoder interface for unified audio decoding.
+//
+// Every decoder follows the same output contract: Read fills buf with
+// little-endian int16 PCM, interleaved frame by frame (L R L R ... for stereo),
+// and NumChannels reports how many samples make up one frame. Decoders scale
+// other bit depths to 16 bits but never mix or drop channels unless asked to
+// through monoDecoder; channel layout is otherwise handled in one place,
+// readAllSamples, which folds surround streams down.
 type AudioDecoder interface {
 	Read([]byte) (int, error)
 	SampleRate() int
@@ -78,22 +156,80 @@ type AudioDecoder interface {
 	Close() error
 }
 
+// sampleCounter is implemented by decoders whose container states the length
+// of the stream up front, as PCM formats and FLAC do. TotalSamples returns the
+// number of int16 samples Read yields across all channels, or false when the
+// length is unknown.
+type sampleCounter interface {
+	TotalSamples() (int, bool)
+}
+
+// frameSkipper is implemented by decoders that can jump ahead in the stream
+// without decoding, as uncompressed formats can by seeking past the PCM bytes.
+// SkipFrames must be called before the first Read and returns how many frames
+// were skipped, fewer if the stream ends first.
+type frameSkipper interface {
+	SkipFrames(frames int) (int, error)
+}
+
+// skipPCM seeks file past frames of the PCM chunk read through chunk, frameSize
+// bytes each, keeping chunk's remaining length in step
+func skipPCM(file *os.File, chunk io.Reader, frames, frameSize int) (int, error) {
+	limited, ok := chunk.(*io.LimitedReader)
+	if !ok || frameSize <= 0 {
+		return 0, nil
+	}
+
+	frames = min(frames, int(limited.N/int64(frameSize)))
+	n := int64(frames * frameSize)
+	if _, err := file.Seek(n, io.SeekCurrent); err != nil {
+		return 0, err
+	}
+	limited.N -= n
+	return frames, nil
+}
+
 // MP3Decoder wraps go-mp3 decoder
 type MP3Decoder struct {
-	decoder *mp3.Decoder
-	file    *os.File
+	decoder    *mp3.Decoder
+	file       *os.File
+	channels   int
+	stereo     []byte
+	replayGain float64
 }
 
 func (d *MP3Decoder) Read(buf []byte) (int, error) {
-	return d.decoder.Read(buf)
+	if d.channels == 2 {
+		return d.decoder.Read(buf)
+	}
+
+	// go-mp3 always decodes to stereo, duplicating mono frames into both channels; keep the left copy
+	need := len(buf) / 2 * 4
+	if cap(d.stereo) < need {
+		d.stereo = make([]byte, need)
+	}
+	n, err := io.ReadFull(d.decoder, d.stereo[:need])
+	for i := 0; i+3 < n; i += 4 {
+		buf[i/2], buf[i/2+1] = d.stereo[i], d.stereo[i+1]
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n / 4 * 2, err
 }
 
 func (d *MP3Decoder) SampleRate() int {
 	return d.decoder.SampleRate()
 }
 
+// NumChannels returns 1 for mono MP3s and 2 otherwise, as read from the first frame header
 func (d *MP3Decoder) NumChannels() int {
-	return 2 // MP3 is typically stereo
+	return d.channels
+}
+
+// ReplayGain returns the gain from the file's ID3 tag in dB, or 0 if untagged
+func (d *MP3Decoder) ReplayGain() float64 {
+	return d.replayGain
 }
 
 func (d *MP3Decoder) Close() error {
@@ -105,6 +241,24 @@ type WAVDecoder struct {
 	decoder *wav.Decoder
 	file    *os.File
 	buffer  *audio.IntBuffer
+	// float marks 32-bit IEEE float samples, which go-audio returns as raw bits
+	float bool
+	loops []LoopPoint
+}
+
+// TotalSamples returns the sample count given by the size of the data chunk
+func (d *WAVDecoder) TotalSamples() (int, bool) {
+	bytesPerSample := int(d.decoder.BitDepth) / 8
+	if d.decoder.PCMSize <= 0 || bytesPerSample <= 0 {
+		return 0, false
+	}
+	return d.decoder.PCMSize / bytesPerSample, true
+}
+
+// SkipFrames seeks past the first frames of the data chunk
+func (d *WAVDecoder) SkipFrames(frames int) (int, error) {
+	frameSize := (int(d.decoder.BitDepth)-1)/8 + 1
+	return skipPCM(d.file, d.decoder.PCMChunk.R, frames, frameSize*int(d.decoder.NumChans))
 }
 
 func (d *WAVDecoder) Read(buf []byte) (int, error) {
@@ -118,13 +272,21 @@ func (d *WAVDecoder) Read(buf []byte) (int, error) {
 		return 0, io.EOF
 	}
 
-	// Convert int samples to int16 bytes
+	// Convert int samples to int16 bytes; only the first n entries are fresh
+	bitDepth := int(d.decoder.BitDepth)
 	bytesWritten := 0
-	samples := d.buffer.Data
-	for i := 0; i < len(samples) && bytesWritten < len(buf)-1; i++ {
-		sample := int16(samples[i])
-		buf[bytesWritten] = byte(sample)
-		buf[bytesWritten+1] = byte(sample >> 8)
+	for _, v := range d.buffer.Data[:n] {
+		if bitDepth == 8 {
+			v -= 128 // 8-bit WAV is unsigned
+		}
+		if bytesWritten >= len(buf)-1 {
+			break
+		}
+		if d.float {
+			putInt16(buf[bytesWritten:], floatToPCM16(math.Float32frombits(uint32(v))))
+		} else {
+			putInt16(buf[bytesWritten:], pcmToInt16(v, bitDepth))
+		}
 		bytesWritten += 2
 	}
 
@@ -145,11 +307,23 @@ func (d *WAVDecoder) Close() error {
 
 // FLACDecoder wraps mewkiz/flac decoder
 type FLACDecoder struct {
-	stream   *flac.Stream
-	file     *os.File
-	buffer   []int32
-	pos      int
-	finished bool
+	stream     *flac.Stream
+	file       *os.File
+	buffer     []int32
+	pos        int
+	finished   bool
+	sampleRate int
+	nextRate   int
+	replayGain float64
+	mono       bool
+}
+
+// monoDecoder is implemented by decoders that can mix their channels down to
+// mono while decoding, which is cheaper than interleaving every channel only to
+// mix them afterwards. DecodeMono must be called before the first Read; from
+// then on NumChannels reports 1.
+type monoDecoder interface {
+	DecodeMono()
 }
 
 func (d *FLACDecoder) Read(buf []byte) (int, error) {
@@ -157,6 +331,12 @@ func (d *FLACDecoder) Read(buf []byte) (int, error) {
 		return 0, io.EOF
 	}
 
+	// Samples buffered from a frame at a new sample rate are reported from this read on
+	if d.nextRate != 0 {
+		d.sampleRate = d.nextRate
+		d.nextRate = 0
+	}
+
 	bytesWritten := 0
 
 	for bytesWritten < len(buf)-1 {
@@ -170,16 +350,28 @@ func (d *FLACDecoder) Read(buf []byte) (int, error) {
 				return bytesWritten, err
 			}
 
-			// Get samples from first channel (convert to mono for simplicity)
-			d.buffer = frame.Subframes[0].Samples
+			// FLAC stores each channel in its own subframe; interleave or mix them
+			if d.mono {
+				d.buffer = mixSubframes(d.buffer[:0], frame.Subframes)
+			} else {
+				d.buffer = interleaveSubframes(d.buffer[:0], frame.Subframes)
+			}
 			d.pos = 0
+
+			// Never mix sample rates within a single read
+			if rate := int(frame.SampleRate); rate > 0 && rate != d.sampleRate {
+				if bytesWritten > 0 {
+					d.nextRate = rate
+					return bytesWritten, nil
+				}
+				d.sampleRate = rate
+			}
 		}
 
 		// Convert samples to bytes
+		bitDepth := int(d.stream.Info.BitsPerSample)
 		for d.pos < len(d.buffer) && bytesWritten < len(buf)-1 {
-			sample := int16(d.buffer[d.pos])
-			buf[bytesWritten] = byte(sample)
-			buf[bytesWritten+1] = byte(sample >> 8)
+			putInt16(buf[bytesWritten:], pcmToInt16(int(d.buffer[d.pos]), bitDepth))
 			bytesWritten += 2
 			d.pos++
 		}
@@ -188,39 +380,100 @@ func (d *FLACDecoder) Read(buf []byte) (int, error) {
 	return bytesWritten, nil
 }
 
+// interleaveSubframes merges per-channel FLAC subframes into interleaved frames, reusing dst.
+// Every channel is kept, so content panned to any side shows up in the waveform. A
+// malformed last frame may hold subframes of differing lengths; the longest sets the
+// frame count and shorter channels are padded with silence to keep frames aligned.
+func interleaveSubframes(dst []int32, subframes []*frame.Subframe) []int32 {
+	n := 0
+	for _, subframe := range subframes {
+		n = max(n, len(subframe.Samples))
+	}
+
+	for i := 0; i < n; i++ {
+		for _, subframe := range subframes {
+			var s int32
+			if i < len(subframe.Samples) {
+				s = subframe.Samples[i]
+			}
+			dst = append(dst, s)
+		}
+	}
+	return dst
+}
+
+// mixSubframes appends the mono mix of subframes to dst, weighting the
+// channels the way downmixSurround does. Short subframes count as silence.
+func mixSubframes(dst []int32, subframes []*frame.Subframe) []int32 {
+	n := 0
+	for _, subframe := range subframes {
+		n = max(n, len(subframe.Samples))
+	}
+
+	weights := mixWeights(len(subframes))
+	for i := 0; i < n; i++ {
+		var sum float64
+		for c, subframe := range subframes {
+			if i < len(subframe.Samples) {
+				sum += float64(subframe.Samples[i]) * weights[c]
+			}
+		}
+		dst = append(dst, int32(math.Round(sum)))
+	}
+	return dst
+}
+
 func (d *FLACDecoder) SampleRate() int {
-	return int(d.stream.Info.SampleRate)
+	return d.sampleRate
 }
 
 func (d *FLACDecoder) NumChannels() int {
+	if d.mono {
+		return 1
+	}
 	return int(d.stream.Info.NChannels)
 }
 
+// TotalSamples returns the sample count given by STREAMINFO, which encoders
+// may leave at 0 for unknown
+func (d *FLACDecoder) TotalSamples() (int, bool) {
+	if d.stream.Info.NSamples == 0 {
+		return 0, false
+	}
+	return int(d.stream.Info.NSamples) * d.NumChannels(), true
+}
+
+// DecodeMono makes Read mix the channels of each frame into one
+func (d *FLACDecoder) DecodeMono() {
+	d.mono = true
+}
+
+// ReplayGain returns the gain from the file's Vorbis comments in dB, or 0 if untagged
+func (d *FLACDecoder) ReplayGain() float64 {
+	return d.replayGain
+}
+
 func (d *FLACDecoder) Close() error {
 	return d.file.Close()
 }
 
 // OGGDecoder wraps jfreymuth/oggvorbis decoder
 type OGGDecoder struct {
-	reader *oggvorbis.Reader
-	file   *os.File
-	format *oggvorbis.Format
+	reader     *oggvorbis.Reader
+	file       *os.File
+	replayGain float64
 }
 
 func (d *OGGDecoder) Read(buf []byte) (int, error) {
 	// Read float32 samples
 	floatBuf := make([]float32, len(buf)/4) // Assuming stereo, 2 bytes per sample
 	n, err := d.reader.Read(floatBuf)
-	if err != nil {
-		return 0, err
-	}
 
-	// Convert float32 to int16 bytes
+	// Convert float32 to int16 bytes; Vorbis output is already interleaved
 	bytesWritten := 0
 	for i := 0; i < n && bytesWritten < len(buf)-1; i++ {
-		sample := int16(floatBuf[i] * 32767)
-		buf[bytesWritten] = byte(sample)
-		buf[bytesWritten+1] = byte(sample >> 8)
+		v := math.Max(-1, math.Min(1, float64(floatBuf[i])))
+		putInt16(buf[bytesWritten:], int16(v*32767))
 		bytesWritten += 2
 	}
 
@@ -228,11 +481,16 @@ func (d *OGGDecoder) Read(buf []byte) (int, error) {
 }
 
 func (d *OGGDecoder) SampleRate() int {
-	return d.format.SampleRate
+	return d.reader.SampleRate()
 }
 
 func (d *OGGDecoder) NumChannels() int {
-	return d.format.Channels
+	return d.reader.Channels()
+}
+
+// ReplayGain returns the gain from the file's Vorbis comments in dB, or 0 if untagged
+func (d *OGGDecoder) ReplayGain() float64 {
+	return d.replayGain
 }
 
 func (d *OGGDecoder) Close() error {
@@ -244,6 +502,30 @@ type AIFFDecoder struct {
 	decoder *aiff.Decoder
 	file    *os.File
 	buffer  *audio.IntBuffer
+	// float marks AIFF-C fl32 samples, which go-audio returns as raw bits
+	float bool
+}
+
+// TotalSamples returns the sample count given by the COMM chunk
+func (d *AIFFDecoder) TotalSamples() (int, bool) {
+	if d.decoder.NumSampleFrames == 0 || d.decoder.NumChans == 0 {
+		return 0, false
+	}
+	return int(d.decoder.NumSampleFrames) * int(d.decoder.NumChans), true
+}
+
+// SkipFrames seeks past the first frames of the SSND chunk
+func (d *AIFFDecoder) SkipFrames(frames int) (int, error) {
+	if !d.decoder.WasPCMAccessed() {
+		if err := d.decoder.FwdToPCM(); err != nil {
+			return 0, err
+		}
+	}
+	if d.decoder.PCMChunk == nil {
+		return 0, nil
+	}
+	frameSize := (int(d.decoder.BitDepth)-1)/8 + 1
+	return skipPCM(d.file, d.decoder.PCMChunk.R, frames, frameSize*int(d.decoder.NumChans))
 }
 
 func (d *AIFFDecoder) Read(buf []byte) (int, error) {
@@ -257,13 +539,21 @@ func (d *AIFFDecoder) Read(buf []byte) (int, error) {
 		return 0, io.EOF
 	}
 
-	// Convert int samples to int16 bytes
+	// Convert int samples to int16 bytes; only the first n entries are fresh
+	bitDepth := int(d.decoder.BitDepth)
 	bytesWritten := 0
-	samples := d.buffer.Data
-	for i := 0; i < len(samples) && bytesWritten < len(buf)-1; i++ {
-		sample := int16(samples[i])
-		buf[bytesWritten] = byte(sample)
-		buf[bytesWritten+1] = byte(sample >> 8)
+	for _, v := range d.buffer.Data[:n] {
+		if bitDepth == 8 {
+			v = int(int8(v)) // 8-bit AIFF is signed but decoded as an unsigned byte
+		}
+		if bytesWritten >= len(buf)-1 {
+			break
+		}
+		if d.float {
+			putInt16(buf[bytesWritten:], floatToPCM16(math.Float32frombits(uint32(v))))
+		} else {
+			putInt16(buf[bytesWritten:], pcmToInt16(v, bitDepth))
+		}
 		bytesWritten += 2
 	}
 
@@ -282,10 +572,21 @@ func (d *AIFFDecoder) Close() error {
 	return d.file.Close()
 }
 
-// OpusDecoder wraps pion/opus decoder
+// OpusDecoder wraps pion/opus decoder, reading packets from the Ogg container.
+// pion/opus only decodes mono SILK frames, so music encoded with CELT fails to decode.
 type OpusDecoder struct {
 	decoder  opus.Decoder
+	ogg      *oggreader.OggReader
 	file     *os.File
+	channels int
+	preSkip  int
+	// skipTotal is the header's pre-skip, by which granule positions run ahead of the output
+	skipTotal int64
+	// end is the number of samples to output per the last granule position seen, -1 if none yet
+	end      int64
+	decoded  int64
+	packets  [][]byte
+	partial  []byte
 	buffer   []int16
 	pos      int
 	finished bool
@@ -301,9 +602,7 @@ func (d *OpusDecoder) Read(buf []byte) (int, error) {
 	for bytesWritten < len(buf)-1 {
 		// If we need more samples, decode next packet
 		if d.pos >= len(d.buffer) {
-			// Read opus packet from file (this is simplified - real Opus files need proper packet parsing)
-			packet := make([]byte, 1024)
-			n, err := d.file.Read(packet)
+			packet, err := d.nextPacket()
 			if err != nil {
 				if err == io.EOF {
 					d.finished = true
@@ -311,28 +610,42 @@ func (d *OpusDecoder) Read(buf []byte) (int, error) {
 				return bytesWritten, err
 			}
 
-			// Decode Opus packet to PCM
-			pcmOut := make([]byte, 4096) // Output buffer for PCM data
-			_, _, err = d.decoder.Decode(packet[:n], pcmOut)
+			pcmOut := make([]byte, opusFrameBytes)
+			bandwidth, isStereo, err := d.decoder.Decode(packet, pcmOut)
 			if err != nil {
 				return bytesWritten, err
 			}
+			channels := 1
+			if isStereo {
+				channels = 2
+			}
+			if channels != d.channels {
+				return bytesWritten, fmt.Errorf("Opus packet has %d channels, stream has %d", channels, d.channels)
+			}
 
-			// Convert bytes to int16 samples
-			samples := make([]int16, len(pcmOut)/2)
-			for i := 0; i < len(pcmOut)-1; i += 2 {
-				samples[i/2] = int16(pcmOut[i]) | int16(pcmOut[i+1])<<8
+			samples, err := opusFrame(pcmOut, bandwidth)
+			if err != nil {
+				return bytesWritten, err
 			}
 
+			// The encoder's lookahead is padded at the start of the stream; drop it
+			skip := min(d.preSkip, len(samples))
+			d.preSkip -= skip
+			samples = samples[skip:]
+
+			// The last frame is padded too; the granule position says where the audio ends
+			if d.end >= 0 && d.decoded+int64(len(samples)) > d.end {
+				samples = samples[:max(d.end-d.decoded, 0)]
+			}
+			d.decoded += int64(len(samples))
+
 			d.buffer = samples
 			d.pos = 0
 		}
 
 		// Convert samples to bytes
 		for d.pos < len(d.buffer) && bytesWritten < len(buf)-1 {
-			sample := d.buffer[d.pos]
-			buf[bytesWritten] = byte(sample)
-			buf[bytesWritten+1] = byte(sample >> 8)
+			putInt16(buf[bytesWritten:], d.buffer[d.pos])
 			bytesWritten += 2
 			d.pos++
 		}
@@ -341,37 +654,206 @@ func (d *OpusDecoder) Read(buf []byte) (int, error) {
 	return bytesWritten, nil
 }
 
+// opusFrameBytes is the size of one decoded frame from pion/opus: 20ms of
+// 16-bit mono PCM at 48kHz
+const opusFrameBytes = 960 * 2
+
+// opusFrame returns a 20ms mono frame decoded by pion/opus into pcm at 48kHz.
+// pion/opus decodes SILK at the rate of its bandwidth and writes each sample
+// three times, which only reaches 48kHz for wideband; the rest of pcm is left
+// over from earlier packets. Each sample is held for as long as it lasts at
+// 48kHz instead, so pre-skip and granule positions, which count 48kHz
+// samples, line up with the output whatever the bandwidth.
+func opusFrame(pcm []byte, bandwidth opus.Bandwidth) ([]int16, error) {
+	rate := bandwidth.SampleRate()
+	if rate == 0 || 48000%rate != 0 {
+		return nil, fmt.Errorf("unsupported Opus bandwidth of %d Hz", rate)
+	}
+
+	repeat := 48000 / rate
+	samples := make([]int16, 0, opusFrameBytes/2)
+	for i := 0; i < rate/50 && 6*i+1 < len(pcm); i++ {
+		s := int16(pcm[6*i]) | int16(pcm[6*i+1])<<8
+		for range repeat {
+			samples = append(samples, s)
+		}
+	}
+	return samples, nil
+}
+
+// nextPacket returns the next audio packet of the stream, skipping the comment header
+func (d *OpusDecoder) nextPacket() ([]byte, error) {
+	for {
+		for len(d.packets) == 0 {
+			segments, header, err := d.ogg.ParseNextPage()
+			if err != nil {
+				if err == io.ErrUnexpectedEOF {
+					err = io.EOF
+				}
+				return nil, err
+			}
+			d.packets, d.partial = oggPackets(d.packets, d.partial, segments)
+
+			// Granule positions count 48kHz samples, including the pre-skip, up to the
+			// last packet finished on the page. Pages finishing none carry -1, and the
+			// comment header page carries 0.
+			if granule := header.GranulePosition; granule != math.MaxUint64 && granule > 0 {
+				d.end = int64(granule) - d.skipTotal
+			}
+		}
+
+		packet := d.packets[0]
+		d.packets = d.packets[1:]
+		if !bytes.HasPrefix(packet, []byte("OpusTags")) {
+			return packet, nil
+		}
+	}
+}
+
+// oggPackets reassembles packets from the lacing segments of an Ogg page. A
+// segment of 255 bytes continues into the next one, possibly on the next page,
+// so an unfinished packet is returned as partial to be carried over.
+func oggPackets(packets [][]byte, partial []byte, segments [][]byte) ([][]byte, []byte) {
+	for _, segment := range segments {
+		partial = append(partial, segment...)
+		if len(segment) < 255 {
+			packets = append(packets, partial)
+			partial = nil
+		}
+	}
+	return packets, partial
+}
+
 func (d *OpusDecoder) SampleRate() int {
-	return 48000 // Opus native sample rate
+	return 48000 // Opus always decodes at 48kHz, whatever the input rate in its header
 }
 
 func (d *OpusDecoder) NumChannels() int {
-	return 1 // Simplified to mono for now
+	return d.channels
 }
 
 func (d *OpusDecoder) Close() error {
 	return d.file.Close()
 }
 
-// NewAudioDecoder creates a new audio decoder based on the file format
-func NewAudioDecoder(filename string) (AudioDecoder, error) {
-	format := DetectFormat(filename)
+// pcmToInt16 scales a signed PCM sample of the given bit depth to 16 bits. The
+// shift scales 32-bit samples by 1/2^16, so they reach the same ±1.0 full scale
+// as 16-bit ones once normalized by 1/32768. The math stays in 64 bits and
+// values beyond the bit depth clip instead of wrapping around.
+func pcmToInt16(v, bitDepth int) int16 {
+	s := int64(v)
+	switch {
+	case bitDepth > 16:
+		s >>= bitDepth - 16
+	case bitDepth > 0 && bitDepth < 16:
+		s <<= 16 - bitDepth
+	}
+	return int16(max(math.MinInt16, min(math.MaxInt16, s)))
+}
+
+// floatToPCM16 scales a float sample, full scale at ±1.0, to 16 bits, clipping
+// anything beyond full scale
+func floatToPCM16(f float32) int16 {
+	v := math.Round(float64(f) * 32767)
+	return int16(math.Max(-32768, math.Min(32767, v)))
+}
+
+// putInt16 writes s to the first two bytes of buf in little-endian order
+func putInt16(buf []byte, s int16) {
+	buf[0] = byte(s)
+	buf[1] = byte(s >> 8)
+}
+
+// pcmReaderDecoder adapts a reader of raw 16-bit little-endian PCM to the
+// decoder contract. Reads always return whole samples; a byte left over from
+// one read is carried into the next.
+type pcmReaderDecoder struct {
+	r          io.Reader
+	sampleRate int
+	channels   int
+	carry      []byte
+	total      int64
+}
+
+func (d *pcmReaderDecoder) Read(buf []byte) (int, error) {
+	if len(buf) < 2 {
+		return 0, io.ErrShortBuffer
+	}
+
+	n := copy(buf, d.carry)
+	d.carry = d.carry[:0]
+
+	var err error
+	for n < 2 && err == nil {
+		var m int
+		m, err = d.r.Read(buf[n:])
+		n += m
+		d.total += int64(m)
+	}
+
+	if n%2 == 1 {
+		n--
+		d.carry = append(d.carry, buf[n])
+	}
+	if err == io.EOF && len(d.carry) > 0 {
+		return n, fmt.Errorf("truncated PCM stream: %d bytes is not a whole number of 16-bit samples", d.total)
+	}
+	return n, err
+}
 
+func (d *pcmReaderDecoder) SampleRate() int {
+	return d.sampleRate
+}
+
+func (d *pcmReaderDecoder) NumChannels() int {
+	return d.channels
+}
+
+func (d *pcmReaderDecoder) Close() error {
+	return nil
+}
+
+// NewAudioDecoder creates a new audio decoder based on the file format, detected
+// from the file's magic bytes or, failing that, its extension
+func NewAudioDecoder(filename string) (AudioDecoder, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 
+	// Trust the content over the extension, which may be missing or wrong
+	format := DetectFormatReader(file)
+	if format == FormatUnknown {
+		format = DetectFormat(filename)
+	}
+
 	switch format {
 	case FormatMP3:
+		// go-mp3 skips the ID3 tag itself, so read it first and rewind
+		gain := replayGainFromTags(id3Tags(file))
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		channels := mp3Channels(file)
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
 		decoder, err := mp3.NewDecoder(file)
 		if err != nil {
 			file.Close()
 			return nil, err
 		}
-		return &MP3Decoder{decoder: decoder, file: file}, nil
+		return &MP3Decoder{decoder: decoder, file: file, channels: channels, replayGain: gain}, nil
 
 	case FormatWAV:
+		// Loop points are an extra; a damaged smpl chunk shouldn't keep the audio from decoding
+		loops, _ := readWAVLoops(file)
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
 		decoder := wav.NewDecoder(file)
 		if !decoder.IsValidFile() {
 			file.Close()
@@ -385,7 +867,17 @@ func NewAudioDecoder(filename string) (AudioDecoder, error) {
 			},
 			Data: make([]int, 1024), // Initial buffer size
 		}
-		return &WAVDecoder{decoder: decoder, file: file, buffer: buffer}, nil
+		float := decoder.WavAudioFormat == wavFormatFloat
+		if float && decoder.BitDepth != 32 {
+			file.Close()
+			return nil, fmt.Errorf("unsupported %d-bit float WAV", decoder.BitDepth)
+		}
+		// Find the data chunk now so its size is known before the first Read
+		if err := decoder.FwdToPCM(); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("invalid WAV file: %w", err)
+		}
+		return &WAVDecoder{decoder: decoder, file: file, buffer: buffer, float: float, loops: loops}, nil
 
 	case FormatFLAC:
 		stream, err := flac.Parse(file)
@@ -394,11 +886,13 @@ func NewAudioDecoder(filename string) (AudioDecoder, error) {
 			return nil, err
 		}
 		return &FLACDecoder{
-			stream:   stream,
-			file:     file,
-			buffer:   make([]int32, 0),
-			pos:      0,
-			finished: false,
+			stream:     stream,
+			file:       file,
+			buffer:     make([]int32, 0),
+			pos:        0,
+			finished:   false,
+			sampleRate: int(stream.Info.SampleRate),
+			replayGain: flacReplayGain(stream),
 		}, nil
 
 	case FormatOGG:
@@ -407,16 +901,14 @@ func NewAudioDecoder(filename string) (AudioDecoder, error) {
 			file.Close()
 			return nil, err
 		}
-		format, err := oggvorbis.GetFormat(file)
-		if err != nil {
-			file.Close()
-			return nil, err
-		}
-		return &OGGDecoder{reader: reader, file: file, format: format}, nil
+		gain := replayGainFromTags(vorbisCommentTags(reader.CommentHeader().Comments))
+		return &OGGDecoder{reader: reader, file: file, replayGain: gain}, nil
 
 	case FormatAIFF:
 		decoder := aiff.NewDecoder(file)
-		if !decoder.IsValidFile() {
+		// go-audio only accepts integer encodings, but reads 32-bit float samples fine as raw bits
+		float := aiffFloat(decoder)
+		if !float && !decoder.IsValidFile() {
 			file.Close()
 			return nil, fmt.Errorf("invalid AIFF file")
 		}
@@ -428,16 +920,26 @@ func NewAudioDecoder(filename string) (AudioDecoder, error) {
 			},
 			Data: make([]int, 1024), // Initial buffer size
 		}
-		return &AIFFDecoder{decoder: decoder, file: file, buffer: buffer}, nil
+		return &AIFFDecoder{decoder: decoder, file: file, buffer: buffer, float: float}, nil
 
 	case FormatOpus:
-		decoder := opus.NewDecoder()
+		ogg, header, err := oggreader.NewWith(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("invalid Opus file: %w", err)
+		}
+		if header.Channels != 1 {
+			file.Close()
+			return nil, fmt.Errorf("decoding %d-channel Opus is not supported, only mono", header.Channels)
+		}
 		return &OpusDecoder{
-			decoder:  decoder,
-			file:     file,
-			buffer:   make([]int16, 0),
-			pos:      0,
-			finished: false,
+			decoder:   opus.NewDecoder(),
+			ogg:       ogg,
+			file:      file,
+			channels:  int(header.Channels),
+			preSkip:   int(header.PreSkip),
+			skipTotal: int64(header.PreSkip),
+			end:       -1,
 		}, nil
 
 	default:
@@ -446,38 +948,401 @@ func NewAudioDecoder(filename string) (AudioDecoder, error) {
 	}
 }
 
-// readSamplesFromFormat reads audio samples from any supported format
-func readSamplesFromFormat(path string) ([]int16, error) {
+// wavFormatFloat is the WAV format tag of IEEE float samples
+const wavFormatFloat = 3
+
+// aiffFloat reports whether the decoder holds a well-formed AIFF-C file of 32-bit float samples
+func aiffFloat(decoder *aiff.Decoder) bool {
+	decoder.ReadInfo()
+	if decoder.Err() != nil || decoder.NumChans < 1 || decoder.SampleRate == 0 || decoder.BitDepth != 32 {
+		return false
+	}
+	switch string(decoder.Encoding[:]) {
+	case "fl32", "FL32":
+		return true
+	}
+	return false
+}
+
+// mp3Channels reads the channel mode from the first MPEG audio frame header after
+// any ID3v2 tag, returning 1 for mono and 2 for every stereo mode. It assumes
+// stereo if no frame header turns up near the start of the file.
+func mp3Channels(r io.Reader) int {
+	data := make([]byte, mp3ScanBytes)
+	n, _ := io.ReadFull(r, data)
+	data = data[:n]
+
+	if len(data) >= 10 && string(data[:3]) == "ID3" {
+		skip := 10 + syncsafe(data[6:10])
+		if data[5]&0x10 != 0 {
+			skip += 10 // footer
+		}
+		if skip > len(data) {
+			// A large tag, e.g. with cover art; read on past it
+			rest := make([]byte, mp3ScanBytes)
+			if _, err := io.CopyN(io.Discard, r, int64(skip-len(data))); err != nil {
+				return 2
+			}
+			n, _ := io.ReadFull(r, rest)
+			data, skip = rest[:n], 0
+		}
+		data = data[skip:]
+	}
+
+	for i := 0; i+3 < len(data); i++ {
+		b1, b2 := data[i+1], data[i+2]
+		// Frame sync, a valid version and layer, and a usable bitrate and sample rate index
+		if data[i] != 0xFF || b1&0xE0 != 0xE0 || b1&0x18 == 0x08 || b1&0x06 == 0 || b2&0xF0 == 0xF0 || b2&0x0C == 0x0C {
+			continue
+		}
+		if data[i+3]>>6 == 3 {
+			return 1
+		}
+		return 2
+	}
+	return 2
+}
+
+// mp3ScanBytes is how far past the ID3 tag mp3Channels looks for a frame header
+const mp3ScanBytes = 16 * 1024
+
+// flacReplayGain reads the gain from the Vorbis comment blocks of a parsed FLAC stream
+func flacReplayGain(stream *flac.Stream) float64 {
+	var tags [][2]string
+	for _, block := range stream.Blocks {
+		if comment, ok := block.Body.(*meta.VorbisComment); ok {
+			tags = append(tags, comment.Tags...)
+		}
+	}
+	return replayGainFromTags(tags)
+}
+
+// streamInfo describes the layout of decoded PCM samples
+type streamInfo struct {
+	sampleRate int
+	channels   int
+	// replayGain is the gain in dB from the file's ReplayGain tags, 0 if untagged
+	replayGain float64
+	// loops are the file's loop points, in frames at sampleRate
+	loops []LoopPoint
+}
+
+// duration returns the playback length of n interleaved samples. The decoders
+// emit one int16 per channel per frame, so the sample count must be divided by
+// the channel count before mapping it to time.
+func (si streamInfo) duration(n int) time.Duration {
+	if si.sampleRate <= 0 || si.channels <= 0 {
+		return 0
+	}
+	frames := n / si.channels
+	return time.Duration(frames) * time.Second / time.Duration(si.sampleRate)
+}
+
+// DecodeSamples decodes a whole audio file of any supported format into
+// interleaved 16-bit PCM, for processing of your own without a waveform.
+// Streams that change sample rate mid-way are resampled to the rate they start
+// with, and surround audio is folded down to mono. Stereo stays interleaved in
+// two channels; waveforms average them only once the bars are computed.
+func DecodeSamples(filename string) (samples []int16, sampleRate int, channels int, err error) {
+	samples, info, err := readSamplesFromFormat(context.Background(), filename, 0, 0, ChannelMix, false, nil)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return samples, info.sampleRate, info.channels, nil
+}
+
+// estimateSamples returns the number of samples decoder will deliver from path:
+// the stated length where the container has one, otherwise a rough estimate
+// from the file size
+func estimateSamples(decoder AudioDecoder, path string) int {
+	if counter, ok := decoder.(sampleCounter); ok {
+		if samples, known := counter.TotalSamples(); known {
+			return samples
+		}
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return int(fileInfo.Size() / 4)
+}
+
+// readSamplesFromFormat reads the audio between start and end from any
+// supported format, see readAllSamples, and keeps only the selected channel
+func readSamplesFromFormat(ctx context.Context, path string, start, end time.Duration, channel Channel, mono bool, p *progress) ([]int16, streamInfo, error) {
 	decoder, err := NewAudioDecoder(path)
 	if err != nil {
-		return nil, err
+		return nil, streamInfo{}, err
 	}
 	defer decoder.Close()
 
-	// Estimate capacity based on file size
-	fileInfo, _ := os.Stat(path)
-	estimatedSamples := int(fileInfo.Size() / 4) // Rough estimate
+	if mono {
+		requestMono(decoder)
+	}
+
+	samples, info, err := readAllSamples(ctx, decoder, estimateSamples(decoder, path), start, end, p)
+	if err != nil {
+		return nil, streamInfo{}, err
+	}
+
+	// Decoders that couldn't mix natively are mixed down here instead
+	if mono {
+		samples, info = mixToMono(samples, info)
+	}
+
+	return selectChannel(samples, info, channel)
+}
+
+// requestMono asks decoder to decode straight to mono if it supports that
+func requestMono(decoder AudioDecoder) {
+	if d, ok := decoder.(monoDecoder); ok {
+		d.DecodeMono()
+	}
+}
+
+// mixToMono mixes interleaved samples of any channel count down to mono
+func mixToMono(samples []int16, info streamInfo) ([]int16, streamInfo) {
+	if info.channels <= 1 {
+		return samples, info
+	}
+	mono := downmixSurround(samples, info.channels)
+	info.channels = 1
+	return mono, info
+}
+
+// selectChannel de-interleaves the selected channel from samples. Mixing keeps
+// the interleaved samples as they are, for StereoSplit and the exports that
+// need every channel; computePeaks averages them to mono when the bars are
+// computed. Any choice for mono audio keeps the samples as well. A
+// trailing partial frame, left by a stream cut off mid-frame, is dropped so the
+// channels can't swap places.
+func selectChannel(samples []int16, info streamInfo, channel Channel) ([]int16, streamInfo, error) {
+	var index int
+	switch channel {
+	case ChannelMix, "":
+		return samples, info, nil
+	case ChannelLeft:
+		index = 0
+	case ChannelRight:
+		index = 1
+	default:
+		return nil, streamInfo{}, fmt.Errorf("unknown channel %q (must be mix, left or right)", channel)
+	}
+
+	if info.channels < 2 {
+		return samples, info, nil
+	}
+
+	frames := len(samples) / info.channels
+	out := make([]int16, frames)
+	for i := range out {
+		out[i] = samples[i*info.channels+index]
+	}
+
+	info.channels = 1
+	return out, info, nil
+}
+
+// readAllSamples drains a decoder into a single PCM buffer. Streams that change
+// sample rate mid-way (e.g. concatenated segments) are resampled segment by
+// segment to the rate the stream started with, keeping the time axis linear.
+// Decoding starts start into the stream, seeking there where the decoder can
+// and discarding the audio before it otherwise, and stops early at end unless
+// that is zero. Loop points are shifted along with the start. Progress through
+// the file is reported to p. Once ctx is done it gives up, returning ctx.Err().
+func readAllSamples(ctx context.Context, decoder AudioDecoder, estimatedSamples int, start, end time.Duration, p *progress) ([]int16, streamInfo, error) {
+	info := streamInfo{
+		sampleRate: decoder.SampleRate(),
+		channels:   decoder.NumChannels(),
+	}
+	if g, ok := decoder.(replayGainer); ok {
+		info.replayGain = g.ReplayGain()
+	}
+	if l, ok := decoder.(loopPointer); ok {
+		info.loops = l.LoopPoints()
+	}
+
+	skip, err := skipToStart(decoder, info, start)
+	if err != nil {
+		return nil, streamInfo{}, err
+	}
+	estimatedSamples = max(estimatedSamples-skip, 0)
+
+	limit := 0
+	if end > 0 && info.sampleRate > 0 && info.channels > 0 {
+		limit = samplesIn(end-start, info)
+		if estimatedSamples > limit {
+			estimatedSamples = limit
+		}
+	}
+
+	p.track(decoder)
 	pcm := make([]int16, 0, estimatedSamples)
+	segmentStart := 0
+	segmentRate := info.sampleRate
 
 	const bufferSize = 32768
 	buf := make([]byte, bufferSize)
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, streamInfo{}, err
+		}
+
 		n, err := decoder.Read(buf)
-		if n == 0 || err != nil {
-			if err != io.EOF {
-				return nil, err
-			}
+		if err != nil && err != io.EOF {
+			return nil, streamInfo{}, err
+		}
+
+		// Like any io.Reader, the final read may return data together with io.EOF
+		if n == 0 {
 			break
 		}
 
+		// A new sample rate starts a new segment; bring the finished one onto the common rate
+		if rate := decoder.SampleRate(); rate > 0 && rate != segmentRate {
+			pcm = append(pcm[:segmentStart], resampleLinear(pcm[segmentStart:], info.channels, segmentRate, info.sampleRate)...)
+			segmentStart = len(pcm)
+			segmentRate = rate
+		}
+
 		// Convert bytes to int16 samples
 		samples := make([]int16, n/2)
 		for i := 0; i < n-1; i += 2 {
 			samples[i/2] = int16(buf[i]) | int16(buf[i+1])<<8
 		}
+		if skip > 0 {
+			discard := min(skip, len(samples))
+			samples = samples[discard:]
+			skip -= discard
+		}
 		pcm = append(pcm, samples...)
+		p.decoded()
+
+		// Segments at other rates are resampled afterwards, so the cut is approximate for them
+		if limit > 0 && len(pcm) >= limit {
+			pcm = pcm[:limit]
+			break
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if segmentRate != info.sampleRate {
+		pcm = append(pcm[:segmentStart], resampleLinear(pcm[segmentStart:], info.channels, segmentRate, info.sampleRate)...)
+	}
+
+	if start > 0 && len(pcm) == 0 {
+		return nil, streamInfo{}, fmt.Errorf("start time %s is past the end of the audio", start)
+	}
+	if start > 0 && len(info.loops) > 0 {
+		info.loops = shiftLoops(info.loops, samplesIn(start, info)/info.channels)
+	}
+
+	// Surround streams are folded down so every channel contributes with its intended weight
+	if info.channels > 2 {
+		pcm = downmixSurround(pcm, info.channels)
+		info.channels = 1
+	}
+
+	return pcm, info, nil
+}
+
+// samplesIn returns the number of interleaved samples making up d of audio
+func samplesIn(d time.Duration, info streamInfo) int {
+	return int(int64(info.sampleRate)*int64(d)/int64(time.Second)) * info.channels
+}
+
+// skipToStart moves decoder start into the stream, seeking past as much as it
+// can, and returns the number of samples that are left to be decoded and
+// discarded before start
+func skipToStart(decoder AudioDecoder, info streamInfo, start time.Duration) (int, error) {
+	if start <= 0 || info.sampleRate <= 0 || info.channels <= 0 {
+		return 0, nil
+	}
+
+	frames := samplesIn(start, info) / info.channels
+	if s, ok := decoder.(frameSkipper); ok {
+		skipped, err := s.SkipFrames(frames)
+		if err != nil {
+			return 0, fmt.Errorf("seeking to %s: %w", start, err)
+		}
+		frames -= skipped
 	}
+	return frames * info.channels, nil
+}
+
+// resampleLinear converts interleaved samples from one sample rate to another
+// using linear interpolation between neighbouring frames
+func resampleLinear(samples []int16, channels, from, to int) []int16 {
+	if from <= 0 || to <= 0 || from == to || len(samples) == 0 {
+		return samples
+	}
+	if channels <= 0 {
+		channels = 1
+	}
+
+	frames := len(samples) / channels
+	outFrames := int(int64(frames) * int64(to) / int64(from))
+	out := make([]int16, outFrames*channels)
+	step := float64(from) / float64(to)
+
+	for i := 0; i < outFrames; i++ {
+		pos := float64(i) * step
+		idx := int(pos)
+		frac := pos - float64(idx)
+		next := idx + 1
+		if next >= frames {
+			next = frames - 1
+		}
 
-	return pcm, nil
+		for c := 0; c < channels; c++ {
+			a := float64(samples[idx*channels+c])
+			b := float64(samples[next*channels+c])
+			out[i*channels+c] = int16(a + (b-a)*frac)
+		}
+	}
+
+	return out
+}
+
+// surroundWeights are the ITU-R BS.775 downmix coefficients for 5.1 in the
+// standard FL, FR, FC, LFE, BL, BR order, folded to mono as (Lo+Ro)/2 where
+// Lo = L + 0.707*C + 0.707*Ls. Center and surrounds sit 3dB below the fronts
+// and the LFE is dropped.
+var surroundWeights = [6]float64{0.5, 0.5, math.Sqrt2 / 2, 0, math.Sqrt2 / 4, math.Sqrt2 / 4}
+
+// mixWeights returns the weight of each channel in a mono mix: the BS.775
+// coefficients for 5.1 and an equal-weight average for other layouts
+func mixWeights(channels int) []float64 {
+	weights := make([]float64, channels)
+	if channels == len(surroundWeights) {
+		copy(weights, surroundWeights[:])
+	} else {
+		for c := range weights {
+			weights[c] = 1 / float64(channels)
+		}
+	}
+	return weights
+}
+
+// downmixSurround mixes interleaved multichannel samples down to mono. 5.1 uses
+// the BS.775 coefficients; other layouts fall back to an equal-weight average.
+func downmixSurround(samples []int16, channels int) []int16 {
+	if channels <= 1 {
+		return samples
+	}
+
+	weights := mixWeights(channels)
+	out := make([]int16, len(samples)/channels)
+	for i := range out {
+		var sum float64
+		for c, w := range weights {
+			sum += float64(samples[i*channels+c]) * w
+		}
+		out[i] = int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(sum))))
+	}
+	return out
 }
@@ -0,0 +1,2304 @@
+package waveform
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/go-audio/aiff"
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+	"github.com/pion/opus"
+	"github.com/pion/opus/pkg/oggreader"
+)
+
+// writeSilentMP3 writes an MPEG-1 Layer III stream of silent 128kbps, 44.1kHz
+// stereo frames. Each frame holds 1152 samples per channel.
+func writeSilentMP3(t *testing.T, path string, frames int) {
+	t.Helper()
+	writeMP3Frames(t, path, frames, 0x04)
+}
+
+// writeMP3Frames writes silent 128kbps, 44.1kHz MPEG-1 Layer III frames whose
+// fourth header byte, holding the channel mode, is mode
+func writeMP3Frames(t *testing.T, path string, frames int, mode byte) {
+	t.Helper()
+
+	const frameSize = 144 * 128000 / 44100
+	frame := make([]byte, frameSize)
+	frame[0], frame[1], frame[2], frame[3] = 0xFF, 0xFB, 0x90, mode
+
+	data := make([]byte, 0, frameSize*frames)
+	for i := 0; i < frames; i++ {
+		data = append(data, frame...)
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write MP3 fixture: %v", err)
+	}
+}
+
+func TestMP3Duration(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "silence.mp3")
+	frames := 100
+	writeSilentMP3(t, filename, frames)
+
+	w, err := NewFromAudioFile(filename, nil)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+
+	expected := time.Duration(frames*1152) * time.Second / 44100
+	if diff := w.Duration() - expected; diff < -time.Millisecond || diff > time.Millisecond {
+		t.Errorf("Expected duration %v, got %v", expected, w.Duration())
+	}
+}
+
+func TestMP3MonoChannels(t *testing.T) {
+	dir := t.TempDir()
+	mono := filepath.Join(dir, "mono.mp3")
+	writeMP3Frames(t, mono, 20, 0xC4) // channel mode 3: single channel
+	stereo := filepath.Join(dir, "stereo.mp3")
+	writeSilentMP3(t, stereo, 20)
+
+	for filename, channels := range map[string]int{mono: 1, stereo: 2} {
+		decoder, err := NewAudioDecoder(filename)
+		if err != nil {
+			t.Fatalf("NewAudioDecoder failed: %v", err)
+		}
+		if got := decoder.NumChannels(); got != channels {
+			t.Errorf("%s: expected %d channels, got %d", filepath.Base(filename), channels, got)
+		}
+		decoder.Close()
+
+		// One sample per channel per frame position, whatever the layout
+		config := DefaultConfig()
+		config.RetainSamples = true
+		w, err := NewFromAudioFile(filename, config)
+		if err != nil {
+			t.Fatalf("NewFromAudioFile failed: %v", err)
+		}
+		if expected := 20 * 1152 * channels; len(w.samples) != expected {
+			t.Errorf("%s: expected %d samples, got %d", filepath.Base(filename), expected, len(w.samples))
+		}
+		if expected := time.Duration(20*1152) * time.Second / 44100; w.Duration() != expected {
+			t.Errorf("%s: expected duration %v, got %v", filepath.Base(filename), expected, w.Duration())
+		}
+	}
+}
+
+func TestMP3ChannelsAfterID3(t *testing.T) {
+	// A tag larger than the scan window, as with embedded cover art
+	tag := make([]byte, 10+40000)
+	copy(tag, "ID3\x04\x00\x00")
+	size := 40000
+	tag[6], tag[7], tag[8], tag[9] = byte(size>>21&0x7F), byte(size>>14&0x7F), byte(size>>7&0x7F), byte(size&0x7F)
+
+	header := []byte{0xFF, 0xFB, 0x90, 0xC4}
+	if got := mp3Channels(bytes.NewReader(append(tag, header...))); got != 1 {
+		t.Errorf("Expected a mono frame after the tag, got %d channels", got)
+	}
+}
+
+func TestTotalSamples(t *testing.T) {
+	dir := t.TempDir()
+	flacFile := filepath.Join(dir, "tone.flac")
+	writeToneFLAC(t, flacFile, 20000, nil)
+	wavFile := filepath.Join(dir, "stereo.wav")
+	f, err := os.Create(wavFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := wav.NewEncoder(f, 44100, 24, 2, 1)
+	if err := enc.Write(stereoBuffer(24)); err != nil {
+		t.Fatal(err)
+	}
+	enc.Close()
+	f.Close()
+	mp3File := filepath.Join(dir, "silent.mp3")
+	writeSilentMP3(t, mp3File, 10)
+
+	for filename, wantKnown := range map[string]bool{flacFile: true, wavFile: true, mp3File: false} {
+		decoder, err := NewAudioDecoder(filename)
+		if err != nil {
+			t.Fatalf("NewAudioDecoder(%s) failed: %v", filepath.Base(filename), err)
+		}
+
+		total, known := 0, false
+		if counter, ok := decoder.(sampleCounter); ok {
+			total, known = counter.TotalSamples()
+		}
+		if known != wantKnown {
+			t.Errorf("%s: expected a known length %v, got %v", filepath.Base(filename), wantKnown, known)
+		}
+
+		samples, _, err := readAllSamples(context.Background(), decoder, 0, 0, 0, nil)
+		decoder.Close()
+		if err != nil {
+			t.Fatalf("readAllSamples(%s) failed: %v", filepath.Base(filename), err)
+		}
+		if known && total != len(samples) {
+			t.Errorf("%s: stated %d samples but decoded %d", filepath.Base(filename), total, len(samples))
+		}
+	}
+}
+
+func TestGenerateTiles(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "silence.mp3")
+	writeSilentMP3(t, filename, 100) // 100*1152 samples at 44.1kHz is about 2.6s
+
+	config := DefaultConfig()
+	config.Bars = 20
+	tiles, err := GenerateTiles(filename, time.Second, config)
+	if err != nil {
+		t.Fatalf("GenerateTiles failed: %v", err)
+	}
+
+	if len(tiles) != 3 {
+		t.Fatalf("Expected 3 tiles for 2.6s of audio, got %d", len(tiles))
+	}
+	for i, tile := range tiles {
+		if !bytes.HasPrefix(tile, []byte("<svg")) {
+			t.Errorf("Tile %d is not an SVG document", i)
+		}
+	}
+}
+
+func TestStreamInfoDuration(t *testing.T) {
+	info := streamInfo{sampleRate: 48000, channels: 2}
+	if d := info.duration(96000); d != time.Second {
+		t.Errorf("Expected 1s for 96000 interleaved stereo samples, got %v", d)
+	}
+
+	if d := (streamInfo{}).duration(1000); d != 0 {
+		t.Errorf("Expected zero duration without stream info, got %v", d)
+	}
+}
+
+// segmentedDecoder emits a sequence of mono PCM segments, each at its own sample rate
+type segmentedDecoder struct {
+	segments [][]int16
+	rates    []int
+	current  int
+	pos      int
+}
+
+func (d *segmentedDecoder) Read(buf []byte) (int, error) {
+	for d.current < len(d.segments) && d.pos >= len(d.segments[d.current]) {
+		d.current++
+		d.pos = 0
+	}
+	if d.current >= len(d.segments) {
+		return 0, io.EOF
+	}
+
+	segment := d.segments[d.current]
+	n := 0
+	for ; d.pos < len(segment) && n < len(buf)-1; d.pos++ {
+		buf[n] = byte(segment[d.pos])
+		buf[n+1] = byte(segment[d.pos] >> 8)
+		n += 2
+	}
+	return n, nil
+}
+
+func (d *segmentedDecoder) SampleRate() int {
+	if d.current >= len(d.rates) {
+		return d.rates[len(d.rates)-1]
+	}
+	return d.rates[d.current]
+}
+
+func (d *segmentedDecoder) NumChannels() int { return 1 }
+
+func (d *segmentedDecoder) Close() error { return nil }
+
+func TestVariableSampleRateStream(t *testing.T) {
+	// One second at 44.1kHz followed by one second at 22.05kHz
+	first := make([]int16, 44100)
+	second := make([]int16, 22050)
+	for i := range second {
+		second[i] = 1000
+	}
+
+	decoder := &segmentedDecoder{
+		segments: [][]int16{first, second},
+		rates:    []int{44100, 22050},
+	}
+
+	samples, info, err := readAllSamples(context.Background(), decoder, 0, 0, 0, nil)
+	if err != nil {
+		t.Fatalf("readAllSamples failed: %v", err)
+	}
+
+	if info.sampleRate != 44100 {
+		t.Errorf("Expected common sample rate 44100, got %d", info.sampleRate)
+	}
+
+	if len(samples) != 88200 {
+		t.Errorf("Expected 88200 samples after resampling, got %d", len(samples))
+	}
+
+	if d := info.duration(len(samples)); d != 2*time.Second {
+		t.Errorf("Expected corrected duration of 2s, got %v", d)
+	}
+
+	// The second segment must start exactly halfway through the time axis
+	if samples[44099] != 0 || samples[44100] != 1000 {
+		t.Errorf("Expected segment boundary at sample 44100, got %d/%d", samples[44099], samples[44100])
+	}
+}
+
+func TestResampleLinear(t *testing.T) {
+	stereo := []int16{0, 100, 10, 110, 20, 120, 30, 130}
+
+	up := resampleLinear(stereo, 2, 1, 2)
+	if len(up) != 16 {
+		t.Fatalf("Expected 16 samples, got %d", len(up))
+	}
+	if up[2] != 5 || up[3] != 105 {
+		t.Errorf("Expected interpolated frame (5, 105), got (%d, %d)", up[2], up[3])
+	}
+
+	down := resampleLinear(stereo, 2, 2, 1)
+	if len(down) != 4 || down[2] != 20 || down[3] != 120 {
+		t.Errorf("Expected downsampled frames [0 100 20 120], got %v", down)
+	}
+}
+
+// writeToneFLAC writes a mono 16-bit 44.1kHz FLAC stream of a 440Hz tone at the
+// given amplitude, with tags stored in a Vorbis comment block
+func writeToneFLAC(t *testing.T, path string, amplitude float64, tags [][2]string) {
+	t.Helper()
+
+	samples := make([]int32, 4*4096)
+	for i := range samples {
+		samples[i] = int32(amplitude * math.Sin(2*math.Pi*440*float64(i)/44100))
+	}
+	writeFLAC(t, path, [][]int32{samples}, tags)
+}
+
+// writeFLAC writes one or two channels of 16-bit 44.1kHz samples to a FLAC
+// stream in blocks of 4096, with tags stored in a Vorbis comment block
+func writeFLAC(t *testing.T, path string, channels [][]int32, tags [][2]string) {
+	t.Helper()
+
+	const blockSize = 4096
+	info := &meta.StreamInfo{
+		BlockSizeMin:  blockSize,
+		BlockSizeMax:  blockSize,
+		SampleRate:    44100,
+		NChannels:     uint8(len(channels)),
+		BitsPerSample: 16,
+	}
+	comment := &meta.Block{
+		// Any non-zero length; the encoder computes the real one but skips empty blocks
+		Header: meta.Header{Type: meta.TypeVorbisComment, Length: 1},
+		Body:   &meta.VorbisComment{Vendor: "gowaveform", Tags: tags},
+	}
+
+	layout := frame.ChannelsMono
+	if len(channels) == 2 {
+		layout = frame.ChannelsLR
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create FLAC fixture: %v", err)
+	}
+	defer f.Close()
+
+	enc, err := flac.NewEncoder(f, info, comment)
+	if err != nil {
+		t.Fatalf("Failed to create FLAC encoder: %v", err)
+	}
+
+	for start := 0; start < len(channels[0]); start += blockSize {
+		end := min(start+blockSize, len(channels[0]))
+
+		fr := &frame.Frame{
+			Header: frame.Header{
+				HasFixedBlockSize: true,
+				BlockSize:         uint16(end - start),
+				SampleRate:        44100,
+				Channels:          layout,
+				BitsPerSample:     16,
+			},
+		}
+		for _, samples := range channels {
+			fr.Subframes = append(fr.Subframes, &frame.Subframe{
+				SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+				Samples:   samples[start:end],
+				NSamples:  end - start,
+			})
+		}
+		if err := enc.WriteFrame(fr); err != nil {
+			t.Fatalf("Failed to write FLAC frame: %v", err)
+		}
+	}
+
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Failed to finish FLAC fixture: %v", err)
+	}
+}
+
+func TestApplyReplayGain(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tagged.flac")
+	writeToneFLAC(t, filename, 20000, [][2]string{
+		{"REPLAYGAIN_ALBUM_GAIN", "+3.00 dB"},
+		{"REPLAYGAIN_TRACK_GAIN", "-6.02 dB"},
+	})
+
+	config := DefaultConfig()
+	config.Mode = ModePeak
+	config.RetainSamples = true
+
+	plain, err := NewFromAudioFile(filename, config)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+
+	gained := *config
+	gained.ApplyReplayGain = true
+	scaled, err := NewFromAudioFile(filename, &gained)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+
+	if len(plain.samples) == 0 || len(plain.samples) != len(scaled.samples) {
+		t.Fatalf("Expected equal, non-zero sample counts, got %d and %d", len(plain.samples), len(scaled.samples))
+	}
+
+	// -6.02 dB halves the amplitude; the album gain must be ignored in favour of the track gain
+	for i, s := range plain.samples {
+		if expected := float64(s) / 2; math.Abs(float64(scaled.samples[i])-expected) > 1 {
+			t.Fatalf("Sample %d: expected %v after gain, got %d", i, expected, scaled.samples[i])
+		}
+	}
+}
+
+func TestTargetSampleRate(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tone.flac")
+	writeToneFLAC(t, filename, 20000, nil)
+
+	config := DefaultConfig()
+	source, err := NewFromAudioFile(filename, config)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+
+	resampled := *config
+	resampled.TargetSampleRate = 22050
+	w, err := NewFromAudioFile(filename, &resampled)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+
+	if len(w.samples) != len(source.samples)/2 {
+		t.Errorf("Expected %d samples at 22.05kHz, got %d", len(source.samples)/2, len(w.samples))
+	}
+	if w.info.sampleRate != 22050 {
+		t.Errorf("Expected a 22050Hz stream, got %d", w.info.sampleRate)
+	}
+	if w.Duration() != source.Duration() {
+		t.Errorf("Expected resampling to keep the duration %v, got %v", source.Duration(), w.Duration())
+	}
+}
+
+func TestDecimateFrames(t *testing.T) {
+	// Ten stereo frames whose samples hold their frame index, in two chunks
+	frames := make([]int16, 20)
+	for i := range frames {
+		frames[i] = int16(i / 2)
+	}
+
+	var kept []int16
+	offset := 0
+	for _, chunk := range [][]int16{frames[:6], frames[6:]} {
+		var out []int16
+		out, offset = decimateFrames(append([]int16(nil), chunk...), 2, 4, offset)
+		kept = append(kept, out...)
+	}
+	if want := []int16{0, 0, 4, 4, 8, 8}; !slices.Equal(kept, want) {
+		t.Errorf("Expected every 4th frame across the chunks, got %v", kept)
+	}
+}
+
+func TestGenerateThumbnailFile(t *testing.T) {
+	// A quiet first half and a loud second half, long enough to be decimated
+	samples := make([]int32, 32*4096)
+	for i := range samples {
+		amplitude := 5000.0
+		if i >= len(samples)/2 {
+			amplitude = 20000
+		}
+		samples[i] = int32(amplitude * math.Sin(2*math.Pi*440*float64(i)/44100))
+	}
+	filename := filepath.Join(t.TempDir(), "tone.flac")
+	writeFLAC(t, filename, [][]int32{samples}, nil)
+
+	config := DefaultConfig()
+	config.CornerRadius = 0
+	// Tall enough for the quiet bars to clear the minimum bar height
+	thumb, err := GenerateThumbnailFile(filename, 80, 100, 10, config)
+	if err != nil {
+		t.Fatalf("GenerateThumbnailFile failed: %v", err)
+	}
+
+	svgStr := string(thumb)
+	if !strings.Contains(svgStr, `viewBox="0 0 80 100"`) {
+		t.Errorf("Expected an 80x100 thumbnail, got %s", svgStr[:strings.IndexByte(svgStr, '>')+1])
+	}
+	rects := regexp.MustCompile(`<rect [^>]*height="([\d.]+)"`).FindAllStringSubmatch(svgStr, -1)
+	if len(rects) != 10 {
+		t.Fatalf("Expected 10 bars, got %d", len(rects))
+	}
+	quiet, _ := strconv.ParseFloat(rects[0][1], 64)
+	loud, _ := strconv.ParseFloat(rects[9][1], 64)
+	if ratio := loud / quiet; ratio < 3.5 || ratio > 4.5 {
+		t.Errorf("Expected the loud half about 4 times as tall, got %.1f and %.1f", quiet, loud)
+	}
+
+	if _, err := GenerateThumbnailFile(filepath.Join(t.TempDir(), "missing.flac"), 80, 20, 10, nil); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestStreamingMatchesBatch(t *testing.T) {
+	// A stereo tone swelling and fading, with the right channel at half level
+	left := make([]int32, 8*4096)
+	right := make([]int32, len(left))
+	for i := range left {
+		envelope := math.Sin(math.Pi * float64(i) / float64(len(left)))
+		left[i] = int32(25000 * envelope * math.Sin(2*math.Pi*440*float64(i)/44100))
+		right[i] = left[i] / 2
+	}
+	filename := filepath.Join(t.TempDir(), "swell.flac")
+	writeFLAC(t, filename, [][]int32{left, right}, nil)
+
+	for _, mode := range []CalculationMode{ModeRMS, ModePeak} {
+		for _, channel := range []Channel{ChannelMix, ChannelRight} {
+			config := DefaultConfig()
+			config.Mode = mode
+			config.Channel = channel
+			config.Bars = 50
+
+			batch, err := NewFromAudioFile(filename, config)
+			if err != nil {
+				t.Fatalf("NewFromAudioFile failed: %v", err)
+			}
+
+			streaming := *config
+			streaming.Streaming = true
+			streamed, err := NewFromAudioFile(filename, &streaming)
+			if err != nil {
+				t.Fatalf("NewFromAudioFile with Streaming failed: %v", err)
+			}
+
+			if streamed.samples != nil {
+				t.Errorf("Expected a streamed waveform to keep no samples")
+			}
+			if streamed.Duration() != batch.Duration() {
+				t.Errorf("%s/%s: expected duration %v, got %v", mode, channel, batch.Duration(), streamed.Duration())
+			}
+			if len(streamed.Peaks) != len(batch.Peaks) {
+				t.Fatalf("%s/%s: expected %d peaks, got %d", mode, channel, len(batch.Peaks), len(streamed.Peaks))
+			}
+			for i := range batch.Peaks {
+				if diff := math.Abs(streamed.Peaks[i] - batch.Peaks[i]); diff > 0.005 {
+					t.Errorf("%s/%s bar %d: streaming gave %f, batch %f", mode, channel, i, streamed.Peaks[i], batch.Peaks[i])
+				}
+			}
+		}
+	}
+}
+
+func TestID3ReplayGain(t *testing.T) {
+	txxx := func(desc, value string) []byte {
+		data := append([]byte{3}, desc...)
+		data = append(data, 0)
+		data = append(data, value...)
+		frame := []byte{'T', 'X', 'X', 'X', 0, 0, 0, byte(len(data)), 0, 0}
+		return append(frame, data...)
+	}
+
+	body := append(txxx("REPLAYGAIN_ALBUM_GAIN", "-1.50 dB"), txxx("replaygain_track_gain", "-4.25 dB")...)
+	tag := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(body))}, body...)
+
+	tags := id3Tags(bytes.NewReader(tag))
+	if len(tags) != 2 {
+		t.Fatalf("Expected 2 TXXX frames, got %d", len(tags))
+	}
+	if gain := replayGainFromTags(tags); gain != -4.25 {
+		t.Errorf("Expected track gain -4.25 dB, got %v", gain)
+	}
+
+	if tags := id3Tags(bytes.NewReader([]byte("not a tag at all"))); tags != nil {
+		t.Errorf("Expected no tags without an ID3 header, got %v", tags)
+	}
+}
+
+func TestDownmixSurroundCenter(t *testing.T) {
+	// Center-only 5.1: FL, FR, FC, LFE, BL, BR
+	const frames = 100
+	samples := make([]int16, frames*6)
+	for i := 0; i < frames; i++ {
+		samples[i*6+2] = 20000
+	}
+
+	mono := downmixSurround(samples, 6)
+	if len(mono) != frames {
+		t.Fatalf("Expected %d mono samples, got %d", frames, len(mono))
+	}
+
+	// BS.775 places the center 3dB down
+	expected := int16(math.Round(20000 * math.Sqrt2 / 2))
+	for i, s := range mono {
+		if s != expected {
+			t.Fatalf("Sample %d: expected center at -3dB (%d), got %d", i, expected, s)
+		}
+	}
+
+	// Other layouts keep the flat average
+	if quad := downmixSurround([]int16{400, 0, 0, 0}, 4); quad[0] != 100 {
+		t.Errorf("Expected equal-weight average for 4 channels, got %d", quad[0])
+	}
+}
+
+// stereoFrames is the known stereo input for the decoder contract tests: the
+// left channel counts up and the right channel counts down
+const stereoFrames = 5000
+
+func stereoSample(frame, channel int) int {
+	if channel == 0 {
+		return frame
+	}
+	return -frame
+}
+
+// checkInterleaved decodes filename and verifies it yields interleaved stereo
+// int16 frames matching stereoSample
+func checkInterleaved(t *testing.T, filename string) {
+	t.Helper()
+
+	decoder, err := NewAudioDecoder(filename)
+	if err != nil {
+		t.Fatalf("NewAudioDecoder failed: %v", err)
+	}
+	defer decoder.Close()
+
+	samples, info, err := readAllSamples(context.Background(), decoder, 0, 0, 0, nil)
+	if err != nil {
+		t.Fatalf("readAllSamples failed: %v", err)
+	}
+
+	if info.channels != 2 {
+		t.Fatalf("Expected 2 channels, got %d", info.channels)
+	}
+	if len(samples) != stereoFrames*2 {
+		t.Fatalf("Expected %d interleaved samples, got %d", stereoFrames*2, len(samples))
+	}
+	for i, s := range samples {
+		if expected := stereoSample(i/2, i%2); int(s) != expected {
+			t.Fatalf("Sample %d (frame %d, channel %d): expected %d, got %d", i, i/2, i%2, expected, s)
+		}
+	}
+}
+
+// stereoBuffer returns the known stereo input at the given bit depth
+func stereoBuffer(bitDepth int) *audio.IntBuffer {
+	buf := &audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: 2, SampleRate: 44100},
+		SourceBitDepth: bitDepth,
+		Data:           make([]int, stereoFrames*2),
+	}
+	for i := range buf.Data {
+		buf.Data[i] = stereoSample(i/2, i%2) << (bitDepth - 16)
+	}
+	return buf
+}
+
+func TestWAVDecoderInterleaved(t *testing.T) {
+	for _, bitDepth := range []int{16, 24} {
+		filename := filepath.Join(t.TempDir(), "stereo.wav")
+		f, err := os.Create(filename)
+		if err != nil {
+			t.Fatalf("Failed to create WAV fixture: %v", err)
+		}
+
+		enc := wav.NewEncoder(f, 44100, bitDepth, 2, 1)
+		if err := enc.Write(stereoBuffer(bitDepth)); err != nil {
+			t.Fatalf("Failed to write WAV fixture: %v", err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("Failed to finish WAV fixture: %v", err)
+		}
+		f.Close()
+
+		checkInterleaved(t, filename)
+	}
+}
+
+func TestAIFFDecoderInterleaved(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "stereo.aiff")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatalf("Failed to create AIFF fixture: %v", err)
+	}
+
+	enc := aiff.NewEncoder(f, 44100, 16, 2)
+	if err := enc.Write(stereoBuffer(16)); err != nil {
+		t.Fatalf("Failed to write AIFF fixture: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Failed to finish AIFF fixture: %v", err)
+	}
+	f.Close()
+
+	checkInterleaved(t, filename)
+}
+
+// floatStereoSample returns stereoSample as a float at full scale ±1.0
+func floatStereoSample(frame, channel int) float32 {
+	return float32(stereoSample(frame, channel)) / 32767
+}
+
+func TestWAVDecoderFloat(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "float.wav")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatalf("Failed to create WAV fixture: %v", err)
+	}
+
+	// go-audio writes 32-bit samples as they come, so pass it the IEEE bits
+	buf := &audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: 2, SampleRate: 44100},
+		SourceBitDepth: 32,
+		Data:           make([]int, stereoFrames*2),
+	}
+	for i := range buf.Data {
+		buf.Data[i] = int(int32(math.Float32bits(floatStereoSample(i/2, i%2))))
+	}
+
+	enc := wav.NewEncoder(f, 44100, 32, 2, wavFormatFloat)
+	if err := enc.Write(buf); err != nil {
+		t.Fatalf("Failed to write WAV fixture: %v", err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Failed to finish WAV fixture: %v", err)
+	}
+	f.Close()
+
+	checkInterleaved(t, filename)
+}
+
+func TestAIFFDecoderFloat(t *testing.T) {
+	// go-audio can't write AIFF-C, so assemble a stereo fl32 file by hand
+	comm := binary.BigEndian.AppendUint16(nil, 2)
+	comm = binary.BigEndian.AppendUint32(comm, stereoFrames)
+	comm = binary.BigEndian.AppendUint16(comm, 32)
+	comm = append(comm, 0x40, 0x0E, 0xAC, 0x44, 0, 0, 0, 0, 0, 0) // 44100 as 80-bit extended
+	comm = append(comm, "fl32\x00\x00"...)                        // encoding and empty, padded name
+
+	ssnd := make([]byte, 8)
+	for i := 0; i < stereoFrames*2; i++ {
+		ssnd = binary.BigEndian.AppendUint32(ssnd, math.Float32bits(floatStereoSample(i/2, i%2)))
+	}
+
+	var body []byte
+	body = append(body, "AIFC"...)
+	for _, chunk := range []struct {
+		id   string
+		data []byte
+	}{{"FVER", []byte{0xA2, 0x80, 0x51, 0x40}}, {"COMM", comm}, {"SSND", ssnd}} {
+		body = append(body, chunk.id...)
+		body = binary.BigEndian.AppendUint32(body, uint32(len(chunk.data)))
+		body = append(body, chunk.data...)
+	}
+	data := binary.BigEndian.AppendUint32([]byte("FORM"), uint32(len(body)))
+	data = append(data, body...)
+
+	filename := filepath.Join(t.TempDir(), "float.aifc")
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkInterleaved(t, filename)
+}
+
+func TestFloatToPCM16(t *testing.T) {
+	tests := []struct {
+		f        float32
+		expected int16
+	}{
+		{0, 0},
+		{0.5, 16384},
+		{-1, -32767},
+		{1.5, 32767},
+		{-2, -32768},
+	}
+
+	for _, tt := range tests {
+		if got := floatToPCM16(tt.f); got != tt.expected {
+			t.Errorf("floatToPCM16(%v) = %d, expected %d", tt.f, got, tt.expected)
+		}
+	}
+}
+
+func TestFLACDecoderInterleaved(t *testing.T) {
+	left, right := make([]int32, stereoFrames), make([]int32, stereoFrames)
+	for i := range left {
+		left[i], right[i] = int32(stereoSample(i, 0)), int32(stereoSample(i, 1))
+	}
+
+	filename := filepath.Join(t.TempDir(), "stereo.flac")
+	writeFLAC(t, filename, [][]int32{left, right}, nil)
+
+	checkInterleaved(t, filename)
+}
+
+func TestInterleaveSubframesUneven(t *testing.T) {
+	subframes := []*frame.Subframe{
+		{Samples: []int32{1, 2, 3}},
+		{Samples: []int32{-1, -2}},
+	}
+
+	got := interleaveSubframes(nil, subframes)
+	expected := []int32{1, -1, 2, -2, 3, 0}
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestFLACRightPanned(t *testing.T) {
+	left, right := make([]int32, 4*4096), make([]int32, 4*4096)
+	for i := range right {
+		right[i] = int32(16000 * math.Sin(2*math.Pi*440*float64(i)/44100))
+	}
+
+	dir := t.TempDir()
+	stereo := filepath.Join(dir, "right.flac")
+	writeFLAC(t, stereo, [][]int32{left, right}, nil)
+	mono := filepath.Join(dir, "mono.flac")
+	writeFLAC(t, mono, [][]int32{right}, nil)
+
+	config := DefaultConfig()
+	config.Mode = ModePeak
+	config.Bars = 10
+
+	// Mixing averages the tone with the silent left channel, halving it
+	for filename, floor := range map[string]float64{stereo: 0.2, mono: 0.4} {
+		w, err := NewFromAudioFile(filename, config)
+		if err != nil {
+			t.Fatalf("NewFromAudioFile(%s) failed: %v", filename, err)
+		}
+		for i, peak := range w.Peaks {
+			if peak < floor || peak > 2*floor {
+				t.Errorf("%s: expected the tone in bar %d, got peak %.3f", filepath.Base(filename), i, peak)
+			}
+		}
+		if d := w.Duration(); d < 370*time.Millisecond || d > 373*time.Millisecond {
+			t.Errorf("%s: expected a duration of ~371ms, got %v", filepath.Base(filename), d)
+		}
+	}
+}
+
+func TestChannelSelection(t *testing.T) {
+	left, right := make([]int32, 4*4096), make([]int32, 4*4096)
+	for i := range left {
+		tone := math.Sin(2 * math.Pi * 440 * float64(i) / 44100)
+		left[i], right[i] = int32(20000*tone), int32(5000*tone)
+	}
+
+	dir := t.TempDir()
+	stereo := filepath.Join(dir, "stereo.flac")
+	writeFLAC(t, stereo, [][]int32{left, right}, nil)
+	mono := filepath.Join(dir, "mono.flac")
+	writeFLAC(t, mono, [][]int32{left}, nil)
+
+	// The loudest sample of the single bar drawn from the channel
+	loudest := func(filename string, channel Channel) (int, int) {
+		samples, info, err := readSamplesFromFormat(context.Background(), filename, 0, 0, channel, false, nil)
+		if err != nil {
+			t.Fatalf("readSamplesFromFormat(%s, %s) failed: %v", filepath.Base(filename), channel, err)
+		}
+		peaks := computePeaks(context.Background(), samples, info, 1, ModePeak, nil, false, nil)
+		return int(math.Round(peaks[0] * 32768)), info.channels
+	}
+
+	// Mixing keeps both channels for StereoSplit, but draws their average
+	for channel, expected := range map[Channel]int{ChannelMix: 12500, ChannelLeft: 20000, ChannelRight: 5000} {
+		peak, channels := loudest(stereo, channel)
+		if math.Abs(float64(peak-expected)) > 2 {
+			t.Errorf("Stereo %s: expected a peak of %d, got %d", channel, expected, peak)
+		}
+		wantChannels := 1
+		if channel == ChannelMix {
+			wantChannels = 2
+		}
+		if channels != wantChannels {
+			t.Errorf("Stereo %s: expected %d channels, got %d", channel, wantChannels, channels)
+		}
+
+		// Every choice reads a mono file the same way
+		if peak, channels := loudest(mono, channel); math.Abs(float64(peak-20000)) > 2 || channels != 1 {
+			t.Errorf("Mono %s: expected a peak of 20000 in one channel, got %d in %d", channel, peak, channels)
+		}
+	}
+
+	if _, _, err := readSamplesFromFormat(context.Background(), stereo, 0, 0, "center", false, nil); err == nil {
+		t.Error("Expected an error for an unknown channel")
+	}
+}
+
+func TestNewFromAudioReader(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "piped.wav")
+	writeGoldenPCM(t, filename, goldenSamples(), 16)
+
+	config := DefaultConfig()
+	config.Bars = 50
+	want, err := NewFromAudioFile(filename, config)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Failed to open fixture: %v", err)
+	}
+	defer f.Close()
+
+	// A pipe can't seek, and the content alone tells the format
+	w, err := NewFromAudioReader(io.MultiReader(f), config)
+	if err != nil {
+		t.Fatalf("NewFromAudioReader failed: %v", err)
+	}
+	if !slices.Equal(w.Peaks, want.Peaks) || w.SampleRate() != 44100 || w.Channels() != 2 {
+		t.Errorf("Expected the piped WAV to match the file, got %d Hz with %d channels", w.SampleRate(), w.Channels())
+	}
+
+	if _, err := NewFromAudioReader(strings.NewReader("not audio at all"), config); err == nil {
+		t.Error("Expected an error for a stream in no supported format")
+	}
+}
+
+func TestNewFromPCMReader(t *testing.T) {
+	// One second of 8kHz stereo, a tone on the left and silence on the right
+	samples := make([]int16, 2*8000)
+	for i := 0; i < len(samples); i += 2 {
+		samples[i] = int16(20000 * math.Sin(2*math.Pi*440*float64(i/2)/8000))
+	}
+	var pcm bytes.Buffer
+	if err := binary.Write(&pcm, binary.LittleEndian, samples); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.Bars = 20
+	config.Mode = ModePeak
+
+	// A reader handing out one byte at a time must still line up whole samples
+	w, err := NewFromPCMReader(iotest.OneByteReader(bytes.NewReader(pcm.Bytes())), 8000, 2, config)
+	if err != nil {
+		t.Fatalf("NewFromPCMReader failed: %v", err)
+	}
+	mono, _ := mixToMono(samples, streamInfo{channels: 2})
+	if !slices.Equal(w.Peaks, NewFromSamples(mono, config).Peaks) {
+		t.Errorf("Expected the same peaks as NewFromSamples, got %v", w.Peaks)
+	}
+	if w.Duration() != time.Second {
+		t.Errorf("Expected a duration of 1s, got %v", w.Duration())
+	}
+
+	right := *config
+	right.Channel = ChannelRight
+	silent, err := NewFromPCMReader(bytes.NewReader(pcm.Bytes()), 8000, 2, &right)
+	if err != nil {
+		t.Fatalf("NewFromPCMReader failed: %v", err)
+	}
+	for i, peak := range silent.Peaks {
+		if peak != 0 {
+			t.Errorf("Expected the silent right channel in bar %d, got %f", i, peak)
+		}
+	}
+
+	truncated := pcm.Bytes()[:pcm.Len()-1]
+	if _, err := NewFromPCMReader(bytes.NewReader(truncated), 8000, 2, config); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("Expected a truncated stream error, got %v", err)
+	}
+	if _, err := NewFromPCMReader(bytes.NewReader(pcm.Bytes()), 0, 2, config); err == nil {
+		t.Error("Expected an error for a zero sample rate")
+	}
+}
+
+func TestLiveWaveform(t *testing.T) {
+	// Two seconds of 8kHz stereo in 50ms chunks, quiet then loud on the left
+	// and silent on the right
+	const chunk = 400
+	chunks := make([][]byte, 40)
+	for c := range chunks {
+		amplitude := 2000.0
+		if c >= len(chunks)/2 {
+			amplitude = 20000
+		}
+		samples := make([]int16, 2*chunk)
+		for i := 0; i < len(samples); i += 2 {
+			samples[i] = int16(amplitude * math.Sin(2*math.Pi*440*float64(c*chunk+i/2)/8000))
+		}
+		var pcm bytes.Buffer
+		if err := binary.Write(&pcm, binary.LittleEndian, samples); err != nil {
+			t.Fatal(err)
+		}
+		chunks[c] = pcm.Bytes()
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.Mode = ModePeak
+	config.Channel = ChannelLeft
+
+	whole, err := NewLiveWaveform(8000, 2, 0, config)
+	if err != nil {
+		t.Fatalf("NewLiveWaveform failed: %v", err)
+	}
+	windowed, err := NewLiveWaveform(8000, 2, 500*time.Millisecond, config)
+	if err != nil {
+		t.Fatalf("NewLiveWaveform failed: %v", err)
+	}
+	if peaks := whole.Snapshot().Peaks; len(peaks) != 10 || peakMax(peaks) != 0 {
+		t.Errorf("Expected 10 silent bars before any audio, got %v", peaks)
+	}
+
+	// Capture delivers a chunk every few milliseconds, split mid-sample, while
+	// the display keeps rendering snapshots
+	r, pw := io.Pipe()
+	go func() {
+		for _, data := range chunks {
+			pw.Write(data[:3])
+			pw.Write(data[3:])
+			time.Sleep(2 * time.Millisecond)
+		}
+		pw.Close()
+	}()
+	done := make(chan error)
+	go func() {
+		_, err := whole.ReadFrom(io.TeeReader(r, windowed))
+		done <- err
+	}()
+
+	rendering := true
+	for rendering {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("ReadFrom failed: %v", err)
+			}
+			rendering = false
+		default:
+			if _, err := windowed.Snapshot().GenerateSVG(); err != nil {
+				t.Fatalf("GenerateSVG of a snapshot failed: %v", err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if whole.Captured() != 2*time.Second {
+		t.Errorf("Expected 2s captured, got %v", whole.Captured())
+	}
+
+	// The whole recording is quiet in its first half
+	w := whole.Snapshot()
+	if w.Duration() != 2*time.Second || w.SampleCount() != 16000 {
+		t.Errorf("Expected a 2s snapshot of 16000 samples, got %v and %d", w.Duration(), w.SampleCount())
+	}
+	for i, peak := range w.Peaks {
+		want := 2000.0 / 32768
+		if i >= 5 {
+			want = 20000.0 / 32768
+		}
+		if math.Abs(peak-want) > 0.01 {
+			t.Errorf("Whole recording bar %d: expected about %.3f, got %.3f", i, want, peak)
+		}
+	}
+
+	// The window only holds the loud end
+	w = windowed.Snapshot()
+	if w.Duration() != 500*time.Millisecond {
+		t.Errorf("Expected a 500ms window, got %v", w.Duration())
+	}
+	for i, peak := range w.Peaks {
+		if math.Abs(peak-20000.0/32768) > 0.01 {
+			t.Errorf("Window bar %d: expected about %.3f, got %.3f", i, 20000.0/32768, peak)
+		}
+	}
+
+	smooth := *config
+	smooth.Mode = ModeSmooth
+	if _, err := NewLiveWaveform(8000, 2, 0, &smooth); err == nil {
+		t.Error("Expected an error showing a whole recording in smooth mode")
+	}
+	if _, err := NewLiveWaveform(8000, 2, time.Second, &smooth); err != nil {
+		t.Errorf("Expected smooth mode to work with a window, got %v", err)
+	}
+}
+
+func TestDecodeSamples(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "decode.wav")
+	want := goldenSamples()
+	writeGoldenPCM(t, filename, want, 16)
+
+	samples, sampleRate, channels, err := DecodeSamples(filename)
+	if err != nil {
+		t.Fatalf("DecodeSamples failed: %v", err)
+	}
+	if sampleRate != 44100 || channels != 2 {
+		t.Errorf("Expected 44100 Hz stereo, got %d Hz with %d channels", sampleRate, channels)
+	}
+	if !slices.Equal(samples, want) {
+		t.Errorf("Expected the %d interleaved samples written, got %d that differ", len(want), len(samples))
+	}
+
+	if _, _, _, err := DecodeSamples(filepath.Join(t.TempDir(), "missing.wav")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestTimeRange(t *testing.T) {
+	// Three seconds of 44.1kHz stereo, each second louder or quieter than the last
+	const rate = 44100
+	levels := []float64{1000, 20000, 5000}
+	left := make([]int32, 3*rate)
+	right := make([]int32, 3*rate)
+	for i := range left {
+		left[i] = int32(levels[i/rate] * math.Sin(2*math.Pi*441*float64(i)/rate))
+		right[i] = left[i] / 2
+	}
+	buf := &audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: 2, SampleRate: rate},
+		SourceBitDepth: 16,
+	}
+	for i := range left {
+		buf.Data = append(buf.Data, int(left[i]), int(right[i]))
+	}
+
+	dir := t.TempDir()
+	wavFile := filepath.Join(dir, "range.wav")
+	aiffFile := filepath.Join(dir, "range.aiff")
+	flacFile := filepath.Join(dir, "range.flac")
+	for _, filename := range []string{wavFile, aiffFile} {
+		f, err := os.Create(filename)
+		if err != nil {
+			t.Fatalf("Failed to create fixture: %v", err)
+		}
+		var enc interface {
+			Write(*audio.IntBuffer) error
+			Close() error
+		}
+		if filename == wavFile {
+			enc = wav.NewEncoder(f, rate, 16, 2, 1)
+		} else {
+			enc = aiff.NewEncoder(f, rate, 16, 2)
+		}
+		if err := enc.Write(buf); err != nil {
+			t.Fatalf("Failed to write fixture: %v", err)
+		}
+		if err := enc.Close(); err != nil {
+			t.Fatalf("Failed to finish fixture: %v", err)
+		}
+		f.Close()
+	}
+	writeFLAC(t, flacFile, [][]int32{left, right}, nil)
+
+	// WAV and AIFF seek, FLAC decodes and discards
+	for _, filename := range []string{wavFile, aiffFile} {
+		decoder, err := NewAudioDecoder(filename)
+		if err != nil {
+			t.Fatalf("NewAudioDecoder(%s) failed: %v", filepath.Base(filename), err)
+		}
+		if _, ok := decoder.(frameSkipper); !ok {
+			t.Errorf("Expected the %s decoder to seek", filepath.Base(filename))
+		}
+		decoder.Close()
+	}
+
+	for _, filename := range []string{wavFile, aiffFile, flacFile} {
+		for _, streaming := range []bool{false, true} {
+			name := fmt.Sprintf("%s streaming %v", filepath.Base(filename), streaming)
+
+			config := DefaultConfig()
+			config.Bars = 10
+			config.Mode = ModePeak
+			config.Streaming = streaming
+			config.StartTime = time.Second
+			config.EndTime = 2 * time.Second
+			w, err := NewFromAudioFile(filename, config)
+			if err != nil {
+				t.Fatalf("%s: NewFromAudioFile failed: %v", name, err)
+			}
+			if w.Duration() != time.Second || w.SampleCount() != rate {
+				t.Errorf("%s: expected 1s of %d samples, got %v of %d", name, rate, w.Duration(), w.SampleCount())
+			}
+			// The right channel at half the level mixes in at three quarters
+			for i, peak := range w.Peaks {
+				if math.Abs(peak-15000.0/32768) > 0.01 {
+					t.Errorf("%s: bar %d expected the loud second at %.3f, got %.3f", name, i, 15000.0/32768, peak)
+				}
+			}
+
+			// MaxDuration counts from the start time; no end time runs to the end of the file
+			config.EndTime = 0
+			config.MaxDuration = 1500 * time.Millisecond
+			w, err = NewFromAudioFile(filename, config)
+			if err != nil {
+				t.Fatalf("%s: NewFromAudioFile failed: %v", name, err)
+			}
+			if w.Duration() != 1500*time.Millisecond {
+				t.Errorf("%s: expected 1.5s from MaxDuration, got %v", name, w.Duration())
+			}
+			if last := w.Peaks[len(w.Peaks)-1]; math.Abs(last-3750.0/32768) > 0.01 {
+				t.Errorf("%s: expected the last bar in the quiet third second, got %.3f", name, last)
+			}
+
+			config.MaxDuration = 0
+			config.StartTime = 4 * time.Second
+			if _, err := NewFromAudioFile(filename, config); err == nil || !strings.Contains(err.Error(), "past the end") {
+				t.Errorf("%s: expected an error starting past the end, got %v", name, err)
+			}
+		}
+	}
+
+	config := DefaultConfig()
+	config.StartTime = 2 * time.Second
+	config.EndTime = time.Second
+	if _, err := NewFromAudioFile(wavFile, config); err == nil {
+		t.Error("Expected an error for an end time before the start time")
+	}
+}
+
+func TestProgressFunc(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "progress.wav")
+	writeGoldenPCM(t, filename, goldenSamples(), 16)
+
+	// The callback deliberately doesn't lock: calls must never overlap
+	var reports []float64
+	record := func(fraction float64) {
+		reports = append(reports, fraction)
+	}
+	check := func(name string, decodes bool) {
+		t.Helper()
+		if len(reports) == 0 || len(reports) > 101 {
+			t.Fatalf("%s: expected 1 to 101 reports, got %d", name, len(reports))
+		}
+		for i := 1; i < len(reports); i++ {
+			if reports[i]-reports[i-1] < progressStep-1e-9 && reports[i] != 1 {
+				t.Errorf("%s: report %d moved from %.3f to %.3f, less than a step", name, i, reports[i-1], reports[i])
+			}
+		}
+		if last := reports[len(reports)-1]; last != 1 {
+			t.Errorf("%s: expected the last report to be 1, got %.3f", name, last)
+		}
+		if decodes && reports[0] > 0.5 {
+			t.Errorf("%s: expected decoding to report early progress, first report was %.3f", name, reports[0])
+		}
+		reports = nil
+	}
+
+	for _, tc := range []struct {
+		name                  string
+		concurrent, streaming bool
+	}{
+		{"serial", false, false},
+		{"concurrent", true, false},
+		{"streaming", false, true},
+	} {
+		config := DefaultConfig()
+		config.Bars = 500
+		config.Mode = ModeRMS
+		config.Concurrent = tc.concurrent
+		config.Streaming = tc.streaming
+		config.ProgressFunc = record
+		if _, err := NewFromAudioFile(filename, config); err != nil {
+			t.Fatalf("%s: NewFromAudioFile failed: %v", tc.name, err)
+		}
+		check(tc.name, true)
+	}
+
+	config := DefaultConfig()
+	config.Bars = 500
+	config.ProgressFunc = record
+	NewFromSamples(goldenSamples(), config)
+	check("samples", false)
+}
+
+func TestNewFromAudioFileContext(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cancel.wav")
+	writeGoldenPCM(t, filename, goldenSamples(), 16)
+
+	for _, tc := range []struct {
+		name                  string
+		concurrent, streaming bool
+		// cancelAt is the progress at which the context is cancelled
+		cancelAt float64
+	}{
+		{"serial decode", false, false, 0.2},
+		{"serial bars", false, false, progressDecodeShare + 0.02},
+		{"concurrent bars", true, false, progressDecodeShare + 0.02},
+		{"streaming", false, true, 0.2},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		var after int
+		config := DefaultConfig()
+		config.Bars = 500
+		config.Mode = ModeRMS
+		config.Concurrent = tc.concurrent
+		config.Streaming = tc.streaming
+		config.ProgressFunc = func(fraction float64) {
+			if ctx.Err() != nil {
+				after++
+			} else if fraction >= tc.cancelAt {
+				cancel()
+			}
+		}
+
+		w, err := NewFromAudioFileContext(ctx, filename, config)
+		cancel()
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: expected context.Canceled, got %v", tc.name, err)
+		}
+		if w != nil {
+			t.Errorf("%s: expected no waveform once cancelled", tc.name)
+		}
+		// Workers already on a bucket finish it, so allow a report per worker
+		if after > runtime.NumCPU() {
+			t.Errorf("%s: expected work to stop promptly, got %d reports after cancelling", tc.name, after)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if _, err := NewFromAudioFileContext(ctx, filename, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected an expired context to fail with context.DeadlineExceeded, got %v", err)
+	}
+
+	if _, err := NewFromAudioFileContext(context.Background(), filename, nil); err != nil {
+		t.Errorf("Expected a live context to decode, got %v", err)
+	}
+}
+
+func TestNativeMonoDecode(t *testing.T) {
+	left, right := make([]int32, 4*4096), make([]int32, 4*4096)
+	for i := range left {
+		tone := math.Sin(2 * math.Pi * 440 * float64(i) / 44100)
+		left[i], right[i] = int32(20000*tone), int32(4000*math.Cos(float64(i)/50))
+	}
+	filename := filepath.Join(t.TempDir(), "stereo.flac")
+	writeFLAC(t, filename, [][]int32{left, right}, nil)
+
+	// The FLAC decoder mixes natively, without interleaving the channels first
+	decoder, err := NewAudioDecoder(filename)
+	if err != nil {
+		t.Fatalf("NewAudioDecoder failed: %v", err)
+	}
+	requestMono(decoder)
+	if channels := decoder.NumChannels(); channels != 1 {
+		t.Errorf("Expected the FLAC decoder to decode mono, got %d channels", channels)
+	}
+	decoder.Close()
+
+	// Mix down after decoding all channels for reference
+	samples, info, err := readSamplesFromFormat(context.Background(), filename, 0, 0, ChannelMix, false, nil)
+	if err != nil {
+		t.Fatalf("readSamplesFromFormat failed: %v", err)
+	}
+	mixed, _ := mixToMono(samples, info)
+
+	config := DefaultConfig()
+	config.Mode = ModeRMS
+	config.NativeMonoDecode = true
+	for _, streaming := range []bool{false, true} {
+		config.Streaming = streaming
+		w, err := NewFromAudioFile(filename, config)
+		if err != nil {
+			t.Fatalf("NewFromAudioFile failed: %v", err)
+		}
+		if w.info.channels != 1 {
+			t.Errorf("Expected a mono waveform, got %d channels", w.info.channels)
+		}
+
+		reference := downsample(context.Background(), mixed, config.Bars, config.Mode, nil, nil)
+		for i := range reference {
+			if diff := math.Abs(w.Peaks[i] - reference[i]); diff > 0.005 {
+				t.Errorf("Streaming %v bar %d: native mono gave %f, post-mixdown %f", streaming, i, w.Peaks[i], reference[i])
+			}
+		}
+	}
+}
+
+func TestBarsPerSecond(t *testing.T) {
+	// 2.5 seconds of 8kHz mono noise
+	pcm := make([]byte, 2*20000)
+	for i := range pcm {
+		pcm[i] = byte(i * 7)
+	}
+
+	config := DefaultConfig()
+	config.BarsPerSecond = 10
+	w, err := NewFromPCMReader(bytes.NewReader(pcm), 8000, 1, config)
+	if err != nil {
+		t.Fatalf("NewFromPCMReader failed: %v", err)
+	}
+	if len(w.Peaks) != 25 || w.Config.Bars != 25 {
+		t.Errorf("Expected 25 bars for 2.5s at 10 bars per second, got %d peaks with Bars %d", len(w.Peaks), w.Config.Bars)
+	}
+	if w.Config.Width != 500 {
+		t.Errorf("Expected the width to stay fixed, got %d", w.Config.Width)
+	}
+	if config.Bars != 100 {
+		t.Errorf("Expected the caller's config to be left alone, got Bars %d", config.Bars)
+	}
+
+	// 5px per bar in the 500/100 config carries over to the derived bar count
+	config.AutoWidth = true
+	w, err = NewFromPCMReader(bytes.NewReader(pcm), 8000, 1, config)
+	if err != nil {
+		t.Fatalf("NewFromPCMReader failed: %v", err)
+	}
+	if w.Config.Width != 125 {
+		t.Errorf("Expected AutoWidth to give 25 bars 125px, got %d", w.Config.Width)
+	}
+
+	// Raw samples carry no sample rate to derive the bar count from
+	if peaks := NewFromSamples(make([]int16, 20000), config).Peaks; len(peaks) != 100 {
+		t.Errorf("Expected BarsPerSecond to be ignored without a sample rate, got %d bars", len(peaks))
+	}
+}
+
+func TestSelectChannelPartialFrame(t *testing.T) {
+	// A stream cut off after the left sample of its last frame
+	samples := []int16{1, -1, 2, -2, 3}
+	info := streamInfo{sampleRate: 44100, channels: 2}
+
+	for channel, expected := range map[Channel][]int16{ChannelLeft: {1, 2}, ChannelRight: {-1, -2}} {
+		got, _, err := selectChannel(samples, info, channel)
+		if err != nil {
+			t.Fatalf("selectChannel failed: %v", err)
+		}
+		if !slices.Equal(got, expected) {
+			t.Errorf("%s: expected %v, got %v", channel, expected, got)
+		}
+	}
+}
+
+func TestPCMToInt16(t *testing.T) {
+	tests := []struct {
+		v, bitDepth int
+		expected    int16
+	}{
+		{-32768, 16, -32768},
+		{0x7FFFFF, 24, 0x7FFF},
+		{-0x800000, 24, -32768},
+		{127, 8, 127 << 8},
+		{0x7FFFFFFF, 32, 0x7FFF},
+		{-0x80000000, 32, -32768},
+		// Out of range for the bit depth: clip rather than wrap
+		{0x1000000, 24, 0x7FFF},
+		{40000, 16, 0x7FFF},
+	}
+
+	for _, tt := range tests {
+		if got := pcmToInt16(tt.v, tt.bitDepth); got != tt.expected {
+			t.Errorf("pcmToInt16(%d, %d) = %d, expected %d", tt.v, tt.bitDepth, got, tt.expected)
+		}
+	}
+}
+
+func TestWAVLoopMarkers(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "loop.wav")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: 1, SampleRate: 44100},
+		SourceBitDepth: 16,
+		Data:           make([]int, 44100),
+	}
+	for i := range buf.Data {
+		buf.Data[i] = int(10000 * math.Sin(float64(i)/5))
+	}
+	enc := wav.NewEncoder(f, 44100, 16, 1, 1)
+	if err := enc.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Append a smpl chunk after the data, as samplers write it, looping the
+	// middle half of the second
+	smpl := make([]byte, 36+24)
+	binary.LittleEndian.PutUint32(smpl[28:], 1)
+	binary.LittleEndian.PutUint32(smpl[36+8:], 11025)
+	binary.LittleEndian.PutUint32(smpl[36+12:], 33074)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, "smpl"...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(smpl)))
+	data = append(data, smpl...)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.LoopMarkers = true
+	w, err := NewFromAudioFile(filename, config)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+	if got := w.LoopPoints(); !slices.Equal(got, []LoopPoint{{Start: 11025, End: 33074}}) {
+		t.Fatalf("Expected the loop from the smpl chunk, got %v", got)
+	}
+	// The audio itself is untouched by the trailing chunk
+	if w.SampleCount() != 44100 {
+		t.Errorf("Expected 44100 frames, got %d", w.SampleCount())
+	}
+
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	// A quarter and three quarters of the way across the 500px width
+	lines := regexp.MustCompile(`<line x1="([\d.]+)"`).FindAllStringSubmatch(string(svgData), -1)
+	if len(lines) != 2 || lines[0][1] != "125" || lines[1][1] != "375" {
+		t.Errorf("Expected loop markers at x=125 and x=375, got %v", lines)
+	}
+
+	// Resampling keeps the markers in place
+	config.TargetSampleRate = 22050
+	w, err = NewFromAudioFile(filename, config)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+	if got := w.LoopPoints(); !slices.Equal(got, []LoopPoint{{Start: 5512, End: 16537}}) {
+		t.Errorf("Expected the loop scaled to 22.05kHz, got %v", got)
+	}
+}
+
+func TestWAVLoopsCorruptSize(t *testing.T) {
+	// A smpl chunk claiming nearly 4GB in a file of a few dozen bytes
+	data := []byte("RIFF\x00\x00\x00\x00WAVE")
+	data = append(data, "smpl"...)
+	data = binary.LittleEndian.AppendUint32(data, 0xFFFFFFF0)
+	data = append(data, make([]byte, 36+24)...)
+
+	if _, err := readWAVLoops(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "past the end") {
+		t.Errorf("Expected an error for a chunk past the end of the file, got %v", err)
+	}
+
+	// A loop count beyond what the chunk holds
+	binary.LittleEndian.PutUint32(data[16:], 36+24)
+	binary.LittleEndian.PutUint32(data[20+28:], 1000)
+	if _, err := readWAVLoops(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "holds 1") {
+		t.Errorf("Expected an error for too many loops, got %v", err)
+	}
+}
+
+func TestWAVDecoder32BitFullScale(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "full.wav")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A full-scale 32-bit tone, touching both extremes of the range
+	buf := &audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: 1, SampleRate: 44100},
+		SourceBitDepth: 32,
+		Data:           make([]int, 44100),
+	}
+	for i := range buf.Data {
+		v := math.Sin(2 * math.Pi * 441 * float64(i) / 44100)
+		buf.Data[i] = int(math.Max(math.MinInt32, math.Min(math.MaxInt32, math.Round(v*(1<<31)))))
+	}
+	enc := wav.NewEncoder(f, 44100, 32, 1, 1)
+	if err := enc.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for mode, want := range map[CalculationMode]float64{ModePeak: 1.0, ModeRMS: math.Sqrt2 / 2} {
+		config := DefaultConfig()
+		config.Mode = mode
+		config.Bars = 10
+		w, err := NewFromAudioFile(filename, config)
+		if err != nil {
+			t.Fatalf("NewFromAudioFile failed: %v", err)
+		}
+		for i, peak := range w.Peaks {
+			if math.Abs(peak-want) > 0.01 {
+				t.Errorf("%s bar %d: expected %.3f for a full-scale tone, got %.3f", mode, i, want, peak)
+			}
+		}
+	}
+}
+
+// halfHeights returns the tallest bar in the top and bottom half of a square-bar SVG
+func halfHeights(t *testing.T, svgData []byte, height float64) (top, bottom float64) {
+	t.Helper()
+
+	rects := regexp.MustCompile(`<rect x="[\d.]+" y="([\d.]+)" width="[\d.]+" height="([\d.]+)"`).FindAllStringSubmatch(string(svgData), -1)
+	if len(rects) == 0 {
+		t.Fatalf("Expected rect bars in %s", svgData)
+	}
+	for _, rect := range rects {
+		y, _ := strconv.ParseFloat(rect[1], 64)
+		h, _ := strconv.ParseFloat(rect[2], 64)
+		if y < height/2 {
+			top = math.Max(top, h)
+		} else {
+			bottom = math.Max(bottom, h)
+		}
+	}
+	return top, bottom
+}
+
+func TestPerChannelNormalize(t *testing.T) {
+	left, right := make([]int32, 4*4096), make([]int32, 4*4096)
+	for i := range left {
+		tone := math.Sin(2 * math.Pi * 440 * float64(i) / 44100)
+		left[i], right[i] = int32(20000*tone), int32(800*tone)
+	}
+
+	filename := filepath.Join(t.TempDir(), "unbalanced.flac")
+	writeFLAC(t, filename, [][]int32{left, right}, nil)
+
+	config := DefaultConfig()
+	config.Bars = 20
+	config.CornerRadius = 0
+	config.Mode = ModePeak
+	config.StereoSplit = true
+
+	w, err := NewFromAudioFile(filename, config)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+
+	// Each half holds a waveform up to 48% of its height either side of its midline
+	full := float64(config.Height) * 0.48
+
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	top, bottom := halfHeights(t, svgData, float64(config.Height))
+	if math.Abs(top-full) > 0.5 || bottom > full/4 {
+		t.Errorf("Expected a full left half and a small right half with shared scaling, got %.1f and %.1f", top, bottom)
+	}
+
+	config.PerChannelNormalize = true
+	svgData, err = w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	top, bottom = halfHeights(t, svgData, float64(config.Height))
+	if math.Abs(top-full) > 0.5 || math.Abs(bottom-full) > 0.5 {
+		t.Errorf("Expected both halves to fill their height, got %.1f and %.1f", top, bottom)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tone.flac")
+	writeToneFLAC(t, filename, 12000, nil)
+
+	config := DefaultConfig()
+	config.Bars = 30
+	config.Mode = ModeRMS
+	config.BarColor = "#FF6B6B"
+
+	w, err := NewFromAudioFile(filename, config)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+
+	data, err := w.GenerateJSON()
+	if err != nil {
+		t.Fatalf("GenerateJSON failed: %v", err)
+	}
+
+	var fields struct {
+		Bars       int       `json:"bars"`
+		Mode       string    `json:"mode"`
+		SampleRate int       `json:"sampleRate"`
+		Duration   float64   `json:"duration"`
+		Peaks      []float64 `json:"peaks"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Expected valid JSON: %v", err)
+	}
+	if fields.Bars != 30 || fields.Mode != "rms" || fields.SampleRate != 44100 {
+		t.Errorf("Unexpected metadata: %d bars, mode %q, %d Hz", fields.Bars, fields.Mode, fields.SampleRate)
+	}
+	if math.Abs(fields.Duration-w.Duration().Seconds()) > 1e-9 {
+		t.Errorf("Expected duration %v, got %vs", w.Duration(), fields.Duration)
+	}
+	if maxPeak := peakMax(fields.Peaks); maxPeak != 1 {
+		t.Errorf("Expected peaks normalized to a maximum of 1, got %v", maxPeak)
+	}
+
+	loaded, err := LoadJSON(data)
+	if err != nil {
+		t.Fatalf("LoadJSON failed: %v", err)
+	}
+	if loaded.Config.BarColor != "#FF6B6B" || loaded.Config.Mode != ModeRMS || loaded.Config.Bars != 30 {
+		t.Errorf("Expected the config to round-trip, got %+v", loaded.Config)
+	}
+	if loaded.Duration() != w.Duration() {
+		t.Errorf("Expected duration %v, got %v", w.Duration(), loaded.Duration())
+	}
+
+	// Rendering is normalized anyway, so the loaded waveform draws the same bars
+	original, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	rendered, err := loaded.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	if !bytes.Equal(original, rendered) {
+		t.Error("Expected the loaded waveform to render like the original")
+	}
+
+	if _, err := LoadJSON([]byte(`{"bars": 3, "peaks": [1]}`)); err == nil {
+		t.Error("Expected an error for mismatched bars and peaks")
+	}
+}
+
+func TestWaveformMetadata(t *testing.T) {
+	left, right := make([]int32, 3*4096), make([]int32, 3*4096)
+	for i := range left {
+		left[i] = int32(10000 * math.Sin(float64(i)/10))
+		right[i] = left[i] / 2
+	}
+	filename := filepath.Join(t.TempDir(), "stereo.flac")
+	writeFLAC(t, filename, [][]int32{left, right}, nil)
+
+	for _, tc := range []struct {
+		channel   Channel
+		streaming bool
+		channels  int
+	}{{ChannelMix, false, 2}, {ChannelMix, true, 2}, {ChannelLeft, false, 1}} {
+		config := DefaultConfig()
+		config.Channel = tc.channel
+		config.Streaming = tc.streaming
+		w, err := NewFromAudioFile(filename, config)
+		if err != nil {
+			t.Fatalf("NewFromAudioFile failed: %v", err)
+		}
+
+		data, err := w.GenerateJSON()
+		if err != nil {
+			t.Fatalf("GenerateJSON failed: %v", err)
+		}
+		restored, err := LoadJSON(data)
+		if err != nil {
+			t.Fatalf("LoadJSON failed: %v", err)
+		}
+		for _, got := range []*Waveform{w, restored} {
+			if got.SampleRate() != 44100 || got.Channels() != tc.channels || got.SampleCount() != len(left) {
+				t.Errorf("%+v: expected 44100Hz, %d channels and %d samples, got %dHz, %d channels and %d samples",
+					tc, tc.channels, len(left), got.SampleRate(), got.Channels(), got.SampleCount())
+			}
+			if want := time.Duration(len(left)) * time.Second / 44100; got.Duration() != want {
+				t.Errorf("%+v: expected a duration of %v, got %v", tc, want, got.Duration())
+			}
+		}
+	}
+
+	if w := NewFromSamples(make([]int16, 500), nil); w.SampleCount() != 500 || w.SampleRate() != 0 || w.Channels() != 0 {
+		t.Errorf("Expected 500 samples of unknown rate and layout, got %d samples at %dHz in %d channels", w.SampleCount(), w.SampleRate(), w.Channels())
+	}
+}
+
+func TestChannelsJSON(t *testing.T) {
+	left, right := make([]int32, 4*4096), make([]int32, 4*4096)
+	for i := range left {
+		tone := math.Sin(2 * math.Pi * 440 * float64(i) / 44100)
+		left[i], right[i] = int32(20000*tone), int32(5000*tone)
+	}
+	filename := filepath.Join(t.TempDir(), "stereo.flac")
+	writeFLAC(t, filename, [][]int32{left, right}, nil)
+
+	config := DefaultConfig()
+	config.Bars = 40
+	config.RetainSamples = true
+	w, err := NewFromAudioFile(filename, config)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+
+	data, err := w.GenerateChannelsJSON()
+	if err != nil {
+		t.Fatalf("GenerateChannelsJSON failed: %v", err)
+	}
+
+	var v struct {
+		Version         int     `json:"version"`
+		Channels        int     `json:"channels"`
+		SampleRate      int     `json:"sample_rate"`
+		SamplesPerPixel int     `json:"samples_per_pixel"`
+		Bits            int     `json:"bits"`
+		Length          int     `json:"length"`
+		Data            []int16 `json:"data"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+
+	if v.Version != 2 || v.Channels != 2 || v.Bits != 16 {
+		t.Fatalf("Expected version 2 with two 16-bit channels, got %+v", v)
+	}
+	if v.Length != 40 || v.SampleRate != 44100 || v.SamplesPerPixel != len(left)/40 {
+		t.Errorf("Unexpected metadata: %+v", v)
+	}
+	if len(v.Data) != 2*2*40 {
+		t.Fatalf("Expected a min/max pair per channel for 40 bars, got %d values", len(v.Data))
+	}
+	// The channels stay apart: the loud left against the quiet right
+	var l, r int16
+	for i := 0; i < len(v.Data); i += 4 {
+		l, r = max(l, v.Data[i+1]), max(r, v.Data[i+3])
+		if v.Data[i] > v.Data[i+1] || v.Data[i+2] > v.Data[i+3] {
+			t.Errorf("Bar %d: expected min before max, got %v", i/4, v.Data[i:i+4])
+		}
+	}
+	if l < 19000 || r > 5100 {
+		t.Errorf("Expected a left max near 20000 and right max near 5000, got %d and %d", l, r)
+	}
+
+	// The length follows the bars drawn, not a config reused since
+	config.Bars = 7
+	if data, err = w.GenerateChannelsJSON(); err != nil {
+		t.Fatalf("GenerateChannelsJSON failed: %v", err)
+	}
+	if err := json.Unmarshal(data, &v); err != nil || v.Length != 40 || len(v.Data) != 2*2*40 {
+		t.Errorf("Expected 40 bars after changing the config, got %d (%v)", v.Length, err)
+	}
+}
+
+// tinyOpus is a 20ms mono SILK clip in an Ogg container, from the pion/opus
+// test data (SPDX-FileCopyrightText: 2023 The Pion community, MIT license)
+var tinyOpus = []byte{
+	0x4f, 0x67, 0x67, 0x53, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x79, 0x62,
+	0xef, 0xee, 0x00, 0x00, 0x00, 0x00, 0xd7, 0x16, 0x5d, 0x6c, 0x01, 0x13, 0x4f, 0x70, 0x75, 0x73,
+	0x48, 0x65, 0x61, 0x64, 0x01, 0x01, 0x38, 0x01, 0x80, 0xbb, 0x00, 0x00, 0x00, 0x00, 0x00, 0x4f,
+	0x67, 0x67, 0x53, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x79, 0x62, 0xef,
+	0xee, 0x01, 0x00, 0x00, 0x00, 0x6a, 0xfd, 0x4f, 0x1a, 0x01, 0x3e, 0x4f, 0x70, 0x75, 0x73, 0x54,
+	0x61, 0x67, 0x73, 0x0d, 0x00, 0x00, 0x00, 0x4c, 0x61, 0x76, 0x66, 0x35, 0x39, 0x2e, 0x31, 0x36,
+	0x2e, 0x31, 0x30, 0x30, 0x01, 0x00, 0x00, 0x00, 0x1d, 0x00, 0x00, 0x00, 0x65, 0x6e, 0x63, 0x6f,
+	0x64, 0x65, 0x72, 0x3d, 0x4c, 0x61, 0x76, 0x63, 0x35, 0x39, 0x2e, 0x31, 0x38, 0x2e, 0x31, 0x30,
+	0x30, 0x20, 0x6c, 0x69, 0x62, 0x6f, 0x70, 0x75, 0x73, 0x4f, 0x67, 0x67, 0x53, 0x00, 0x04, 0x4f,
+	0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x79, 0x62, 0xef, 0xee, 0x02, 0x00, 0x00, 0x00, 0x6e,
+	0x45, 0x59, 0x46, 0x01, 0x0f, 0x48, 0x83, 0xca, 0xde, 0x8a, 0xe5, 0x67, 0xd5, 0x1c, 0xac, 0xa2,
+	0x54, 0xfa, 0xff, 0xbf,
+}
+
+func TestOpusDecoderOgg(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tiny.opus")
+	if err := os.WriteFile(filename, tinyOpus, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := NewAudioDecoder(filename)
+	if err != nil {
+		t.Fatalf("NewAudioDecoder failed: %v", err)
+	}
+	if decoder.NumChannels() != 1 || decoder.SampleRate() != 48000 {
+		t.Errorf("Expected mono 48kHz, got %d channels at %dHz", decoder.NumChannels(), decoder.SampleRate())
+	}
+	decoder.Close()
+
+	config := DefaultConfig()
+	config.RetainSamples = true
+	w, err := NewFromAudioFile(filename, config)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+
+	// The final granule position of 591 less the 312-sample pre-skip from the OpusHead
+	if len(w.samples) != 279 {
+		t.Errorf("Expected 279 samples after pre-skip and end trimming, got %d", len(w.samples))
+	}
+	if d := w.Duration(); d != 5812500*time.Nanosecond {
+		t.Errorf("Expected a duration of 5.8125ms, got %v", d)
+	}
+
+	// Speech at a sane level: neither silence nor garbage slammed to full scale
+	var loudest int
+	for _, s := range w.samples {
+		loudest = max(loudest, int(s), -int(s))
+	}
+	if loudest == 0 || loudest >= 32767 {
+		t.Errorf("Expected decoded audio at a sane level, got a maximum of %d", loudest)
+	}
+	if peakMax(w.Peaks) == 0 {
+		t.Error("Expected non-empty peaks")
+	}
+}
+
+func TestOpusDecoderFrameLength(t *testing.T) {
+	// The SILK packet of tinyOpus, relabelled with narrowband, mediumband and
+	// wideband tables of contents, all 20ms mono frames. Each comes out as 20ms
+	// at 48kHz, every sample held for 6, 4 or 3 samples in turn.
+	payload := []byte{0x83, 0xca, 0xde, 0x8a, 0xe5, 0x67, 0xd5, 0x1c, 0xac, 0xa2, 0x54, 0xfa, 0xff, 0xbf}
+	for toc, repeat := range map[byte]int{0x08: 6, 0x28: 4, 0x48: 3} {
+		// A stream whose pages are all used up, so only the queued packet is decoded
+		ogg, _, err := oggreader.NewWith(bytes.NewReader(tinyOpus))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			if _, _, err := ogg.ParseNextPage(); err != nil {
+				break
+			}
+		}
+
+		d := &OpusDecoder{
+			decoder:  opus.NewDecoder(),
+			ogg:      ogg,
+			channels: 1,
+			end:      -1,
+			packets:  [][]byte{append([]byte{toc}, payload...)},
+		}
+
+		data, err := io.ReadAll(d)
+		if err != nil {
+			t.Fatalf("TOC %#x: read failed: %v", toc, err)
+		}
+		if len(data)/2 != 960 {
+			t.Fatalf("TOC %#x: expected 960 samples at 48kHz, got %d", toc, len(data)/2)
+		}
+		for i := 0; i < len(data); i += 2 * repeat {
+			for j := i + 2; j < i+2*repeat; j += 2 {
+				if data[j] != data[i] || data[j+1] != data[i+1] {
+					t.Fatalf("TOC %#x: expected each sample held for %d, sample %d differs", toc, repeat, j/2)
+				}
+			}
+		}
+	}
+}
+
+// oggCRC computes the Ogg page checksum: CRC-32 with polynomial 0x04C11DB7,
+// unreflected and with a zero initial value
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// oggPage encodes packets, each shorter than 255 bytes, as one Ogg page
+func oggPage(headerType byte, granule uint64, sequence uint32, packets ...[]byte) []byte {
+	page := make([]byte, 27, 27+len(packets))
+	copy(page, "OggS")
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], 1) // stream serial
+	binary.LittleEndian.PutUint32(page[18:], sequence)
+	page[26] = byte(len(packets))
+	for _, packet := range packets {
+		page = append(page, byte(len(packet)))
+	}
+	for _, packet := range packets {
+		page = append(page, packet...)
+	}
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+	return page
+}
+
+func TestDetectFormatReader(t *testing.T) {
+	dir := t.TempDir()
+
+	// FLAC content behind an MP3 extension
+	flacFile := filepath.Join(dir, "mislabeled.mp3")
+	writeToneFLAC(t, flacFile, 20000, nil)
+	// WAV content behind an unrelated extension
+	wavFile := filepath.Join(dir, "recording.dat")
+	f, err := os.Create(wavFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := wav.NewEncoder(f, 44100, 16, 2, 1)
+	if err := enc.Write(stereoBuffer(16)); err != nil {
+		t.Fatal(err)
+	}
+	enc.Close()
+	f.Close()
+	opusFile := filepath.Join(dir, "tiny.ogg")
+	if err := os.WriteFile(opusFile, tinyOpus, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for filename, want := range map[string]AudioFormat{flacFile: FormatFLAC, wavFile: FormatWAV, opusFile: FormatOpus} {
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := DetectFormatReader(f); got != want {
+			t.Errorf("%s: expected %s, got %s", filepath.Base(filename), want, got)
+		}
+		if pos, _ := f.Seek(0, io.SeekCurrent); pos != 0 {
+			t.Errorf("%s: expected the reader to be rewound, at offset %d", filepath.Base(filename), pos)
+		}
+		f.Close()
+
+		decoder, err := NewAudioDecoder(filename)
+		if err != nil {
+			t.Errorf("NewAudioDecoder(%s) failed: %v", filepath.Base(filename), err)
+			continue
+		}
+		decoder.Close()
+	}
+
+	for header, want := range map[string]AudioFormat{
+		"ID3\x04\x00":              FormatMP3,
+		"\xff\xfb\x90\x00":         FormatMP3,
+		"\xff\xf1\x50\x80":         FormatUnknown,
+		"FORM\x00\x00\x00\x00AIFC": FormatAIFF,
+		"text":                     FormatUnknown,
+	} {
+		if got := DetectFormatReader(strings.NewReader(header)); got != want {
+			t.Errorf("%q: expected %s, got %s", header, want, got)
+		}
+	}
+}
+
+func TestOpusDuration(t *testing.T) {
+	// Reuse the 20ms audio packet of the tiny clip, which sits on its third page
+	ogg, _, err := oggreader.NewWith(bytes.NewReader(tinyOpus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, _, err := ogg.ParseNextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	audioPacket, _, err := ogg.ParseNextPage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 50 packets of 960 samples over 5 pages, the final one padded by 500 samples
+	const preSkip, packets, padding = 312, 50, 500
+	head := []byte("OpusHead\x01\x01\x00\x00\x80\xbb\x00\x00\x00\x00\x00")
+	binary.LittleEndian.PutUint16(head[10:], preSkip)
+
+	data := oggPage(0x02, 0, 0, head)
+	data = append(data, oggPage(0, 0, 1, tags[0])...)
+	for page := 0; page < 5; page++ {
+		granule := uint64((page + 1) * 10 * 960)
+		headerType := byte(0)
+		if page == 4 {
+			granule -= padding
+			headerType = 0x04
+		}
+		data = append(data, oggPage(headerType, granule, uint32(page+2), slices.Repeat([][]byte{audioPacket[0]}, 10)...)...)
+	}
+
+	filename := filepath.Join(t.TempDir(), "second.opus")
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewFromAudioFile(filename, nil)
+	if err != nil {
+		t.Fatalf("NewFromAudioFile failed: %v", err)
+	}
+
+	expected := packets*960 - padding - preSkip
+	if w.SampleCount() != expected {
+		t.Errorf("Expected %d samples, got %d", expected, w.SampleCount())
+	}
+	if d, want := w.Duration(), time.Duration(expected)*time.Second/48000; d != want {
+		t.Errorf("Expected a duration of %v, got %v", want, d)
+	}
+}
+
+func TestOggPacketsAcrossPages(t *testing.T) {
+	full := bytes.Repeat([]byte{1}, 255)
+
+	// A 300-byte packet split over two pages, followed by a short one
+	packets, partial := oggPackets(nil, nil, [][]byte{full})
+	if len(packets) != 0 || len(partial) != 255 {
+		t.Fatalf("Expected the packet to continue, got %d packets and %d pending bytes", len(packets), len(partial))
+	}
+	packets, partial = oggPackets(packets, partial, [][]byte{make([]byte, 45), {7, 8}})
+	if len(packets) != 2 || len(packets[0]) != 300 || len(packets[1]) != 2 || partial != nil {
+		t.Errorf("Expected packets of 300 and 2 bytes, got %d packets with %d pending bytes", len(packets), len(partial))
+	}
+}
+
+// goldenSamples returns the source of TestCrossFormatGolden: three seconds of
+// 44.1kHz stereo with a 440 Hz tone swelling on the left and a 660 Hz tone
+// fading on the right, so a decoder that swaps or drops channels can't pass
+func goldenSamples() []int16 {
+	const rate = 44100
+	samples := make([]int16, 2*3*rate)
+	for i := 0; i < len(samples)/2; i++ {
+		t := float64(i) / rate
+		samples[2*i] = int16(32767 * (0.1 + 0.8*t/3) * math.Sin(2*math.Pi*440*t))
+		samples[2*i+1] = int16(32767 * (0.8 - 0.6*t/3) * math.Sin(2*math.Pi*660*t))
+	}
+	return samples
+}
+
+// writeGoldenPCM writes samples as 44.1kHz stereo with go-audio's WAV or AIFF
+// encoder at the given bit depth
+func writeGoldenPCM(t *testing.T, path string, samples []int16, bitDepth int) {
+	t.Helper()
+
+	buf := &audio.IntBuffer{
+		Format:         &audio.Format{NumChannels: 2, SampleRate: 44100},
+		SourceBitDepth: bitDepth,
+		Data:           make([]int, len(samples)),
+	}
+	for i, s := range samples {
+		buf.Data[i] = int(s) << (bitDepth - 16)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", filepath.Base(path), err)
+	}
+	defer f.Close()
+
+	var enc interface {
+		Write(*audio.IntBuffer) error
+		Close() error
+	}
+	if filepath.Ext(path) == ".aiff" {
+		enc = aiff.NewEncoder(f, 44100, bitDepth, 2)
+	} else {
+		enc = wav.NewEncoder(f, 44100, bitDepth, 2, 1)
+	}
+	if err := enc.Write(buf); err != nil {
+		t.Fatalf("Failed to write %s: %v", filepath.Base(path), err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatalf("Failed to finish %s: %v", filepath.Base(path), err)
+	}
+}
+
+// normalizedPeaks returns peaks scaled so the loudest is 1, the way they are drawn
+func normalizedPeaks(peaks []float64) []float64 {
+	out := make([]float64, len(peaks))
+	if maxPeak := peakMax(peaks); maxPeak > 0 {
+		for i, peak := range peaks {
+			out[i] = peak / maxPeak
+		}
+	}
+	return out
+}
+
+// goldenLevels returns the RMS level of each of bars equal parts of
+// goldenSamples for channel, worked out from the envelopes of its tones rather
+// than from the samples. A tone with amplitude a has a mean square of a²/2, and
+// over a linear swell from a0 to a1, a² averages (a0²+a0a1+a1²)/3. The 440 and
+// 660 Hz tones run whole cycles in every 100ms bar, so in the mix, (L+R)/2,
+// they don't interfere and their mean squares add up.
+func goldenLevels(channel Channel, bars int) []float64 {
+	meanSquare := func(a0, a1 float64) float64 {
+		return (a0*a0 + a0*a1 + a1*a1) / 3 / 2
+	}
+	left := func(t float64) float64 { return 0.1 + 0.8*t/3 }
+	right := func(t float64) float64 { return 0.8 - 0.6*t/3 }
+
+	levels := make([]float64, bars)
+	for i := range levels {
+		t0, t1 := 3*float64(i)/float64(bars), 3*float64(i+1)/float64(bars)
+		l, r := meanSquare(left(t0), left(t1)), meanSquare(right(t0), right(t1))
+		switch channel {
+		case ChannelLeft:
+			levels[i] = math.Sqrt(l)
+		case ChannelRight:
+			levels[i] = math.Sqrt(r)
+		default:
+			levels[i] = math.Sqrt((l + r) / 4)
+		}
+	}
+	return levels
+}
+
+// goldenVorbisLevels are the normalized RMS levels of ten bars of
+// testdata/vorbis.ogg, computed from the PCM libvorbis decodes it to, as
+// shipped next to it in the jfreymuth/oggvorbis test data
+var goldenVorbisLevels = []float64{1, 0.4217, 0.8979, 0.6182, 0.7718, 0.7727, 0.6244, 0.8952, 0.4205, 0.9916}
+
+// mp3Levels returns the RMS level of each of bars equal parts of the left
+// channel go-mp3 decodes filename to, read straight from the library
+func mp3Levels(t *testing.T, filename string, bars int) []float64 {
+	t.Helper()
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	decoder, err := mp3.NewDecoder(f)
+	if err != nil {
+		t.Fatalf("mp3.NewDecoder failed: %v", err)
+	}
+	data, err := io.ReadAll(decoder)
+	if err != nil {
+		t.Fatalf("Decoding %s failed: %v", filepath.Base(filename), err)
+	}
+
+	frames := len(data) / 4
+	levels := make([]float64, bars)
+	for i := range levels {
+		start, end := i*frames/bars, (i+1)*frames/bars
+		var sum float64
+		for f := start; f < end; f++ {
+			s := float64(int16(binary.LittleEndian.Uint16(data[4*f:]))) / 32768
+			sum += s * s
+		}
+		levels[i] = math.Sqrt(sum / float64(end-start))
+	}
+	return levels
+}
+
+// TestCrossFormatGolden checks the drawn peaks of every format and channel
+// choice against references worked out without the package's own decoding,
+// channel selection or downsampling. Peaks are compared per bar in RMS mode.
+//
+// The lossless encodings of goldenSamples are written by the test and must
+// match goldenLevels to within rounding to 16 bits, at their absolute level,
+// so a mix that isn't halved shows up too. There are no Go encoders
+// for the lossy formats, so their fixtures are small clips from the test data
+// of the decoders' own modules:
+//
+//   - testdata/vorbis.ogg is test.ogg of jfreymuth/oggvorbis (MIT license), a
+//     second of mono, checked against goldenVorbisLevels
+//   - testdata/speech.mp3 is the first three seconds of example/mpeg2.mp3 of
+//     hajimehoshi/go-mp3, mono speech in the public domain, checked against
+//     go-mp3's raw output
+//
+// Lossy codecs may change the overall level a little, so their peaks are
+// normalized to the loudest bar. Both fixtures are mono, so every channel
+// choice draws the same. Opus is covered by
+// TestOpusDecoderOgg, as its only fixture is a single 20ms packet.
+func TestCrossFormatGolden(t *testing.T) {
+	const tolerance = 0.002
+
+	source := goldenSamples()
+	dir := t.TempDir()
+	lossless := []struct {
+		name string
+		path string
+	}{
+		{"wav16", filepath.Join(dir, "golden16.wav")},
+		{"wav24", filepath.Join(dir, "golden24.wav")},
+		{"aiff16", filepath.Join(dir, "golden16.aiff")},
+		{"flac", filepath.Join(dir, "golden.flac")},
+	}
+	writeGoldenPCM(t, lossless[0].path, source, 16)
+	writeGoldenPCM(t, lossless[1].path, source, 24)
+	writeGoldenPCM(t, lossless[2].path, source, 16)
+	left, right := make([]int32, len(source)/2), make([]int32, len(source)/2)
+	for i := range left {
+		left[i], right[i] = int32(source[2*i]), int32(source[2*i+1])
+	}
+	writeFLAC(t, lossless[3].path, [][]int32{left, right}, nil)
+
+	check := func(t *testing.T, path string, bars int, normalize bool, reference func(Channel) []float64) {
+		for _, channel := range []Channel{ChannelMix, ChannelLeft, ChannelRight} {
+			config := DefaultConfig()
+			config.Bars = bars
+			config.Mode = ModeRMS
+			config.Concurrent = false
+			config.Channel = channel
+			w, err := NewFromAudioFile(path, config)
+			if err != nil {
+				t.Fatalf("NewFromAudioFile(%s) failed: %v", channel, err)
+			}
+
+			want, got := reference(channel), w.Peaks
+			if normalize {
+				want, got = normalizedPeaks(want), normalizedPeaks(got)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("Channel %s: expected %d bars, got %d", channel, len(want), len(got))
+			}
+			for i := range want {
+				if diff := math.Abs(got[i] - want[i]); diff > tolerance {
+					t.Errorf("Channel %s bar %d: expected %.4f, got %.4f (off by %.4f)", channel, i, want[i], got[i], diff)
+				}
+			}
+		}
+	}
+
+	for _, fixture := range lossless {
+		t.Run(fixture.name, func(t *testing.T) {
+			// goldenSamples scales its tones to 32767 rather than the full 32768
+			check(t, fixture.path, 30, false, func(channel Channel) []float64 {
+				levels := goldenLevels(channel, 30)
+				for i := range levels {
+					levels[i] *= 32767.0 / 32768
+				}
+				return levels
+			})
+		})
+	}
+
+	t.Run("ogg", func(t *testing.T) {
+		check(t, filepath.Join("testdata", "vorbis.ogg"), len(goldenVorbisLevels), true, func(Channel) []float64 {
+			return goldenVorbisLevels
+		})
+	})
+
+	t.Run("mp3", func(t *testing.T) {
+		path := filepath.Join("testdata", "speech.mp3")
+		levels := mp3Levels(t, path, 15)
+		check(t, path, 15, true, func(Channel) []float64 {
+			return levels
+		})
+	})
+}
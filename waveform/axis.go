@@ -0,0 +1,94 @@
+package waveform
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	// amplitudeAxisWidth is the space in pixels reserved left of the waveform for the amplitude axis
+	amplitudeAxisWidth = 36
+	// amplitudeAxisColor is the color of the amplitude axis, its ticks and labels
+	amplitudeAxisColor = "#6B7280"
+)
+
+// defaultAmplitudeTicks are the dB levels marked when AmplitudeAxisTicks is empty
+var defaultAmplitudeTicks = []float64{0, -3, -6, -12, -18}
+
+// amplitudeTickYs returns the y positions, growing downwards, at which bars
+// reach level dB below full scale: once on either side of the midline for
+// centred bars, once for edge-aligned ones. Levels above full scale or below
+// the minimum bar height have none.
+func amplitudeTickYs(level float64, config *Config) []float64 {
+	// Bar heights are relative to full scale, whatever it was normalized to
+	relative := math.Pow(10, level/20)
+	if config.AmplitudeScale == ScaleLog {
+		relative = dbLevel(relative, config.DBFloor)
+	}
+	relative = math.Pow(relative, barGamma(config))
+	maxHeight := float64(config.Height) * 0.48
+	h := relative * maxHeight
+	if level > 0 || h < minBarHeight(config) {
+		return nil
+	}
+
+	height := float64(config.Height)
+	switch config.Alignment {
+	case AlignBottom:
+		return []float64{height - h*2}
+	case AlignTop:
+		return []float64{h * 2}
+	default:
+		return []float64{height/2 - h, height/2 + h}
+	}
+}
+
+// addAmplitudeAxis widens the SVG by amplitudeAxisWidth and draws a vertical
+// axis in the new space on the left, with a labelled tick at each level of
+// AmplitudeAxisTicks. StereoSplit and DeviationView renders get no axis, as
+// their bar heights don't follow the levels.
+func (w *Waveform) addAmplitudeAxis(data []byte) []byte {
+	config := w.Config
+	if (config.StereoSplit && len(w.channels) == 2) || config.DeviationView {
+		return data
+	}
+
+	openEnd := bytes.IndexByte(data, '>') + 1
+	closeStart := bytes.LastIndex(data, []byte("</svg>"))
+	if openEnd <= 0 || closeStart < openEnd {
+		return data
+	}
+
+	ticks := config.AmplitudeAxisTicks
+	if len(ticks) == 0 {
+		ticks = defaultAmplitudeTicks
+	}
+
+	width := config.Width + amplitudeAxisWidth
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg version="1.1" width="%dmm" height="%dmm" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">`,
+		width, config.Height, width, config.Height)
+
+	fmt.Fprintf(&buf, `<g class="waveform-axis" font-family="sans-serif" font-size="9" fill="%s" text-anchor="end">`, amplitudeAxisColor)
+	fmt.Fprintf(&buf, `<line x1="%d" y1="0" x2="%d" y2="%d" stroke="%s" stroke-width="1"/>`,
+		amplitudeAxisWidth-1, amplitudeAxisWidth-1, config.Height, amplitudeAxisColor)
+	for _, level := range ticks {
+		label := strconv.FormatFloat(level, 'f', -1, 64) + " dB"
+		for _, y := range amplitudeTickYs(level, config) {
+			fmt.Fprintf(&buf, `<line x1="%d" y1="%s" x2="%d" y2="%s" stroke="%s" stroke-width="1"/>`,
+				amplitudeAxisWidth-5, svgNumber(y), amplitudeAxisWidth-1, svgNumber(y), amplitudeAxisColor)
+			// Keep labels of ticks near the edges inside the image
+			baseline := min(max(y+3, 8), float64(config.Height)-1)
+			fmt.Fprintf(&buf, `<text x="%d" y="%s">%s</text>`, amplitudeAxisWidth-7, svgNumber(baseline), label)
+		}
+	}
+	buf.WriteString(`</g>`)
+
+	fmt.Fprintf(&buf, `<g transform="translate(%d 0)">`, amplitudeAxisWidth)
+	buf.Write(data[openEnd:closeStart])
+	buf.WriteString(`</g>`)
+	buf.Write(data[closeStart:])
+	return applyRootAttributes(buf.Bytes(), config)
+}
@@ -0,0 +1,52 @@
+package waveform
+
+import (
+	"math"
+
+	"github.com/tdewolff/canvas"
+)
+
+// butterflyHalves splits peaks into the first and second half of the track.
+// The middle bar of an odd count belongs to neither.
+func butterflyHalves(peaks []float64) (first, second []float64) {
+	n := len(peaks) / 2
+	return peaks[:n], peaks[len(peaks)-n:]
+}
+
+// drawButterfly draws the first half of the track rising above the midline and
+// the second half hanging below it, bar for bar, so each bar is half as dense
+// as usual and spans the full width. Both halves share the loudest bar of the
+// track as full scale.
+func drawButterfly(ctx *canvas.Context, peaks []float64, config *Config) {
+	first, second := butterflyHalves(peaks)
+	if len(first) == 0 {
+		return
+	}
+
+	mid := float64(config.Height) / 2.0
+	// Each wing may fill its half, leaving the same margin as a centered bar
+	maxPeak := fullScale(peakMax(peaks), config)
+	scaleFactor := 0.0
+	if maxPeak > 0 {
+		scaleFactor = float64(config.Height) * 0.48 / maxPeak
+	}
+
+	// Quiet stretches still get a sliver on each side of the midline
+	const minHeight = 1.5
+
+	setBarFill(ctx, config)
+	for i := range first {
+		x, span := barSpan(i, len(first), config)
+		width := barInnerWidth(span, config)
+		if width <= 0 {
+			continue
+		}
+
+		up := math.Max(math.Min(applyAmplitudeScale(first[i], maxPeak, config)*scaleFactor, mid*0.96), minHeight)
+		down := math.Max(math.Min(applyAmplitudeScale(second[i], maxPeak, config)*scaleFactor, mid*0.96), minHeight)
+
+		// The canvas y axis points up, so the bar starts below the midline
+		rad := clampRadius(config.CornerRadius, width, up+down)
+		ctx.DrawPath(x, mid-down, canvas.RoundedRectangle(width, up+down, rad))
+	}
+}
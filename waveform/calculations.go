@@ -1,9 +1,69 @@
 package waveform
 
-import "unsafe"
+import (
+	"context"
+	"math"
+	"sync"
+)
+
+// LoudnessFunc returns the loudness of samples[start:end], ideally reaching 1
+// at full scale like the Peak and RMS modes, so Config.MaxAmplitude and
+// Config.SilenceThreshold mean the same with it. It is set as Config.CustomMode
+// or registered with RegisterMode. With Config.Concurrent it is
+// called from several goroutines at once on the same samples, so it must be
+// reentrant: it may read samples but not write them, and must not keep state
+// between calls without locking it.
+type LoudnessFunc func(samples []int16, start, end int) float64
+
+var (
+	customModesMu sync.RWMutex
+	customModes   = map[CalculationMode]LoudnessFunc{}
+)
+
+// RegisterMode makes fn available as calculation mode name, so Config.Mode can
+// select algorithms such as an A-weighted loudness without forking the
+// package. Custom modes are consulted before the built-in ones, so registering
+// a built-in name replaces it. Passing a nil fn removes a registration.
+func RegisterMode(name CalculationMode, fn LoudnessFunc) {
+	customModesMu.Lock()
+	defer customModesMu.Unlock()
+
+	if fn == nil {
+		delete(customModes, name)
+		return
+	}
+	customModes[name] = fn
+}
+
+// customMode returns the function registered for mode, if any
+func customMode(mode CalculationMode) (LoudnessFunc, bool) {
+	customModesMu.RLock()
+	defer customModesMu.RUnlock()
+
+	fn, ok := customModes[mode]
+	return fn, ok
+}
+
+// modeFunc returns the function measuring a bar in mode: custom for
+// ModeCustom, the function registered for mode, or the built-in calculation
+func modeFunc(mode CalculationMode, custom LoudnessFunc) LoudnessFunc {
+	if mode == ModeCustom && custom != nil {
+		return custom
+	}
+	if fn, ok := customMode(mode); ok {
+		return fn
+	}
+	return func(samples []int16, start, end int) float64 {
+		return calculateLoudness(samples, start, end, mode)
+	}
+}
 
 // calculateLoudness calculates loudness based on the selected mode
 func calculateLoudness(samples []int16, start, end int, mode CalculationMode) float64 {
+	if fn, ok := customMode(mode); ok {
+		return fn(samples, start, end)
+	}
+
 	switch mode {
 	case ModeRMS:
 		return calculateRMS(samples, start, end)
@@ -17,6 +77,12 @@ func calculateLoudness(samples []int16, start, end int, mode CalculationMode) fl
 		return calculateDynamic(samples, start, end)
 	case ModeSmooth:
 		return calculateSmooth(samples, start, end)
+	case ModeMAD:
+		return calculateMAD(samples, start, end)
+	case ModeOnset:
+		return calculateOnset(samples, start, end)
+	case ModeLUFSTrue:
+		return calculateLUFSTrue(samples, start, end)
 	default:
 		// Default to LUFS for unknown modes
 		return calculateLUFS(samples, start, end)
@@ -61,24 +127,28 @@ func calculateLUFS(samples []int16, start, end int) float64 {
 	}
 
 	if bucketSize > 0 {
-		meanSquare := sum / float64(bucketSize)
-		// Convert to LUFS-like scale with exaggerated dynamics
-		lufs := fastSqrt(meanSquare)
-
-		// Apply additional dynamic enhancement
-		// Quiet parts become quieter, loud parts become louder
-		if lufs > 0.1 {
-			lufs = lufs * lufs * 2.0 // Emphasize loud parts more
-		} else {
-			lufs = lufs * 0.5 // Make quiet parts even quieter
-		}
-
-		return lufs
+		return lufsFromMeanSquare(sum / float64(bucketSize))
 	}
 
 	return 0
 }
 
+// lufsFromMeanSquare converts the mean of the weighted squares to the LUFS-like scale
+func lufsFromMeanSquare(meanSquare float64) float64 {
+	// Convert to LUFS-like scale with exaggerated dynamics
+	lufs := sqrt(meanSquare)
+
+	// Apply additional dynamic enhancement
+	// Quiet parts become quieter, loud parts become louder
+	if lufs > 0.1 {
+		lufs = lufs * lufs * 2.0 // Emphasize loud parts more
+	} else {
+		lufs = lufs * 0.5 // Make quiet parts even quieter
+	}
+
+	return lufs
+}
+
 // calculateRMS implements traditional RMS calculation for standard waveform representation
 func calculateRMS(samples []int16, start, end int) float64 {
 	if end <= start {
@@ -111,7 +181,7 @@ func calculateRMS(samples []int16, start, end int) float64 {
 	}
 
 	if bucketSize > 0 {
-		return fastSqrt(sum / float64(bucketSize))
+		return sqrt(sum / float64(bucketSize))
 	}
 
 	return 0
@@ -158,7 +228,7 @@ func calculateVU(samples []int16, start, end int) float64 {
 	}
 
 	if bucketSize > 0 {
-		vu := fastSqrt(sum / float64(bucketSize))
+		vu := sqrt(sum / float64(bucketSize))
 		// Apply VU meter ballistics (smooth response)
 		return vu * 1.2 // Slight boost for better visualization
 	}
@@ -200,8 +270,8 @@ func calculateDynamic(samples []int16, start, end int) float64 {
 	}
 
 	if bucketSize > 0 {
-		rms := fastSqrt(sum / float64(bucketSize))
-		dynamicFactor := fastSqrt(variance / float64(bucketSize))
+		rms := sqrt(sum / float64(bucketSize))
+		dynamicFactor := sqrt(variance / float64(bucketSize))
 
 		// Combine RMS with dynamic range factor
 		// High variance = more dynamic = emphasized
@@ -211,48 +281,298 @@ func calculateDynamic(samples []int16, start, end int) float64 {
 	return 0
 }
 
-// calculateSmooth implements smooth mode - heavily filtered for clean, minimal aesthetics
+// smoothingFactor is how much of its previous level the smooth mode filter
+// keeps with each sample: heavy smoothing
+const smoothingFactor = 0.95
+
+// smoothFilter is the exponential filter smooth mode runs over the rectified
+// signal. It keeps its level between calls, so filtering neighbouring ranges
+// one after the other is one continuous filter.
+type smoothFilter struct {
+	level float64
+}
+
+// sumSquares filters samples[start:end] and returns the sum of the squared
+// filtered levels
+func (f *smoothFilter) sumSquares(samples []int16, start, end int) float64 {
+	const invMaxSample = 1.0 / 32768.0
+
+	var sum float64
+	level := f.level
+	for i := start; i < end; i++ {
+		val := float64(samples[i]) * invMaxSample
+		if val < 0 {
+			val = -val
+		}
+
+		// Apply exponential smoothing
+		level = smoothingFactor*level + (1.0-smoothingFactor)*val
+		sum += level * level
+	}
+	f.level = level
+	return sum
+}
+
+// smoothLoudness turns the sum of n squared filtered levels into a bar
+func smoothLoudness(sum float64, n int) float64 {
+	// Additional gentle compression for ultra-smooth appearance
+	return sqrt(sum/float64(n)) * 0.8
+}
+
+// calculateSmooth implements smooth mode - heavily filtered for clean, minimal
+// aesthetics - for a single bucket, starting the filter from silence. Whole
+// waveforms go through smoothPeaks instead.
 func calculateSmooth(samples []int16, start, end int) float64 {
 	if end <= start {
 		return 0
 	}
 
-	bucketSize := end - start
+	var f smoothFilter
+	return smoothLoudness(f.sumSquares(samples, start, end), end-start)
+}
+
+// smoothPeaks computes the smooth mode bars of samples spread over buckets,
+// stepping p once per bucket. The filter is recursive, so it runs once over
+// all samples in order, carrying its level across bucket boundaries; starting
+// it afresh in every bucket would dip the start of each bar while it settles.
+// Once ctx is done it stops, leaving the remaining buckets at 0.
+func smoothPeaks(ctx context.Context, samples []int16, buckets int, p *progress) []float64 {
+	peaks := make([]float64, buckets)
+
+	var f smoothFilter
+	for bucket := 0; bucket < buckets && ctx.Err() == nil; bucket++ {
+		start, end := bucketBounds(bucket, buckets, len(samples))
+		if start < end {
+			peaks[bucket] = smoothLoudness(f.sumSquares(samples, start, end), end-start)
+		}
+		p.step(buckets)
+	}
+	return peaks
+}
+
+// filtersAcrossBuckets reports whether mode is the built-in smooth mode, whose
+// filter has to run over the whole signal in order rather than bucket by bucket
+func filtersAcrossBuckets(mode CalculationMode) bool {
+	if _, ok := customMode(mode); ok {
+		return false
+	}
+	return mode == ModeSmooth
+}
+
+// bucketSums holds the partial sums of one slice of a bucket. Sums over
+// neighbouring slices can be merged, so a single huge bucket can be split
+// across workers and combined afterwards.
+type bucketSums struct {
+	count   int
+	sumAbs  float64
+	sumSq   float64
+	sumLUFS float64
+	peak    float64
+}
+
+// merge combines the partial sums of two slices of the same bucket
+func (b bucketSums) merge(o bucketSums) bucketSums {
+	b.count += o.count
+	b.sumAbs += o.sumAbs
+	b.sumSq += o.sumSq
+	b.sumLUFS += o.sumLUFS
+	if o.peak > b.peak {
+		b.peak = o.peak
+	}
+	return b
+}
+
+// accumulateSums computes the partial sums for samples[start:end]. The LUFS
+// pre-emphasis filter is seeded with the sample preceding the slice, unless the
+// slice starts the bucket, so the split result matches a single serial pass.
+func accumulateSums(samples []int16, bucketStart, start, end int) bucketSums {
 	const invMaxSample = 1.0 / 32768.0
 
+	var sums bucketSums
+	var prevSample float64
+	if start > bucketStart {
+		prevSample = float64(samples[start-1]) * invMaxSample
+	}
+
+	for i := start; i < end; i++ {
+		sample := float64(samples[i]) * invMaxSample
+		sums.add(sample, prevSample)
+		prevSample = sample
+	}
+
+	return sums
+}
+
+// add accumulates one normalized sample, with the sample before it feeding the
+// LUFS pre-emphasis filter
+func (b *bucketSums) add(sample, prevSample float64) {
+	abs := sample
+	if abs < 0 {
+		abs = -abs
+	}
+
+	b.count++
+	b.sumAbs += abs
+	b.sumSq += sample * sample
+	if abs > b.peak {
+		b.peak = abs
+	}
+
+	filtered := sample - 0.85*prevSample
+	if filtered < 0 {
+		filtered = -filtered
+	}
+	b.sumLUFS += filtered * filtered * (1.0 + filtered*0.5)
+}
+
+// loudness turns merged bucket sums into the value of the given mode
+func (b bucketSums) loudness(mode CalculationMode) float64 {
+	if b.count == 0 {
+		return 0
+	}
+	n := float64(b.count)
+
+	switch mode {
+	case ModeRMS:
+		return sqrt(b.sumSq / n)
+	case ModePeak:
+		return b.peak
+	case ModeVU:
+		return sqrt(b.sumSq*0.8/n) * 1.2
+	case ModeMAD:
+		return b.sumAbs / n
+	case ModeDynamic:
+		mean := b.sumAbs / n
+		variance := b.sumSq - n*mean*mean
+		return sqrt(b.sumSq/n) * (1.0 + sqrt(variance/n)*2.0)
+	default:
+		return lufsFromMeanSquare(b.sumLUFS / n)
+	}
+}
+
+// supportsSplitBuckets reports whether a mode can be computed from merged partial sums.
+// Smooth mode runs a filter across the whole bucket and has to stay serial.
+func supportsSplitBuckets(mode CalculationMode) bool {
+	// Custom modes only see whole buckets
+	if _, ok := customMode(mode); ok {
+		return false
+	}
+
+	switch mode {
+	case ModeRMS, ModeLUFS, ModePeak, ModeVU, ModeDynamic, ModeMAD:
+		return true
+	default:
+		return false
+	}
+}
+
+// calculateLoudnessParallel computes the loudness of one large bucket by splitting
+// it across workers that each compute partial sums, then combining the results
+func calculateLoudnessParallel(samples []int16, start, end int, mode CalculationMode, numWorkers int) float64 {
+	if numWorkers < 2 || !supportsSplitBuckets(mode) || end-start < numWorkers {
+		return calculateLoudness(samples, start, end, mode)
+	}
+
+	chunkSize := (end - start + numWorkers - 1) / numWorkers
+	partials := make([]bucketSums, numWorkers)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < numWorkers; worker++ {
+		chunkStart := start + worker*chunkSize
+		chunkEnd := chunkStart + chunkSize
+		if chunkEnd > end {
+			chunkEnd = end
+		}
+		if chunkStart >= chunkEnd {
+			break
+		}
+
+		workers.acquire()
+		wg.Add(1)
+		go func(worker, chunkStart, chunkEnd int) {
+			defer wg.Done()
+			defer workers.release()
+			partials[worker] = accumulateSums(samples, start, chunkStart, chunkEnd)
+		}(worker, chunkStart, chunkEnd)
+	}
+	wg.Wait()
+
+	var total bucketSums
+	for _, partial := range partials {
+		total = total.merge(partial)
+	}
+
+	return total.loudness(mode)
+}
+
+// calculateMAD implements the mean absolute value envelope - cheaper than RMS with a softer look
+func calculateMAD(samples []int16, start, end int) float64 {
+	if end <= start {
+		return 0
+	}
+
+	const invMaxSample = 1.0 / 32768.0
 	var sum float64
-	var smoothedPrev float64 = 0.0
-	const smoothingFactor = 0.95 // Heavy smoothing
 
 	for i := start; i < end; i++ {
 		val := float64(samples[i]) * invMaxSample
 		if val < 0 {
 			val = -val
 		}
+		sum += val
+	}
 
-		// Apply exponential smoothing
-		smoothedPrev = smoothingFactor*smoothedPrev + (1.0-smoothingFactor)*val
-		sum += smoothedPrev * smoothedPrev
+	return sum / float64(end-start)
+}
+
+// onsetFrameSize is the number of samples per energy frame in onset mode
+const onsetFrameSize = 256
+
+// calculateOnset implements an onset strength envelope - the largest rise in RMS
+// between consecutive frames, so attacks spike while sustained sounds stay low.
+// The frame just before the bucket is used as the reference for its first frame.
+func calculateOnset(samples []int16, start, end int) float64 {
+	if end <= start {
+		return 0
 	}
 
-	if bucketSize > 0 {
-		smooth := fastSqrt(sum / float64(bucketSize))
-		// Additional gentle compression for ultra-smooth appearance
-		return smooth * 0.8
+	frame := onsetFrameSize
+	if end-start < frame {
+		frame = end - start
 	}
 
-	return 0
+	prev := 0.0
+	if start >= frame {
+		prev = calculateRMS(samples, start-frame, start)
+	}
+
+	var onset float64
+	for i := start; i < end; i += frame {
+		frameEnd := i + frame
+		if frameEnd > end {
+			frameEnd = end
+		}
+
+		rms := calculateRMS(samples, i, frameEnd)
+		if rise := rms - prev; rise > onset {
+			onset = rise
+		}
+		prev = rms
+	}
+
+	return onset
 }
 
-// fastSqrt implements fast approximate square root using bit manipulation (Quake III algorithm variant)
+// fastSqrt implements fast approximate square root using bit manipulation (Quake III algorithm variant).
+// The single Newton-Raphson step leaves an error of up to ~0.2%.
 func fastSqrt(x float64) float64 {
 	if x <= 0 {
 		return 0
 	}
 	// Use bit manipulation for faster square root approximation
-	i := *(*uint64)(unsafe.Pointer(&x))
+	i := math.Float64bits(x)
 	i = 0x5fe6eb50c7b537a9 - (i >> 1) // Magic number for double precision
-	y := *(*float64)(unsafe.Pointer(&i))
+	y := math.Float64frombits(i)
 	// One Newton-Raphson iteration for better accuracy
 	y = y * (1.5 - 0.5*x*y*y)
 	return 1.0 / y
@@ -0,0 +1,98 @@
+package waveform
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"time"
+)
+
+// clipMinSamples is how many full-scale samples a bucket needs to count as clipped.
+// A lone sample at full scale is usually a legitimate peak; clipping flattens runs of them.
+const clipMinSamples = 3
+
+// ClipRegion is a run of adjacent clipped bars, from Start up to but excluding End
+type ClipRegion struct {
+	Start int
+	End   int
+}
+
+// ClipRegions returns the runs of bars whose audio clips, i.e. holds several samples
+// at full scale. Adjacent clipped bars are merged into a single region. Returns nil
+// if the waveform holds no samples; see Config.RetainSamples.
+func (w *Waveform) ClipRegions() []ClipRegion {
+	return clipRegions(clippedBuckets(w.samples, len(w.Peaks)))
+}
+
+// clippedBuckets flags each bucket that holds at least clipMinSamples full-scale
+// samples, splitting the samples into buckets the same way as downsample
+func clippedBuckets(samples []int16, buckets int) []bool {
+	if len(samples) == 0 || buckets == 0 {
+		return nil
+	}
+
+	clipped := make([]bool, buckets)
+	for bucket := range clipped {
+		start, end := bucketBounds(bucket, buckets, len(samples))
+
+		count := 0
+		for _, s := range samples[start:end] {
+			if s >= 32767 || s <= -32767 {
+				count++
+			}
+		}
+		clipped[bucket] = count >= clipMinSamples
+	}
+	return clipped
+}
+
+// clipRegions merges runs of clipped buckets into regions
+func clipRegions(clipped []bool) []ClipRegion {
+	var regions []ClipRegion
+	for i := 0; i < len(clipped); i++ {
+		if !clipped[i] {
+			continue
+		}
+		start := i
+		for i < len(clipped) && clipped[i] {
+			i++
+		}
+		regions = append(regions, ClipRegion{Start: start, End: i})
+	}
+	return regions
+}
+
+// clipOverlayColor is the color of the bands marking clipped regions
+const clipOverlayColor = "#EF4444"
+
+// addClipOverlays draws a translucent red band over each clipped region, labelled
+// with its time range when the sample rate is known and "clipped" otherwise
+func (w *Waveform) addClipOverlays(data []byte) []byte {
+	regions := w.ClipRegions()
+	closeStart := bytes.LastIndex(data, []byte("</svg>"))
+	if len(regions) == 0 || closeStart < 0 {
+		return data
+	}
+
+	var buf bytes.Buffer
+	buf.Write(data[:closeStart])
+	buf.WriteString(`<g class="waveform-clips">`)
+	for _, region := range regions {
+		x, _ := barSpan(region.Start, len(w.Peaks), w.Config)
+		end, _ := barSpan(region.End, len(w.Peaks), w.Config)
+		startSample, _ := bucketBounds(region.Start, len(w.Peaks), len(w.samples))
+		_, endSample := bucketBounds(region.End-1, len(w.Peaks), len(w.samples))
+		label := "clipped"
+		if start, end := w.info.duration(startSample), w.info.duration(endSample); end > 0 {
+			label = fmt.Sprintf("clipped %s–%s", start.Round(time.Millisecond*100), end.Round(time.Millisecond*100))
+		}
+
+		fmt.Fprintf(&buf, `<rect x="%s" y="0" width="%s" height="%d" fill="%s" fill-opacity="0.3"/>`,
+			svgNumber(x), svgNumber(end-x), w.Config.Height, clipOverlayColor)
+		fmt.Fprintf(&buf, `<text x="%s" y="10" font-family="sans-serif" font-size="9" fill="%s">%s</text>`,
+			svgNumber(x+2), clipOverlayColor, html.EscapeString(label))
+	}
+	buf.WriteString(`</g>`)
+	buf.Write(data[closeStart:])
+	return buf.Bytes()
+}
@@ -0,0 +1,75 @@
+package waveform
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+)
+
+// comparisonLabelHeight is the height in pixels of the label strip above each comparison row
+const comparisonLabelHeight = 16
+
+// GenerateComparison renders the waveform once per mode from the retained samples
+// and stacks the results into a single SVG, one labeled row per mode. Each row uses
+// config (or the waveform's own config when nil) with only the mode swapped out.
+func (w *Waveform) GenerateComparison(modes []CalculationMode, config *Config) ([]byte, error) {
+	if config == nil {
+		config = w.Config
+	}
+	if w.samples == nil {
+		return nil, fmt.Errorf("no samples retained to compare modes; set Config.RetainSamples")
+	}
+
+	rows := make([][]byte, len(modes))
+	for i, mode := range modes {
+		rowConfig := *config
+		rowConfig.Mode = mode
+
+		peaks := computePeaks(context.Background(), w.samples, w.info, rowConfig.Bars, mode, rowConfig.CustomMode, rowConfig.Concurrent, nil)
+
+		row, err := renderSVG(peaks, &rowConfig)
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = row
+	}
+
+	return addAccessibleText(applyRootAttributes(stackRows(rows, modes, config), config), config), nil
+}
+
+// stackRows combines separately rendered SVG documents into one, placing each
+// below a text label. Gradient ids are prefixed per row so they stay unique.
+func stackRows(rows [][]byte, modes []CalculationMode, config *Config) []byte {
+	rowHeight := config.Height + comparisonLabelHeight
+	height := rowHeight * len(rows)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg version="1.1" width="%dmm" height="%dmm" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">`,
+		config.Width, height, config.Width, height)
+
+	for i, row := range rows {
+		top := i * rowHeight
+		fmt.Fprintf(&buf, `<text x="0" y="%d" font-family="sans-serif" font-size="12" fill="%s">%s</text>`,
+			top+comparisonLabelHeight-4, html.EscapeString(config.BarColor), html.EscapeString(string(modes[i])))
+
+		openEnd := bytes.IndexByte(row, '>') + 1
+		closeStart := bytes.LastIndex(row, []byte("</svg>"))
+		if openEnd <= 0 || closeStart < openEnd {
+			continue
+		}
+
+		prefix := fmt.Sprintf("r%d", i)
+		body := row[openEnd:closeStart]
+		body = bytes.ReplaceAll(body, []byte(`id="`), []byte(`id="`+prefix))
+		body = bytes.ReplaceAll(body, []byte(`url(#`), []byte(`url(#`+prefix))
+
+		fmt.Fprintf(&buf, `<g transform="translate(0 %d)">`, top+comparisonLabelHeight)
+		buf.Write(body)
+		buf.WriteString(`</g>`)
+	}
+
+	// Match renderSVG's trailing newline
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
@@ -0,0 +1,106 @@
+package waveform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+const (
+	// datVersion is the audiowaveform .dat format version written (single channel)
+	datVersion = 1
+	// datFlag8Bit marks the min/max pairs as 8-bit instead of 16-bit
+	datFlag8Bit = 0x1
+)
+
+// datHeader is the fixed header of an audiowaveform version 1 .dat file
+type datHeader struct {
+	Version         int32
+	Flags           uint32
+	SampleRate      int32
+	SamplesPerPixel int32
+	Length          uint32
+}
+
+// GenerateDat returns the waveform in the audiowaveform .dat format, with one
+// min/max pair per bar computed from the retained samples. Config.DatBits selects
+// the pair resolution like audiowaveform's --bits flag; 0 means 16. Channels are
+// mixed into a single min/max range. The sample rate is 0 for waveforms created
+// from raw samples.
+func (w *Waveform) GenerateDat() ([]byte, error) {
+	bits := w.Config.DatBits
+	if bits == 0 {
+		bits = 16
+	}
+	if bits != 8 && bits != 16 {
+		return nil, fmt.Errorf("unsupported .dat resolution: %d bits (must be 8 or 16)", bits)
+	}
+	if w.samples == nil {
+		return nil, fmt.Errorf("no samples retained to export; set Config.RetainSamples")
+	}
+
+	channels := w.info.channels
+	if channels <= 0 {
+		channels = 1
+	}
+
+	pairs := minMaxPairs(w.samples, w.Config.Bars)
+
+	header := datHeader{
+		Version:         datVersion,
+		SampleRate:      int32(w.info.sampleRate),
+		SamplesPerPixel: int32(len(w.samples) / channels / max(len(pairs), 1)),
+		Length:          uint32(len(pairs)),
+	}
+	if bits == 8 {
+		header.Flags |= datFlag8Bit
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	for _, pair := range pairs {
+		for _, v := range pair {
+			if bits == 8 {
+				buf.WriteByte(byte(int8(v >> 8)))
+			} else {
+				binary.Write(&buf, binary.LittleEndian, v)
+			}
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteDat writes the waveform to an audiowaveform .dat file
+func (w *Waveform) WriteDat(filename string) error {
+	data, err := w.GenerateDat()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0644)
+}
+
+// minMaxPairs splits samples into buckets and returns the minimum and maximum
+// sample of each, using the same bucket boundaries as downsample
+func minMaxPairs(samples []int16, buckets int) [][2]int16 {
+	if len(samples) == 0 || buckets == 0 {
+		return nil
+	}
+
+	pairs := make([][2]int16, buckets)
+	for bucket := range pairs {
+		start, end := bucketBounds(bucket, buckets, len(samples))
+		if start >= end {
+			continue
+		}
+
+		lo, hi := samples[start], samples[start]
+		for _, s := range samples[start+1 : end] {
+			lo = min(lo, s)
+			hi = max(hi, s)
+		}
+		pairs[bucket] = [2]int16{lo, hi}
+	}
+	return pairs
+}
@@ -0,0 +1,61 @@
+package waveform
+
+import (
+	"github.com/tdewolff/canvas"
+)
+
+// drawEnvelope draws peaks as one continuous shape instead of separate bars.
+// StyleFilled fills the area between the bar tips and, for centered bars, their
+// mirror image below the midline, or the edge they are anchored to. StyleLine
+// strokes the tips alone.
+func drawEnvelope(ctx *canvas.Context, peaks []float64, maxPeak float64, config *Config) {
+	// The tip and the anchored end of each bar, through its centre
+	tips := make([]canvas.Point, len(peaks))
+	bases := make([]canvas.Point, len(peaks))
+	for i, peak := range peaks {
+		x, span := barSpan(i, len(peaks), config)
+		y, length := barExtent(barHalfHeight(peak, maxPeak, config), config)
+
+		// The canvas y axis points up, so the tip of a top-aligned bar is its lower end
+		tip, base := y+length, y
+		if config.Alignment == AlignTop {
+			tip, base = y, y+length
+		}
+		centre := x + barInnerWidth(span, config)/2
+		tips[i] = canvas.Point{X: centre, Y: tip}
+		bases[i] = canvas.Point{X: centre, Y: base}
+	}
+
+	// Hold the first and last bars out to the edges so the shape spans the full width
+	width := float64(config.Width)
+	path := &canvas.Path{}
+	path.MoveTo(0, tips[0].Y)
+	for _, p := range tips {
+		path.LineTo(p.X, p.Y)
+	}
+	path.LineTo(width, tips[len(tips)-1].Y)
+
+	if config.Style == StyleLine {
+		lineWidth := config.LineWidth
+		if lineWidth <= 0 {
+			lineWidth = 2
+		}
+		ctx.SetFillColor(canvas.Transparent)
+		ctx.SetStroke(barPaint(config))
+		ctx.SetStrokeWidth(lineWidth)
+		ctx.SetStrokeJoiner(canvas.RoundJoin)
+		ctx.SetStrokeCapper(canvas.RoundCap)
+		ctx.DrawPath(0, 0, path)
+		ctx.SetStrokeColor(canvas.Transparent)
+		return
+	}
+
+	// Back along the other side to close the shape
+	path.LineTo(width, bases[len(bases)-1].Y)
+	for i := len(bases) - 1; i >= 0; i-- {
+		path.LineTo(bases[i].X, bases[i].Y)
+	}
+	path.LineTo(0, bases[0].Y)
+	path.Close()
+	ctx.DrawPath(0, 0, path)
+}
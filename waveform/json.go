@@ -0,0 +1,165 @@
+package waveform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// waveformJSON is the portable JSON form of a Waveform
+type waveformJSON struct {
+	Bars int             `json:"bars"`
+	Mode CalculationMode `json:"mode"`
+	// SampleRate and Channels are omitted for waveforms created from raw samples
+	SampleRate  int `json:"sampleRate,omitempty"`
+	Channels    int `json:"channels,omitempty"`
+	SampleCount int `json:"sampleCount,omitempty"`
+	// Duration is the playback length in seconds, omitted when unknown
+	Duration float64   `json:"duration,omitempty"`
+	Peaks    []float64 `json:"peaks"`
+	Config   *Config   `json:"config"`
+}
+
+// MarshalJSON encodes the waveform with its peaks normalized to 0..1, so
+// consumers don't need to know the absolute scale, along with the bar count,
+// calculation mode, sample rate, channel and sample counts, duration and full
+// configuration
+func (w *Waveform) MarshalJSON() ([]byte, error) {
+	peaks := make([]float64, len(w.Peaks))
+	if maxPeak := peakMax(w.Peaks); maxPeak > 0 {
+		for i, peak := range w.Peaks {
+			peaks[i] = peak / maxPeak
+		}
+	}
+
+	config := w.Config
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	return json.Marshal(waveformJSON{
+		Bars:        len(peaks),
+		Mode:        config.Mode,
+		SampleRate:  w.info.sampleRate,
+		Channels:    w.info.channels,
+		SampleCount: w.frames,
+		Duration:    w.duration.Seconds(),
+		Peaks:       peaks,
+		Config:      config,
+	})
+}
+
+// UnmarshalJSON decodes a waveform written by MarshalJSON. The result can be
+// rendered and resized, but holds no samples, so sample-based exports such as
+// GenerateDat are unavailable.
+func (w *Waveform) UnmarshalJSON(data []byte) error {
+	var v waveformJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Bars != len(v.Peaks) {
+		return fmt.Errorf("invalid waveform JSON: %d bars but %d peaks", v.Bars, len(v.Peaks))
+	}
+
+	if v.Config == nil {
+		v.Config = DefaultConfig()
+	}
+
+	*w = Waveform{
+		Peaks:    v.Peaks,
+		Config:   v.Config,
+		duration: time.Duration(v.Duration * float64(time.Second)),
+		frames:   v.SampleCount,
+		info:     streamInfo{sampleRate: v.SampleRate, channels: v.Channels},
+	}
+	return nil
+}
+
+// GenerateJSON returns the waveform as JSON; see MarshalJSON
+func (w *Waveform) GenerateJSON() ([]byte, error) {
+	return json.Marshal(w)
+}
+
+// WriteJSON writes the waveform to a JSON file
+func (w *Waveform) WriteJSON(filename string) error {
+	data, err := w.GenerateJSON()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0644)
+}
+
+// LoadJSON reconstructs a waveform and its configuration from JSON written by
+// GenerateJSON, without decoding the audio again
+func LoadJSON(data []byte) (*Waveform, error) {
+	w := &Waveform{}
+	if err := json.Unmarshal(data, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// channelsJSONVersion is the audiowaveform JSON format version written, the
+// first to hold more than one channel
+const channelsJSONVersion = 2
+
+// channelsJSON is the audiowaveform version 2 JSON format, read by stereo
+// players such as peaks.js
+type channelsJSON struct {
+	Version  int `json:"version"`
+	Channels int `json:"channels"`
+	// SampleRate is 0 for waveforms created from raw samples
+	SampleRate      int `json:"sample_rate"`
+	SamplesPerPixel int `json:"samples_per_pixel"`
+	Bits            int `json:"bits"`
+	Length          int `json:"length"`
+	// Data holds a min/max pair for each channel of each bar in turn:
+	// min and max of the first channel of bar 0, then of the second, and so on
+	Data []int16 `json:"data"`
+}
+
+// GenerateChannelsJSON returns the waveform in the audiowaveform version 2 JSON
+// format, with a 16-bit min/max pair for each channel of each bar, left then
+// right for stereo, computed from the retained samples like GenerateDat. Mono
+// audio yields a single channel.
+func (w *Waveform) GenerateChannelsJSON() ([]byte, error) {
+	if w.samples == nil {
+		return nil, fmt.Errorf("no samples retained to export; set Config.RetainSamples")
+	}
+
+	channels := max(w.info.channels, 1)
+	perChannel := make([][][2]int16, channels)
+	for c, samples := range splitChannels(w.samples, channels) {
+		perChannel[c] = minMaxPairs(samples, len(w.Peaks))
+	}
+
+	length := len(perChannel[0])
+	v := channelsJSON{
+		Version:         channelsJSONVersion,
+		Channels:        channels,
+		SampleRate:      w.info.sampleRate,
+		SamplesPerPixel: len(w.samples) / channels / max(length, 1),
+		Bits:            16,
+		Length:          length,
+		Data:            make([]int16, 0, 2*channels*length),
+	}
+	for bar := 0; bar < length; bar++ {
+		for _, pairs := range perChannel {
+			v.Data = append(v.Data, pairs[bar][0], pairs[bar][1])
+		}
+	}
+
+	return json.Marshal(v)
+}
+
+// WriteChannelsJSON writes the audiowaveform version 2 JSON to a file
+func (w *Waveform) WriteChannelsJSON(filename string) error {
+	data, err := w.GenerateChannelsJSON()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0644)
+}
@@ -0,0 +1,182 @@
+package waveform
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// liveReadSize is the size of the reads ReadFrom makes. Capture libraries
+// usually hand over a few milliseconds of audio per read, so this only bounds
+// how much a single read can deliver at once.
+const liveReadSize = 4096
+
+// LiveWaveform builds a waveform from interleaved 16-bit little-endian PCM as
+// it is captured, e.g. from a microphone through PortAudio, so it can be
+// redrawn while recording. Audio is written to it with Write or ReadFrom from
+// one goroutine while others take a Snapshot to render, typically 30 times a
+// second.
+//
+// Written audio shows up in the next snapshot; the only latency is the size of
+// the chunks the capture library delivers. With a window, snapshots show the
+// last window of audio, scrolling left as it grows, with the bars spread over
+// the audio captured so far until the window has filled. Without one they show
+// everything since the start, squeezing more audio into each bar as the
+// recording grows while memory stays bounded.
+type LiveWaveform struct {
+	mu       sync.Mutex
+	config   *Config
+	channels int        // Channels of the written PCM
+	info     streamInfo // The audio drawn, after channel selection
+	window   int        // Samples kept, 0 to keep every bar's sums instead
+	recent   []int16
+	acc      *streamAccumulator
+	pending  []byte
+	captured int
+}
+
+// NewLiveWaveform creates a LiveWaveform for PCM with the given sample rate and
+// channel count, showing the last window of audio, or everything when window
+// is 0. Config.Channel picks the channel drawn and ApplyReplayGain is ignored.
+// Showing everything needs a mode supported by Streaming, such as RMS or Peak,
+// as the samples themselves are not kept.
+func NewLiveWaveform(sampleRate, channels int, window time.Duration, config *Config) (*LiveWaveform, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", sampleRate)
+	}
+	if channels <= 0 {
+		return nil, fmt.Errorf("invalid channel count %d", channels)
+	}
+	if window < 0 {
+		return nil, fmt.Errorf("invalid window %s", window)
+	}
+
+	// Validate the channel choice before any audio arrives
+	info, err := liveInfo(sampleRate, channels, config.Channel)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &LiveWaveform{config: config, channels: channels, info: info}
+	if window > 0 {
+		l.window = int(int64(sampleRate)*int64(window)/int64(time.Second)) * info.channels
+		if l.window == 0 {
+			return nil, fmt.Errorf("window %s is shorter than a sample", window)
+		}
+	} else {
+		if !supportsSplitBuckets(config.Mode) {
+			return nil, fmt.Errorf("mode %q cannot show a whole live recording; use a window", config.Mode)
+		}
+		l.acc = newStreamAccumulator(config.Bars, info.channels)
+	}
+	return l, nil
+}
+
+// liveInfo returns the stream info of the audio drawn from PCM with the given
+// format once channel was selected
+func liveInfo(sampleRate, channels int, channel Channel) (streamInfo, error) {
+	_, info, err := selectChannel(nil, streamInfo{sampleRate: sampleRate, channels: channels}, channel)
+	return info, err
+}
+
+// Write adds captured PCM following on from the audio written before. Partial
+// samples and frames are held back until the rest of them is written.
+func (l *LiveWaveform) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pending = append(l.pending, p...)
+	whole := len(l.pending) / (2 * l.channels) * (2 * l.channels)
+	samples := make([]int16, whole/2)
+	for i := 0; i < whole; i += 2 {
+		samples[i/2] = int16(l.pending[i]) | int16(l.pending[i+1])<<8
+	}
+	l.pending = append(l.pending[:0], l.pending[whole:]...)
+
+	samples, _, err := selectChannel(samples, streamInfo{channels: l.channels}, l.config.Channel)
+	if err != nil {
+		return 0, err
+	}
+	l.add(samples)
+	return len(p), nil
+}
+
+// add keeps samples for the next snapshot
+func (l *LiveWaveform) add(samples []int16) {
+	l.captured += len(samples)
+	if l.acc != nil {
+		l.acc.add(samples)
+		return
+	}
+
+	l.recent = append(l.recent, samples...)
+	// Dropping old audio only once twice the window is held keeps it amortized
+	if len(l.recent) >= 2*l.window {
+		l.recent = append(l.recent[:0], l.recent[len(l.recent)-l.window:]...)
+	}
+}
+
+// ReadFrom writes the PCM read from r until EOF, returning the number of bytes
+// read. Each read is written as soon as it arrives, so snapshots keep up with
+// a live capture stream.
+func (l *LiveWaveform) ReadFrom(r io.Reader) (int64, error) {
+	buf := make([]byte, liveReadSize)
+	var total int64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			total += int64(n)
+			if _, werr := l.Write(buf[:n]); werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// Captured returns the duration of the audio written so far
+func (l *LiveWaveform) Captured() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.info.duration(l.captured)
+}
+
+// Snapshot returns a waveform of the audio written so far, or of its last
+// window. It shares nothing with the LiveWaveform, so it can be rendered while
+// capture goes on. Before any audio arrives its bars are all silent.
+func (l *LiveWaveform) Snapshot() *Waveform {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.acc != nil {
+		peaks := l.acc.peaks(l.config.Bars, l.config.Mode)
+		if peaks == nil {
+			peaks = make([]float64, l.config.Bars)
+		}
+		return &Waveform{
+			Peaks:    peaks,
+			Config:   l.config,
+			duration: l.info.duration(l.acc.total),
+			frames:   l.acc.total / max(l.info.channels, 1),
+			info:     l.info,
+		}
+	}
+
+	samples := l.recent[max(len(l.recent)-l.window, 0):]
+	// Nothing cancels a snapshot, so this can't fail
+	w, _ := newFromDecoded(context.Background(), append([]int16(nil), samples...), l.info, l.config, nil)
+	if len(w.Peaks) == 0 {
+		w.Peaks = make([]float64, l.config.Bars)
+	}
+	return w
+}
@@ -0,0 +1,173 @@
+package waveform
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// LoopPoint is a sustain loop of a sampler instrument, as stored in the smpl
+// chunk of WAV files. Start and End are the first and last frame played in the
+// loop, counted from the start of the waveform's audio at its SampleRate.
+type LoopPoint struct {
+	Start int
+	End   int
+}
+
+// loopPointer is implemented by decoders that read loop points from the file
+type loopPointer interface {
+	LoopPoints() []LoopPoint
+}
+
+// LoopPoints returns the loop points of the WAV file's smpl chunk, nil if it
+// has none
+func (d *WAVDecoder) LoopPoints() []LoopPoint {
+	return d.loops
+}
+
+// LoopPoints returns the loop points read from the audio file, e.g. the smpl
+// chunk of WAV files. With StartTime or TrimSilence they are shifted along with
+// the start of the audio, so loops in cut audio end up before frame 0.
+func (w *Waveform) LoopPoints() []LoopPoint {
+	return w.info.loops
+}
+
+// smplLoopsOffset is where the loop list starts in a smpl chunk, after the
+// manufacturer, product, sample period, MIDI, SMPTE, loop count and sampler
+// data size fields
+const smplLoopsOffset = 36
+
+// readWAVLoops returns the loops of the smpl chunk of the RIFF WAVE file read
+// by r, walking the chunk headers and seeking past everything else, so the
+// audio itself is never read. The smpl chunk usually follows the data chunk.
+// Only its fixed fields and loop records are read, after checking them against
+// the length of the file, so a corrupt chunk size can't run up an allocation.
+// r is left at an arbitrary position.
+func readWAVLoops(r io.ReadSeeker) ([]LoopPoint, error) {
+	fileSize, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(12, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			// No smpl chunk before the end of the file
+			return nil, nil
+		}
+		size := int64(binary.LittleEndian.Uint32(header[4:]))
+
+		if !bytes.Equal(header[:4], []byte("smpl")) {
+			// Chunks are padded to an even size
+			if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if size < smplLoopsOffset {
+			return nil, fmt.Errorf("smpl chunk of %d bytes is too short", size)
+		}
+		pos, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if size > fileSize-pos {
+			return nil, fmt.Errorf("smpl chunk of %d bytes runs past the end of the file", size)
+		}
+
+		fields := make([]byte, smplLoopsOffset)
+		if _, err := io.ReadFull(r, fields); err != nil {
+			return nil, fmt.Errorf("reading smpl chunk: %w", err)
+		}
+		count := int64(binary.LittleEndian.Uint32(fields[28:]))
+		if held := (size - smplLoopsOffset) / 24; count > held {
+			return nil, fmt.Errorf("smpl chunk lists %d loops but holds %d", count, held)
+		}
+
+		records := make([]byte, count*24)
+		if _, err := io.ReadFull(r, records); err != nil {
+			return nil, fmt.Errorf("reading smpl chunk: %w", err)
+		}
+		loops := make([]LoopPoint, 0, count)
+		for i := 0; i < int(count); i++ {
+			loop := records[i*24:]
+			// Each loop is a cue point ID, type, start, end, fraction and play count
+			loops = append(loops, LoopPoint{
+				Start: int(binary.LittleEndian.Uint32(loop[8:])),
+				End:   int(binary.LittleEndian.Uint32(loop[12:])),
+			})
+		}
+		return loops, nil
+	}
+}
+
+// scaleLoops maps loop points from one sample rate to another
+func scaleLoops(loops []LoopPoint, from, to int) []LoopPoint {
+	scaled := make([]LoopPoint, len(loops))
+	for i, loop := range loops {
+		scaled[i] = LoopPoint{
+			Start: int(int64(loop.Start) * int64(to) / int64(from)),
+			End:   int(int64(loop.End) * int64(to) / int64(from)),
+		}
+	}
+	return scaled
+}
+
+// shiftLoops moves loop points frames earlier
+func shiftLoops(loops []LoopPoint, frames int) []LoopPoint {
+	shifted := make([]LoopPoint, len(loops))
+	for i, loop := range loops {
+		shifted[i] = LoopPoint{Start: loop.Start - frames, End: loop.End - frames}
+	}
+	return shifted
+}
+
+// loopMarkerColor is the color of the loop markers
+const loopMarkerColor = "#10B981"
+
+// addLoopMarkers shades the stretch of each loop over the bars and marks its
+// boundaries with lines. Loops reaching outside the drawn audio are cut off at
+// its ends.
+func (w *Waveform) addLoopMarkers(data []byte) []byte {
+	loops := w.LoopPoints()
+	closeStart := bytes.LastIndex(data, []byte("</svg>"))
+	if len(loops) == 0 || w.frames == 0 || closeStart < 0 {
+		return data
+	}
+
+	var buf bytes.Buffer
+	buf.Write(data[:closeStart])
+	buf.WriteString(`<g class="waveform-loops">`)
+	for _, loop := range loops {
+		// The end frame is played too, so the loop closes after it
+		start, end := max(loop.Start, 0), min(loop.End+1, w.frames)
+		if start >= end {
+			continue
+		}
+		x0, x1 := w.timelineX(start), w.timelineX(end)
+
+		fmt.Fprintf(&buf, `<rect x="%s" y="0" width="%s" height="%d" fill="%s" fill-opacity="0.15"/>`,
+			svgNumber(x0), svgNumber(x1-x0), w.Config.Height, loopMarkerColor)
+		for _, x := range []float64{x0, x1} {
+			fmt.Fprintf(&buf, `<line x1="%s" y1="0" x2="%s" y2="%d" stroke="%s" stroke-width="1"/>`,
+				svgNumber(x), svgNumber(x), w.Config.Height, loopMarkerColor)
+		}
+	}
+	buf.WriteString(`</g>`)
+	buf.Write(data[closeStart:])
+	return buf.Bytes()
+}
+
+// timelineX returns the x position of frame along the time axis
+func (w *Waveform) timelineX(frame int) float64 {
+	t := float64(frame) / float64(w.frames)
+	if w.Config.TimeAxis == TimeAxisLog {
+		t = logTime(t)
+	}
+	return t * float64(w.Config.Width)
+}
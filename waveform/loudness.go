@@ -0,0 +1,81 @@
+package waveform
+
+import (
+	"math"
+	"time"
+
+	"github.com/tdewolff/canvas"
+)
+
+const (
+	// shortTermWindow is the EBU R128 short-term loudness window
+	shortTermWindow = 3 * time.Second
+	// loudnessCurveFloor is the loudness at the bottom edge of the loudness curve;
+	// the top edge is 0 LUFS
+	loudnessCurveFloor = -60.0
+	// loudnessCurveWidth is the stroke width of the loudness curve in pixels
+	loudnessCurveWidth = 1.5
+)
+
+// ShortTermLoudness returns the short-term loudness at each bar in LUFS, measured
+// over a 3 second window centered on the bar (3 bars when the sample rate is
+// unknown). Silent windows are -Inf. Returns nil if the waveform holds no samples;
+// see Config.RetainSamples.
+func (w *Waveform) ShortTermLoudness() []float64 {
+	bars := len(w.Peaks)
+	if len(w.samples) == 0 || bars == 0 {
+		return nil
+	}
+
+	window := 3 * max(len(w.samples)/bars, 1)
+	if w.info.sampleRate > 0 && w.info.channels > 0 {
+		window = int(int64(w.info.sampleRate)*int64(shortTermWindow)/int64(time.Second)) * w.info.channels
+	}
+
+	series := make([]float64, bars)
+	for i := range series {
+		bucketStart, bucketEnd := bucketBounds(i, bars, len(w.samples))
+		center := (bucketStart + bucketEnd) / 2
+		start := max(center-window/2, 0)
+		end := min(start+window, len(w.samples))
+		if start >= end {
+			series[i] = math.Inf(-1)
+			continue
+		}
+		series[i] = blockLoudness(w.samples[start:end])
+	}
+	return series
+}
+
+// drawLoudnessCurve strokes a line through the loudness of each bar, rising from
+// loudnessCurveFloor at the bottom of the canvas to 0 LUFS at the top
+func drawLoudnessCurve(ctx *canvas.Context, series []float64, config *Config) {
+	if len(series) == 0 {
+		return
+	}
+
+	color := config.LoudnessCurveColor
+	if color == "" {
+		color = DefaultConfig().LoudnessCurveColor
+	}
+
+	height := float64(config.Height)
+
+	path := &canvas.Path{}
+	for i, lufs := range series {
+		level := math.Max(0, math.Min(1, (lufs-loudnessCurveFloor)/-loudnessCurveFloor))
+		x, span := barSpan(i, len(series), config)
+		x, y := x+span/2, level*height
+		if i == 0 {
+			path.MoveTo(x, y)
+		} else {
+			path.LineTo(x, y)
+		}
+	}
+
+	ctx.SetFillColor(canvas.Transparent)
+	ctx.SetStrokeColor(canvas.Hex(color))
+	ctx.SetStrokeWidth(loudnessCurveWidth)
+	ctx.SetStrokeJoiner(canvas.RoundJoin)
+	ctx.DrawPath(0, 0, path)
+}
@@ -0,0 +1,227 @@
+package waveform
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// lufsReferenceRate is the sample rate assumed for K-weighting audio of
+	// unknown rate, the rate ITU-R BS.1770 specifies its filters at
+	lufsReferenceRate = 48000
+	// gatingBlock is the length of the blocks integrated loudness is gated on
+	gatingBlock = 400 * time.Millisecond
+	// gatingStep is how far consecutive gating blocks start apart, overlapping them by 75%
+	gatingStep = 100 * time.Millisecond
+	// absoluteGate is the loudness in LUFS blocks must exceed to count at all
+	absoluteGate = -70.0
+	// relativeGate is how far in LU below the loudness of the blocks passing the
+	// absolute gate a block may be and still count
+	relativeGate = -10.0
+)
+
+// biquad is a second order IIR filter section in transposed direct form II
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+// process filters one sample
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// kWeighting is the two-stage K-weighting filter of ITU-R BS.1770 for one
+// channel: a high shelf modelling the acoustic effect of the head, followed by
+// the RLB high-pass
+type kWeighting struct {
+	shelf, highPass biquad
+}
+
+// newKWeighting returns a K-weighting filter for sampleRate, deriving the
+// coefficients BS.1770 lists for 48kHz so other rates get the same response
+func newKWeighting(sampleRate int) *kWeighting {
+	rate := float64(sampleRate)
+
+	// Stage 1: high shelf of about +4 dB above 1.5kHz
+	const shelfFreq, shelfGain, shelfQ = 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * shelfFreq / rate)
+	vh := math.Pow(10, shelfGain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/shelfQ + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/shelfQ + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/shelfQ + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/shelfQ + k*k) / a0,
+	}
+
+	// Stage 2: high-pass at about 38Hz
+	const highPassFreq, highPassQ = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * highPassFreq / rate)
+	a0 = 1 + k/highPassQ + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/highPassQ + k*k) / a0,
+	}
+
+	return &kWeighting{shelf: shelf, highPass: highPass}
+}
+
+// process K-weights one sample
+func (f *kWeighting) process(x float64) float64 {
+	return f.highPass.process(f.shelf.process(x))
+}
+
+// kWeighter K-weights interleaved samples of several channels, one filter per
+// channel, keeping the filter state from one call to the next
+type kWeighter struct {
+	filters []*kWeighting
+}
+
+// newKWeighter returns a kWeighter for the audio info describes, assuming
+// lufsReferenceRate and mono when they are unknown
+func newKWeighter(info streamInfo) *kWeighter {
+	rate := info.sampleRate
+	if rate <= 0 {
+		rate = lufsReferenceRate
+	}
+	filters := make([]*kWeighting, max(info.channels, 1))
+	for c := range filters {
+		filters[c] = newKWeighting(rate)
+	}
+	return &kWeighter{filters: filters}
+}
+
+// sumSquares K-weights samples[start:end], where start is the index of a
+// sample of the first channel or follows on from the previous call, and
+// returns the sum of the squared filtered samples
+func (k *kWeighter) sumSquares(samples []int16, start, end int) float64 {
+	const invMaxSample = 1.0 / 32768.0
+
+	channels := len(k.filters)
+	var sum float64
+	for i := start; i < end; i++ {
+		v := k.filters[i%channels].process(float64(samples[i]) * invMaxSample)
+		sum += v * v
+	}
+	return sum
+}
+
+// calculateLUFSTrue implements ModeLUFSTrue for a single bucket of mono audio
+// at lufsReferenceRate, starting the filter from silence. Whole waveforms go
+// through loudnessPeaks instead, which knows the format of the audio.
+func calculateLUFSTrue(samples []int16, start, end int) float64 {
+	if end <= start {
+		return 0
+	}
+	k := newKWeighter(streamInfo{})
+	return sqrt(k.sumSquares(samples, start, end) / float64(end-start))
+}
+
+// loudnessPeaks computes the ModeLUFSTrue bars of samples spread over buckets,
+// stepping p once per bucket: the K-weighted RMS level of each bucket. The
+// filters are recursive, so they run once over all samples in order, carrying
+// their state across bucket boundaries. Once ctx is done it stops, leaving the
+// remaining buckets at 0.
+func loudnessPeaks(ctx context.Context, samples []int16, info streamInfo, buckets int, p *progress) []float64 {
+	if len(samples) == 0 || buckets == 0 {
+		return nil
+	}
+
+	peaks := make([]float64, buckets)
+	k := newKWeighter(info)
+	for bucket := 0; bucket < buckets && ctx.Err() == nil; bucket++ {
+		start, end := bucketBounds(bucket, buckets, len(samples))
+		if start < end {
+			peaks[bucket] = sqrt(k.sumSquares(samples, start, end) / float64(end-start))
+		}
+		p.step(buckets)
+	}
+	return peaks
+}
+
+// IntegratedLoudness returns the integrated loudness of the audio in LUFS as
+// ITU-R BS.1770 defines it: K-weighted, summed over the channels and gated on
+// 400ms blocks, leaving out silence and quiet passages well below the rest.
+// It is measured on the audio the waveform was built from, so after channel
+// selection, the surround downmix, ReplayGain and TrimSilence. Audio shorter
+// than a block, or with every block gated out, is -Inf. Waveforms from raw
+// samples are taken to be mono at 48kHz. It returns an error if the waveform
+// holds no samples, e.g. with Config.Streaming or without Config.RetainSamples.
+func (w *Waveform) IntegratedLoudness() (float64, error) {
+	if w.samples == nil {
+		return 0, fmt.Errorf("no samples retained to measure loudness; set Config.RetainSamples")
+	}
+
+	info := w.info
+	if info.sampleRate <= 0 {
+		info.sampleRate = lufsReferenceRate
+	}
+	channels := max(info.channels, 1)
+
+	// The sum of the mean squares of the channels in every gating block, built
+	// from those of the 100ms steps each block spans
+	stepSamples := int(int64(info.sampleRate)*int64(gatingStep)/int64(time.Second)) * channels
+	stepsPerBlock := int(gatingBlock / gatingStep)
+	if stepSamples == 0 || len(w.samples) < stepsPerBlock*stepSamples {
+		return math.Inf(-1), nil
+	}
+
+	k := newKWeighter(info)
+	steps := make([]float64, len(w.samples)/stepSamples)
+	for i := range steps {
+		steps[i] = k.sumSquares(w.samples, i*stepSamples, (i+1)*stepSamples)
+	}
+
+	blockFrames := float64(stepsPerBlock * stepSamples / channels)
+	blocks := make([]float64, 0, len(steps)-stepsPerBlock+1)
+	for i := 0; i+stepsPerBlock <= len(steps); i++ {
+		var sum float64
+		for _, s := range steps[i : i+stepsPerBlock] {
+			sum += s
+		}
+		blocks = append(blocks, sum/blockFrames)
+	}
+
+	gated := gatedMean(blocks, absoluteGate)
+	if gated == 0 {
+		return math.Inf(-1), nil
+	}
+	return loudnessOf(gatedMean(blocks, loudnessOf(gated)+relativeGate)), nil
+}
+
+// gatedMean returns the mean of the block powers louder than gate LUFS, 0 if
+// there are none
+func gatedMean(blocks []float64, gate float64) float64 {
+	var sum float64
+	var n int
+	for _, z := range blocks {
+		if loudnessOf(z) > gate {
+			sum += z
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// loudnessOf returns the loudness in LUFS of the channel-summed mean square of
+// K-weighted audio. Digital silence is -Inf.
+func loudnessOf(power float64) float64 {
+	if power <= 0 {
+		return math.Inf(-1)
+	}
+	return -0.691 + 10*math.Log10(power)
+}
@@ -0,0 +1,55 @@
+package waveform
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"os"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/canvas/renderers/rasterizer"
+)
+
+// GeneratePNG rasterizes the waveform to a PNG of Width*Scale by Height*Scale pixels.
+// The bars are drawn exactly as for SVG; effects that only exist as SVG markup
+// (BaseShadow, ClipOverlay, LoopMarkers, Tooltips, ShowAmplitudeAxis and the root element options) are not applied.
+func (w *Waveform) GeneratePNG() ([]byte, error) {
+	return renderPNG(w.Config, w.draw)
+}
+
+// WritePNG rasterizes the waveform and writes it as a PNG file
+func (w *Waveform) WritePNG(filename string) error {
+	data, err := w.GeneratePNG()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0644)
+}
+
+// renderPNG rasterizes what draw puts on the canvas. The canvas is laid out in pixel units, so a
+// resolution of Scale dots per unit yields the scaled image size.
+func renderPNG(config *Config, draw func(*canvas.Context) error) ([]byte, error) {
+	scale := config.Scale
+	if scale <= 0 {
+		scale = 1
+	}
+	if float64(config.Width)*scale < 1 || float64(config.Height)*scale < 1 {
+		return nil, fmt.Errorf("image size %dx%d at scale %g has no pixels", config.Width, config.Height, scale)
+	}
+
+	ras := rasterizer.New(float64(config.Width), float64(config.Height), canvas.DPMM(scale), canvas.DefaultColorSpace)
+	ctx := canvas.NewContext(ras)
+
+	drawBackground(ctx, config)
+	if err := draw(ctx); err != nil {
+		return nil, err
+	}
+	ras.Close()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, ras); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
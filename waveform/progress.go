@@ -0,0 +1,137 @@
+package waveform
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// progressDecodeShare is the part of the progress taken up by decoding audio
+	// files, which usually takes far longer than computing the bars
+	progressDecodeShare = 0.9
+	// progressStep is the smallest advance passed on to the callback, so it is
+	// called about a hundred times however fine-grained the work is
+	progressStep = 0.01
+)
+
+// progress passes how far a waveform is along on to Config.ProgressFunc. The
+// work runs in phases that each take up part of the range 0..1. A nil
+// *progress ignores every call, so code paths without a callback pay nothing.
+type progress struct {
+	mu       sync.Mutex
+	fn       func(float64)
+	from, to float64
+	steps    int
+	last     float64
+	// file and size locate the position of a decoder in its audio file
+	file *os.File
+	size int64
+}
+
+// newProgress returns a progress reporting to fn, nil if fn is nil
+func newProgress(fn func(float64)) *progress {
+	if fn == nil {
+		return nil
+	}
+	return &progress{fn: fn}
+}
+
+// phase starts the next part of the work, running from where the previous
+// phase ended up to to
+func (p *progress) phase(to float64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.from, p.to, p.steps = p.to, to, 0
+	p.mu.Unlock()
+}
+
+// set reports that fraction of the current phase is done
+func (p *progress) set(fraction float64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.report(p.from + (p.to-p.from)*fraction)
+	p.mu.Unlock()
+}
+
+// step reports that one more of total equal parts of the current phase is
+// done. It may be called from several goroutines at once.
+func (p *progress) step(total int) {
+	if p == nil || total <= 0 {
+		return
+	}
+	p.mu.Lock()
+	p.steps++
+	p.report(p.from + (p.to-p.from)*float64(p.steps)/float64(total))
+	p.mu.Unlock()
+}
+
+// finish reports the whole job done
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.report(1)
+	p.mu.Unlock()
+}
+
+// report passes done on to the callback if it moved on far enough, or is the
+// end. Progress never goes backwards. The caller holds p.mu, which also keeps
+// callbacks from running concurrently.
+func (p *progress) report(done float64) {
+	done = min(done, 1)
+	if done < p.last+progressStep && (done < 1 || p.last == 1) {
+		return
+	}
+	p.last = done
+	p.fn(done)
+}
+
+// track follows decoder's position in its audio file for decoded
+func (p *progress) track(decoder AudioDecoder) {
+	if p == nil {
+		return
+	}
+	file := decoderFile(decoder)
+	if file == nil {
+		return
+	}
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		p.file, p.size = file, info.Size()
+	}
+}
+
+// decoded reports the current phase as far along as the tracked decoder is
+// through its file
+func (p *progress) decoded() {
+	if p == nil || p.file == nil {
+		return
+	}
+	if pos, err := p.file.Seek(0, io.SeekCurrent); err == nil {
+		p.set(float64(pos) / float64(p.size))
+	}
+}
+
+// decoderFile returns the file decoder reads, nil if it isn't one of ours
+func decoderFile(decoder AudioDecoder) *os.File {
+	switch d := decoder.(type) {
+	case *MP3Decoder:
+		return d.file
+	case *WAVDecoder:
+		return d.file
+	case *FLACDecoder:
+		return d.file
+	case *OGGDecoder:
+		return d.file
+	case *AIFFDecoder:
+		return d.file
+	case *OpusDecoder:
+		return d.file
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package waveform
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// replayGainer is implemented by decoders that read ReplayGain tags from their
+// file. ReplayGain returns the gain in dB, or 0 when the file isn't tagged.
+type replayGainer interface {
+	ReplayGain() float64
+}
+
+// replayGainFromTags picks the gain out of name/value tag pairs, preferring the
+// track gain and falling back to the album gain
+func replayGainFromTags(tags [][2]string) float64 {
+	var album float64
+	for _, tag := range tags {
+		gain, err := parseGain(tag[1])
+		if err != nil {
+			continue
+		}
+
+		switch strings.ToUpper(tag[0]) {
+		case "REPLAYGAIN_TRACK_GAIN":
+			return gain
+		case "REPLAYGAIN_ALBUM_GAIN":
+			album = gain
+		}
+	}
+	return album
+}
+
+// parseGain parses a ReplayGain value such as "-6.02 dB"
+func parseGain(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && strings.EqualFold(value[len(value)-2:], "dB") {
+		value = strings.TrimSpace(value[:len(value)-2])
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// vorbisCommentTags splits "NAME=value" Vorbis comments into name/value pairs
+func vorbisCommentTags(comments []string) [][2]string {
+	tags := make([][2]string, 0, len(comments))
+	for _, comment := range comments {
+		if name, value, ok := strings.Cut(comment, "="); ok {
+			tags = append(tags, [2]string{name, value})
+		}
+	}
+	return tags
+}
+
+// id3Tags reads the user-defined text frames (TXXX) of an ID3v2.3 or ID3v2.4 tag
+// at the start of r as description/value pairs. Files without a tag yield nil.
+func id3Tags(r io.Reader) [][2]string {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:3]) != "ID3" {
+		return nil
+	}
+
+	version, flags := header[3], header[5]
+	if version != 3 && version != 4 || flags&0x80 != 0 {
+		// ID3v2.2 and unsynchronised tags aren't worth supporting for a gain value
+		return nil
+	}
+
+	body := make([]byte, syncsafe(header[6:10]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil
+	}
+
+	if flags&0x40 != 0 && len(body) >= 4 {
+		// Skip the extended header; in ID3v2.3 its size excludes the size field itself
+		size := syncsafe(body[:4])
+		if version == 3 {
+			size = int(binary.BigEndian.Uint32(body[:4])) + 4
+		}
+		if size > len(body) {
+			return nil
+		}
+		body = body[size:]
+	}
+
+	var tags [][2]string
+	for len(body) >= 10 && body[0] != 0 {
+		id := string(body[:4])
+		size := int(binary.BigEndian.Uint32(body[4:8]))
+		if version == 4 {
+			size = syncsafe(body[4:8])
+		}
+		if size > len(body)-10 {
+			break
+		}
+
+		data := body[10 : 10+size]
+		body = body[10+size:]
+
+		if id != "TXXX" || len(data) < 2 {
+			continue
+		}
+		text := strings.TrimRight(id3Text(data[0], data[1:]), "\x00")
+		if desc, value, ok := strings.Cut(text, "\x00"); ok {
+			tags = append(tags, [2]string{desc, value})
+		}
+	}
+	return tags
+}
+
+// syncsafe decodes a 4-byte ID3 syncsafe integer (7 bits per byte)
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+// id3Text decodes ID3 text in the given encoding: 0 is ISO-8859-1, 1 is UTF-16
+// with a byte order mark, 2 is UTF-16BE and 3 is UTF-8
+func id3Text(encoding byte, data []byte) string {
+	switch encoding {
+	case 0:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	case 1, 2:
+		order := binary.ByteOrder(binary.BigEndian)
+		if encoding == 1 && len(data) >= 2 {
+			if data[0] == 0xFF && data[1] == 0xFE {
+				order = binary.LittleEndian
+			}
+			if data[0] == 0xFF && data[1] == 0xFE || data[0] == 0xFE && data[1] == 0xFF {
+				data = data[2:]
+			}
+		}
+		units := make([]uint16, len(data)/2)
+		for i := range units {
+			units[i] = order.Uint16(data[i*2:])
+		}
+		// Each string in a UTF-16 frame carries its own byte order mark; drop the inner ones
+		text := string(utf16.Decode(units))
+		return strings.ReplaceAll(text, "\x00\uFEFF", "\x00")
+	default:
+		return string(data)
+	}
+}
+
+// applyGain scales samples in place by gain dB, clipping at full scale
+func applyGain(samples []int16, gain float64) {
+	factor := math.Pow(10, gain/20)
+	for i, s := range samples {
+		v := math.Round(float64(s) * factor)
+		samples[i] = int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, v)))
+	}
+}
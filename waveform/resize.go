@@ -0,0 +1,98 @@
+package waveform
+
+import "math"
+
+// Interpolation selects how peaks are resampled when changing the bar count
+type Interpolation string
+
+const (
+	// InterpolationLinear blends neighbouring peaks. Smooth when upscaling, but it
+	// samples between bars when downscaling and can miss short transients.
+	InterpolationLinear Interpolation = "linear"
+	// InterpolationNearest picks the closest peak. Keeps the blocky look of the
+	// source when upscaling; as lossy as linear when downscaling.
+	InterpolationNearest Interpolation = "nearest"
+	// InterpolationMax takes the maximum of all peaks covered by each new bar.
+	// Best for reducing the bar count since the envelope's peaks are preserved.
+	InterpolationMax Interpolation = "max"
+)
+
+// Resize resamples the waveform's peaks to the given number of bars and sets
+// Config.Bars to match, so exports and renders agree with the peaks. The config
+// is copied first, as other waveforms may share it.
+func (w *Waveform) Resize(bars int, interp Interpolation) {
+	w.Peaks = resamplePeaks(w.Peaks, bars, interp)
+	for c, peaks := range w.channels {
+		w.channels[c] = resamplePeaks(peaks, bars, interp)
+	}
+
+	if w.Config != nil {
+		config := *w.Config
+		config.Bars = len(w.Peaks)
+		w.Config = &config
+	}
+}
+
+// capBars returns peaks max-pooled down to config.MaxElements bars if there are
+// more, so loud moments survive the reduction; otherwise peaks as they are
+func capBars(peaks []float64, config *Config) []float64 {
+	if config.MaxElements <= 0 || len(peaks) <= config.MaxElements {
+		return peaks
+	}
+	return resamplePeaks(peaks, config.MaxElements, InterpolationMax)
+}
+
+// resamplePeaks returns peaks resampled to n values using the given interpolation
+func resamplePeaks(peaks []float64, n int, interp Interpolation) []float64 {
+	if n <= 0 || len(peaks) == 0 {
+		return nil
+	}
+
+	out := make([]float64, n)
+	if len(peaks) == 1 || (n == 1 && interp != InterpolationMax) {
+		for i := range out {
+			out[i] = peaks[0]
+		}
+		return out
+	}
+
+	switch interp {
+	case InterpolationMax:
+		for i := range out {
+			start := i * len(peaks) / n
+			end := (i + 1) * len(peaks) / n
+			if end <= start {
+				end = start + 1 // Upscaling: every bar covers at least one source peak
+			}
+
+			maxPeak := peaks[start]
+			for _, peak := range peaks[start+1 : end] {
+				if peak > maxPeak {
+					maxPeak = peak
+				}
+			}
+			out[i] = maxPeak
+		}
+
+	case InterpolationNearest:
+		step := float64(len(peaks)-1) / float64(n-1)
+		for i := range out {
+			out[i] = peaks[int(math.Round(float64(i)*step))]
+		}
+
+	default:
+		step := float64(len(peaks)-1) / float64(n-1)
+		for i := range out {
+			pos := float64(i) * step
+			idx := int(pos)
+			if idx >= len(peaks)-1 {
+				out[i] = peaks[len(peaks)-1]
+				continue
+			}
+			frac := pos - float64(idx)
+			out[i] = peaks[idx] + (peaks[idx+1]-peaks[idx])*frac
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,12 @@
+//go:build !precisemath
+
+package waveform
+
+// preciseMath reports whether the package was built with the precisemath tag
+const preciseMath = false
+
+// sqrt is the square root behind the calculation modes. By default it is the
+// fast approximation; build with -tags precisemath to use math.Sqrt instead.
+func sqrt(x float64) float64 {
+	return fastSqrt(x)
+}
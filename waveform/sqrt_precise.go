@@ -0,0 +1,17 @@
+//go:build precisemath
+
+package waveform
+
+import "math"
+
+// preciseMath reports whether the package was built with the precisemath tag
+const preciseMath = true
+
+// sqrt is the square root behind the calculation modes, here the exact
+// math.Sqrt selected by the precisemath build tag
+func sqrt(x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	return math.Sqrt(x)
+}
@@ -0,0 +1,68 @@
+package waveform
+
+import (
+	"context"
+
+	"github.com/tdewolff/canvas"
+)
+
+// splitChannels deinterleaves samples into one slice per channel
+func splitChannels(samples []int16, channels int) [][]int16 {
+	out := make([][]int16, channels)
+	for c := range out {
+		out[c] = make([]int16, 0, len(samples)/channels)
+	}
+	for i, s := range samples {
+		out[i%channels] = append(out[i%channels], s)
+	}
+	return out
+}
+
+// channelPeaks downsamples each channel of interleaved samples in the format
+// of info on its own
+func channelPeaks(samples []int16, info streamInfo, config *Config) [][]float64 {
+	peaks := make([][]float64, info.channels)
+	channelInfo := info
+	channelInfo.channels = 1
+	for c, channel := range splitChannels(samples, info.channels) {
+		peaks[c] = computePeaks(context.Background(), channel, channelInfo, config.Bars, config.Mode, config.CustomMode, config.Concurrent, nil)
+	}
+	return peaks
+}
+
+// ChannelPeaks returns the peaks of the left and right channel of StereoSplit
+// waveforms, as drawn in the top and bottom half. It returns nil for other
+// waveforms, including StereoSplit ones of mono audio, which draw Peaks as usual.
+func (w *Waveform) ChannelPeaks() [][]float64 {
+	return w.channels
+}
+
+// drawStereo draws the left channel as a waveform in the top half of the canvas
+// and the right channel in the bottom half. Both share the loudest bar of either
+// channel as full scale unless PerChannelNormalize is set or the scale is fixed.
+func drawStereo(ctx *canvas.Context, peaks [][]float64, config *Config) error {
+	maxPeak := peakMax(peaks[0])
+	if right := peakMax(peaks[1]); right > maxPeak {
+		maxPeak = right
+	}
+	maxPeak = fullScale(maxPeak, config)
+
+	half := *config
+	half.Height = config.Height / 2
+
+	for c, offset := range []int{config.Height - half.Height, 0} {
+		scale := maxPeak
+		if config.PerChannelNormalize {
+			scale = fullScale(peakMax(peaks[c]), config)
+		}
+
+		ctx.Push()
+		ctx.Translate(0, float64(offset))
+		err := drawWaveform(ctx, peaks[c], scale, &half)
+		ctx.Pop()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package waveform
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// streamBlocksPerBar bounds the memory of a streaming decode: once there are
+// this many blocks of sums per bar, neighbouring blocks are merged in pairs.
+// It also bounds the error, as a block straddling two bars counts towards one.
+const streamBlocksPerBar = 256
+
+// streamAccumulator sums samples into equally sized blocks as they arrive, so
+// the loudness of each bar can be computed once the stream length is known
+// without keeping the samples themselves. Interleaved channels are mixed down
+// to mono first, so the blocks hold frames.
+type streamAccumulator struct {
+	channels   int
+	blockSize  int
+	maxBlocks  int
+	blocks     []bucketSums
+	current    bucketSums
+	prevSample float64
+	total      int
+	frames     int
+}
+
+func newStreamAccumulator(bars, channels int) *streamAccumulator {
+	maxBlocks := max(bars, 1) * streamBlocksPerBar
+	return &streamAccumulator{
+		channels:  max(channels, 1),
+		blockSize: 1,
+		maxBlocks: maxBlocks,
+		blocks:    make([]bucketSums, 0, maxBlocks),
+	}
+}
+
+// add accumulates whole frames of interleaved samples following on from those
+// added before
+func (a *streamAccumulator) add(samples []int16) {
+	const invMaxSample = 1.0 / 32768.0
+
+	a.total += len(samples)
+	samples = downmixSurround(samples, a.channels)
+	for _, s := range samples {
+		sample := float64(s) * invMaxSample
+		a.current.add(sample, a.prevSample)
+		a.prevSample = sample
+
+		if a.current.count == a.blockSize {
+			a.blocks = append(a.blocks, a.current)
+			a.current = bucketSums{}
+			if len(a.blocks) == a.maxBlocks {
+				a.compact()
+			}
+		}
+	}
+	a.frames += len(samples)
+}
+
+// compact merges neighbouring blocks, halving their number and doubling their size
+func (a *streamAccumulator) compact() {
+	for i := 0; i < len(a.blocks)/2; i++ {
+		a.blocks[i] = a.blocks[2*i].merge(a.blocks[2*i+1])
+	}
+	a.blocks = a.blocks[:len(a.blocks)/2]
+	a.blockSize *= 2
+}
+
+// peaks splits the samples added so far into buckets the way downsample does
+// and returns the loudness of each. Every block counts towards the bucket
+// holding its middle sample.
+func (a *streamAccumulator) peaks(buckets int, mode CalculationMode) []float64 {
+	if a.frames == 0 || buckets == 0 {
+		return nil
+	}
+
+	blocks := a.blocks
+	if a.current.count > 0 {
+		blocks = append(blocks, a.current)
+	}
+
+	sums := make([]bucketSums, buckets)
+	for i, block := range blocks {
+		bucket := bucketOf(i*a.blockSize+block.count/2, buckets, a.frames)
+		sums[bucket] = sums[bucket].merge(block)
+	}
+
+	peaks := make([]float64, buckets)
+	for i, bucket := range sums {
+		peaks[i] = bucket.loudness(mode)
+	}
+	return peaks
+}
+
+// canStream reports whether config can be rendered by streamPeaks. Modes that
+// are not built from mergeable sums, and options that need every sample at
+// once, fall back to decoding the whole file.
+func canStream(config *Config) bool {
+	return supportsSplitBuckets(config.Mode) && !config.TrimSilence && !config.StereoSplit &&
+		config.TargetSampleRate <= 0 && config.BarsPerSecond <= 0
+}
+
+// streamPeaks decodes filename between start and end chunk by chunk, like
+// readAllSamples, feeding each chunk straight into the bar accumulators, and
+// returns the peaks along with the stream info and the number of samples seen.
+// With scan > 0 only about scan frames, evenly spread over the audio, are fed
+// in, and the number of samples seen counts just those. Progress through the
+// file is reported to p. Once ctx is done it gives up, returning ctx.Err().
+func streamPeaks(ctx context.Context, filename string, start, end time.Duration, config *Config, scan int, p *progress) ([]float64, streamInfo, int, error) {
+	decoder, err := NewAudioDecoder(filename)
+	if err != nil {
+		return nil, streamInfo{}, 0, err
+	}
+	defer decoder.Close()
+
+	mono := wantsMono(config)
+	if mono {
+		requestMono(decoder)
+	}
+
+	info := streamInfo{
+		sampleRate: decoder.SampleRate(),
+		channels:   decoder.NumChannels(),
+	}
+	if g, ok := decoder.(replayGainer); ok {
+		info.replayGain = g.ReplayGain()
+	}
+	if l, ok := decoder.(loopPointer); ok {
+		info.loops = l.LoopPoints()
+	}
+	channels := max(info.channels, 1)
+
+	// Validate the channel choice before decoding anything
+	if _, _, err := selectChannel(nil, info, config.Channel); err != nil {
+		return nil, streamInfo{}, 0, err
+	}
+
+	skip, err := skipToStart(decoder, info, start)
+	if err != nil {
+		return nil, streamInfo{}, 0, err
+	}
+	if start > 0 && len(info.loops) > 0 {
+		info.loops = shiftLoops(info.loops, samplesIn(start, info)/channels)
+	}
+
+	limit := 0
+	if end > 0 && info.sampleRate > 0 {
+		limit = samplesIn(end-start, info)
+	}
+
+	// The stride is fixed up front from the expected length, so the frames
+	// looked at lie on an even grid
+	stride, offset := 1, 0
+	if scan > 0 {
+		expected := max(estimateSamples(decoder, filename)-skip, 0)
+		if limit > 0 {
+			expected = min(expected, limit)
+		}
+		stride = max(expected/channels/scan, 1)
+	}
+
+	// Surround streams are folded down to mono like readAllSamples does, as is
+	// everything when a mono mix was asked for that the decoder couldn't provide
+	downmix := info.channels > 2 || (mono && info.channels > 1)
+	frameInfo := info
+	if downmix {
+		frameInfo.channels = 1
+	}
+
+	p.track(decoder)
+	outInfo := frameInfo
+	if _, selected, err := selectChannel(nil, frameInfo, config.Channel); err == nil {
+		outInfo = selected
+	}
+	acc := newStreamAccumulator(config.Bars, outInfo.channels)
+
+	const bufferSize = 32768
+	buf := make([]byte, bufferSize)
+	var pending []int16
+	read := 0
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, streamInfo{}, 0, err
+		}
+
+		n, err := decoder.Read(buf)
+		if err != nil && err != io.EOF {
+			return nil, streamInfo{}, 0, err
+		}
+		if n == 0 {
+			break
+		}
+
+		for i := 0; i < n-1; i += 2 {
+			pending = append(pending, int16(buf[i])|int16(buf[i+1])<<8)
+		}
+		if skip > 0 {
+			discard := min(skip, len(pending))
+			pending = append(pending[:0], pending[discard:]...)
+			skip -= discard
+		}
+		if limit > 0 && read+len(pending) > limit {
+			pending = pending[:limit-read]
+		}
+
+		// Only whole frames are processed; the remainder waits for the next read
+		whole := len(pending) / channels * channels
+		chunk := pending[:whole]
+		read += whole
+
+		if downmix {
+			chunk = downmixSurround(chunk, info.channels)
+		}
+
+		var selectErr error
+		chunk, outInfo, selectErr = selectChannel(chunk, frameInfo, config.Channel)
+		if selectErr != nil {
+			return nil, streamInfo{}, 0, selectErr
+		}
+		if config.ApplyReplayGain && info.replayGain != 0 {
+			applyGain(chunk, info.replayGain)
+		}
+		if stride > 1 {
+			chunk, offset = decimateFrames(chunk, max(outInfo.channels, 1), stride, offset)
+		}
+		acc.add(chunk)
+		p.decoded()
+
+		pending = append(pending[:0], pending[whole:]...)
+
+		if (limit > 0 && read >= limit) || err == io.EOF {
+			break
+		}
+	}
+
+	if start > 0 && acc.total == 0 {
+		return nil, streamInfo{}, 0, fmt.Errorf("start time %s is past the end of the audio", start)
+	}
+
+	return acc.peaks(config.Bars, config.Mode), outInfo, acc.total, nil
+}
+
+// decimateFrames keeps every stride-th frame of interleaved samples, whose
+// first frame lies offset frames into the stride, and returns the offset of the
+// frame following them. The frames are moved down in place.
+func decimateFrames(samples []int16, channels, stride, offset int) ([]int16, int) {
+	frames := len(samples) / channels
+	kept := samples[:0]
+	for f := (stride - offset) % stride; f < frames; f += stride {
+		kept = append(kept, samples[f*channels:(f+1)*channels]...)
+	}
+	return kept, (offset + frames) % stride
+}
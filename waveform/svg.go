@@ -0,0 +1,367 @@
+package waveform
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tdewolff/canvas"
+	"github.com/tdewolff/canvas/renderers/svg"
+)
+
+// renderSVG renders peaks into a complete SVG document, normalized to their own
+// maximum unless config fixes the scale
+func renderSVG(peaks []float64, config *Config) ([]byte, error) {
+	return renderScaledSVG(peaks, fullScale(peakMax(peaks), config), config)
+}
+
+// renderScaledSVG renders peaks into a complete SVG document, normalized so that
+// maxPeak reaches the full bar height
+func renderScaledSVG(peaks []float64, maxPeak float64, config *Config) ([]byte, error) {
+	return renderDrawing(config, func(ctx *canvas.Context) error {
+		layout := orient(ctx, config)
+		return drawWaveform(ctx, capBars(peaks, layout), maxPeak, layout)
+	})
+}
+
+// renderDrawing renders what draw puts on the canvas into a complete SVG document
+func renderDrawing(config *Config, draw func(*canvas.Context) error) ([]byte, error) {
+	// Create a temporary buffer to capture SVG output
+	var buf []byte
+	file := &bytesWriter{data: &buf}
+
+	ctx := canvas.NewContext(svg.New(file, float64(config.Width), float64(config.Height), nil))
+
+	drawBackground(ctx, config)
+	if err := draw(ctx); err != nil {
+		return nil, err
+	}
+
+	// Important: Ensure SVG ends with a newline. Do not remove!
+	buf = append(buf, []byte("</svg>\n")...)
+
+	// Square bars are plain rectangles; emit them as such
+	if config.CornerRadius == 0 {
+		buf = pathsToRects(buf)
+	}
+
+	if config.CoordinatePrecision > 0 {
+		buf = roundCoordinates(buf, config.CoordinatePrecision)
+	}
+
+	if config.BaseShadow {
+		buf = addBaseShadow(buf, config)
+	}
+
+	return applyRootAttributes(buf, config), nil
+}
+
+var (
+	svgAttrPattern   = regexp.MustCompile(`([\w:-]+)="([^"]*)"`)
+	svgNumberPattern = regexp.MustCompile(`\d*\.\d+(?:[eE][-+]?\d+)?`)
+	svgSizePattern   = regexp.MustCompile(`\s(?:width|height)="[^"]*"`)
+	svgDefsPattern   = regexp.MustCompile(`<defs>.*?</defs>`)
+	svgRectPattern   = regexp.MustCompile(`<path d="M(` + svgNum + `) ?(` + svgNum + `)H(` + svgNum + `)V(` + svgNum + `)H(` + svgNum + `)z"([^>]*)/>`)
+	// svgRectVPattern matches the rectangles of vertical waveforms, whose paths start with a vertical edge
+	svgRectVPattern = regexp.MustCompile(`<path d="M(` + svgNum + `) ?(` + svgNum + `)V(` + svgNum + `)H(` + svgNum + `)V(` + svgNum + `)z"([^>]*)/>`)
+)
+
+// svgNum matches a single number as written in minified SVG path data
+const svgNum = `-?(?:\d+\.?\d*|\.\d+)`
+
+// pathsToRects rewrites axis-aligned rectangular paths as <rect> elements,
+// which are smaller and better supported by simple SVG consumers
+func pathsToRects(data []byte) []byte {
+	return replaceRects(replaceRects(data, svgRectPattern, false), svgRectVPattern, true)
+}
+
+// replaceRects rewrites the rectangular paths pattern matches as <rect>
+// elements. The paths go round the rectangle from a corner, along a vertical
+// edge first when vertical is set and along a horizontal one otherwise.
+func replaceRects(data []byte, pattern *regexp.Regexp, vertical bool) []byte {
+	return pattern.ReplaceAllFunc(data, func(path []byte) []byte {
+		match := pattern.FindSubmatch(path)
+		// The path must return to the edge it set out from
+		back := 1
+		if vertical {
+			back = 2
+		}
+		if string(match[back]) != string(match[5]) {
+			return path
+		}
+
+		var v [4]float64
+		for i := range v {
+			f, err := strconv.ParseFloat(string(match[i+1]), 64)
+			if err != nil {
+				return path
+			}
+			v[i] = f
+		}
+		x0, y0, x1, y1 := v[0], v[1], v[2], v[3]
+		if vertical {
+			x1, y1 = v[3], v[2]
+		}
+
+		return []byte(fmt.Sprintf(`<rect x="%s" y="%s" width="%s" height="%s"%s/>`,
+			svgNumber(math.Min(x0, x1)), svgNumber(math.Min(y0, y1)),
+			svgNumber(math.Abs(x1-x0)), svgNumber(math.Abs(y1-y0)), match[6]))
+	})
+}
+
+// svgNumber formats a value with the renderer's precision of 8 significant digits
+func svgNumber(f float64) string {
+	f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'g', 8, 64), 64)
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// roundCoordinates rounds every fractional number in the SVG's attribute values
+// to at most precision decimal places, trimming trailing zeros
+func roundCoordinates(data []byte, precision int) []byte {
+	return svgAttrPattern.ReplaceAllFunc(data, func(attr []byte) []byte {
+		match := svgAttrPattern.FindSubmatch(attr)
+
+		var value []byte
+		switch string(match[1]) {
+		case "version":
+			return attr
+		case "d":
+			value = roundPathData(match[2], precision)
+		default:
+			value = svgNumberPattern.ReplaceAllFunc(match[2], func(num []byte) []byte {
+				f, err := strconv.ParseFloat(string(num), 64)
+				if err != nil {
+					return num
+				}
+				return []byte(formatDecimal(f, precision))
+			})
+		}
+
+		out := make([]byte, 0, len(attr))
+		out = append(out, match[1]...)
+		out = append(out, '=', '"')
+		out = append(out, value...)
+		return append(out, '"')
+	})
+}
+
+// roundPathData rounds the numbers of SVG path data. Path data can't be handled
+// with a plain number pattern because minified arc flags run straight into the
+// following coordinate (e.g. "A8 8 0 0056.6" holds the flags 0, 0 and x=56.6).
+func roundPathData(d []byte, precision int) []byte {
+	out := make([]byte, 0, len(d))
+	var cmd byte
+	param := 0
+
+	for i := 0; i < len(d); {
+		c := d[i]
+		switch {
+		case c == ' ' || c == ',' || c == '\t' || c == '\n':
+			i++
+		case (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') && c != 'e' && c != 'E':
+			cmd = c
+			param = 0
+			out = append(out, c)
+			i++
+		case (cmd == 'A' || cmd == 'a') && (param%7 == 3 || param%7 == 4):
+			// Arc flags are always a single 0 or 1
+			if param > 0 && out[len(out)-1] != cmd {
+				out = append(out, ' ')
+			}
+			out = append(out, c)
+			param++
+			i++
+		default:
+			j := i
+			if j < len(d) && (d[j] == '-' || d[j] == '+') {
+				j++
+			}
+			dot := false
+			for j < len(d) && (d[j] >= '0' && d[j] <= '9' || d[j] == '.' && !dot) {
+				dot = dot || d[j] == '.'
+				j++
+			}
+			if j < len(d) && (d[j] == 'e' || d[j] == 'E') {
+				j++
+				if j < len(d) && (d[j] == '-' || d[j] == '+') {
+					j++
+				}
+				for j < len(d) && d[j] >= '0' && d[j] <= '9' {
+					j++
+				}
+			}
+			if j == i {
+				// Not a number; copy it through untouched
+				out = append(out, c)
+				i++
+				continue
+			}
+
+			num := string(d[i:j])
+			if f, err := strconv.ParseFloat(num, 64); err == nil {
+				num = formatDecimal(f, precision)
+			}
+			if len(out) > 0 && out[len(out)-1] != cmd {
+				out = append(out, ' ')
+			}
+			out = append(out, num...)
+			param++
+			i = j
+		}
+	}
+
+	return out
+}
+
+// formatDecimal formats f with at most precision decimals and no trailing zeros
+func formatDecimal(f float64, precision int) string {
+	s := strconv.FormatFloat(f, 'f', precision, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(s, "0")
+		s = strings.TrimSuffix(s, ".")
+	}
+	return s
+}
+
+// applyRootAttributes sets the configured attributes on the root <svg> element,
+// after dropping its width and height for ViewBoxOnly.
+// Attributes already present are replaced in place; new ones are appended in
+// sorted order so the output is deterministic.
+func applyRootAttributes(data []byte, config *Config) []byte {
+	attrs := make(map[string]string, len(config.RootAttributes)+4)
+	if config.Title != "" || config.Description != "" {
+		attrs["role"] = "img"
+	}
+	if config.Title != "" {
+		attrs["aria-label"] = config.Title
+	}
+	if config.SVGID != "" {
+		attrs["id"] = config.SVGID
+	}
+	for name, value := range config.RootAttributes {
+		attrs[name] = value
+	}
+	if config.PreserveAspectRatio != "" {
+		attrs["preserveAspectRatio"] = config.PreserveAspectRatio
+	}
+	if len(attrs) == 0 && !config.ViewBoxOnly {
+		return data
+	}
+
+	openEnd := bytes.IndexByte(data, '>')
+	if openEnd < 0 {
+		return data
+	}
+
+	root := data[:openEnd]
+	if config.ViewBoxOnly {
+		root = svgSizePattern.ReplaceAll(root, nil)
+	}
+
+	root = svgAttrPattern.ReplaceAllFunc(root, func(attr []byte) []byte {
+		match := svgAttrPattern.FindSubmatch(attr)
+		value, ok := attrs[string(match[1])]
+		if !ok {
+			return attr
+		}
+		delete(attrs, string(match[1]))
+		return []byte(fmt.Sprintf(`%s="%s"`, match[1], html.EscapeString(value)))
+	})
+
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.Write(root)
+	for _, name := range names {
+		fmt.Fprintf(&buf, ` %s="%s"`, name, html.EscapeString(attrs[name]))
+	}
+	buf.Write(data[openEnd:])
+	return buf.Bytes()
+}
+
+// addAccessibleText inserts the Title and Description of config as <title> and
+// <desc> right after the opening <svg> tag, where screen readers look for them.
+// It runs last, once the root element is final.
+func addAccessibleText(data []byte, config *Config) []byte {
+	if config.Title == "" && config.Description == "" {
+		return data
+	}
+	openEnd := bytes.IndexByte(data, '>') + 1
+	if openEnd <= 0 {
+		return data
+	}
+
+	var buf bytes.Buffer
+	buf.Write(data[:openEnd])
+	if config.Title != "" {
+		fmt.Fprintf(&buf, `<title>%s</title>`, html.EscapeString(config.Title))
+	}
+	if config.Description != "" {
+		fmt.Fprintf(&buf, `<desc>%s</desc>`, html.EscapeString(config.Description))
+	}
+	buf.Write(data[openEnd:])
+	return buf.Bytes()
+}
+
+// addBaseShadow draws a blurred, offset copy of the bars beneath them for a
+// floating look. The copy is shaded from its alpha channel alone, so it is dark
+// whatever the bar colors; its gradient definitions are dropped to keep ids unique.
+func addBaseShadow(data []byte, config *Config) []byte {
+	openEnd := bytes.IndexByte(data, '>') + 1
+	closeStart := bytes.LastIndex(data, []byte("</svg>"))
+	if openEnd <= 0 || closeStart < openEnd {
+		return data
+	}
+	body := data[openEnd:closeStart]
+
+	var buf bytes.Buffer
+	buf.Write(data[:openEnd])
+	buf.WriteString(`<defs><filter id="waveform-shadow" x="-20%" y="-20%" width="140%" height="140%">`)
+	fmt.Fprintf(&buf, `<feGaussianBlur in="SourceAlpha" stdDeviation="%s"/>`, svgNumber(config.ShadowBlur))
+	fmt.Fprintf(&buf, `<feComponentTransfer><feFuncA type="linear" slope="%s"/></feComponentTransfer></filter></defs>`, svgNumber(shadowOpacity))
+	fmt.Fprintf(&buf, `<g class="waveform-shadow" transform="translate(%s %s)" filter="url(#waveform-shadow)">`,
+		svgNumber(config.ShadowOffset), svgNumber(config.ShadowOffset))
+	buf.Write(svgDefsPattern.ReplaceAll(body, nil))
+	buf.WriteString(`</g>`)
+	buf.Write(body)
+	buf.Write(data[closeStart:])
+	return buf.Bytes()
+}
+
+// shadowOpacity is the opacity of the base shadow under fully opaque bars
+const shadowOpacity = 0.35
+
+// animateReveal wraps the SVG content in a clip rectangle that grows from the left
+// edge to the full width over durationMs, drawing the bars in left-to-right, or
+// from the top edge to the full height for vertical waveforms
+func animateReveal(data []byte, config *Config, durationMs int) []byte {
+	openEnd := bytes.IndexByte(data, '>') + 1
+	closeStart := bytes.LastIndex(data, []byte("</svg>"))
+	if openEnd <= 0 || closeStart < openEnd {
+		return data
+	}
+
+	var buf bytes.Buffer
+	buf.Write(data[:openEnd])
+	if config.Orientation == OrientationVertical {
+		fmt.Fprintf(&buf, `<defs><clipPath id="waveform-reveal"><rect x="0" y="0" width="%d" height="0">`, config.Width)
+		fmt.Fprintf(&buf, `<animate attributeName="height" from="0" to="%d" dur="%dms" fill="freeze"/>`, config.Height, durationMs)
+	} else {
+		fmt.Fprintf(&buf, `<defs><clipPath id="waveform-reveal"><rect x="0" y="0" width="0" height="%d">`, config.Height)
+		fmt.Fprintf(&buf, `<animate attributeName="width" from="0" to="%d" dur="%dms" fill="freeze"/>`, config.Width, durationMs)
+	}
+	buf.WriteString(`</rect></clipPath></defs><g clip-path="url(#waveform-reveal)">`)
+	buf.Write(data[openEnd:closeStart])
+	buf.WriteString(`</g>`)
+	buf.Write(data[closeStart:])
+	return buf.Bytes()
+}
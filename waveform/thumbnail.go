@@ -0,0 +1,86 @@
+package waveform
+
+import "context"
+
+// thumbnailSamplesPerBar is how many samples per bar a thumbnail looks at. At
+// thumbnail sizes a bar is a pixel or two wide, so a sparse scan is enough.
+const thumbnailSamplesPerBar = 2048
+
+// GenerateThumbnail renders a tiny preview of the waveform, e.g. 80x20 with 40
+// bars for list views. Instead of running the configured mode over every sample
+// it takes the peak of a decimated scan of the retained samples and rounds the
+// output to one decimal place. The waveform has been decoded in full already;
+// GenerateThumbnailFile saves that work too.
+func (w *Waveform) GenerateThumbnail(width, height, bars int) ([]byte, error) {
+	config := thumbnailConfig(w.Config, width, height, bars)
+
+	samples := w.samples
+	if samples == nil {
+		// Without retained samples the existing peaks are the best source
+		return renderSVG(resamplePeaks(w.Peaks, bars, InterpolationMax), config)
+	}
+
+	return renderSVG(downsample(context.Background(), decimate(samples, bars*thumbnailSamplesPerBar), bars, ModePeak, nil, nil), config)
+}
+
+// GenerateThumbnailFile renders a thumbnail of an audio file like
+// GenerateThumbnail without building a waveform first. The file is decoded
+// chunk by chunk and only the peak of about thumbnailSamplesPerBar frames per
+// bar, evenly spread, is taken while decoding, so no samples are kept and the
+// configured mode never runs. Compressed formats still have to be decoded
+// throughout; pair it with Config.MaxDuration to skip the tail of very long
+// files. config supplies the look, time range and channel, nil meaning
+// DefaultConfig.
+func GenerateThumbnailFile(filename string, width, height, bars int, config *Config) ([]byte, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	start, end, err := decodeRange(config)
+	if err != nil {
+		return nil, err
+	}
+
+	thumb := thumbnailConfig(config, width, height, bars)
+	peaks, _, _, err := streamPeaks(context.Background(), filename, start, end, thumb, bars*thumbnailSamplesPerBar, nil)
+	if err != nil {
+		return nil, err
+	}
+	return renderSVG(peaks, thumb)
+}
+
+// thumbnailConfig returns a copy of config for a thumbnail of width by height
+// pixels with the given number of bars
+func thumbnailConfig(base *Config, width, height, bars int) *Config {
+	config := *base
+	config.Width = width
+	config.Height = height
+	config.Bars = bars
+	config.Mode = ModePeak
+	config.CoordinatePrecision = 1
+	config.PerBarFade = false
+
+	// Keep bars legible when they are only a few pixels wide
+	if bars > 0 && width/bars < 3 {
+		config.BarSpacing = 0
+	} else {
+		config.BarSpacing = 1
+	}
+	if config.CornerRadius > float64(height)/8 {
+		config.CornerRadius = float64(height) / 8
+	}
+	return &config
+}
+
+// decimate returns about n samples picked at an even stride across samples
+func decimate(samples []int16, n int) []int16 {
+	if n <= 0 || len(samples) <= n {
+		return samples
+	}
+
+	stride := len(samples) / n
+	out := make([]int16, 0, len(samples)/stride+1)
+	for i := 0; i < len(samples); i += stride {
+		out = append(out, samples[i])
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+package waveform
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// GenerateTiles decodes an audio file once and renders it as a sequence of SVG
+// tiles, each covering tileDuration of audio with config.Bars bars, for timelines
+// that load the waveform on demand. All tiles share one normalization so bar
+// heights are comparable across tiles. The last tile is usually shorter; it keeps
+// the same bar pitch and is narrowed to match, or shortened when vertical.
+func GenerateTiles(filename string, tileDuration time.Duration, config *Config) ([][]byte, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+
+	// The tiles are cut from the samples, so they must outlive the decode
+	decodeConfig := *config
+	decodeConfig.RetainSamples = true
+	w, err := NewFromAudioFile(filename, &decodeConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return renderTiles(w.samples, w.info, tileDuration, config)
+}
+
+// renderTiles splits samples into tiles of tileDuration and renders each one
+func renderTiles(samples []int16, info streamInfo, tileDuration time.Duration, config *Config) ([][]byte, error) {
+	if tileDuration <= 0 {
+		return nil, fmt.Errorf("tile duration must be positive, got %v", tileDuration)
+	}
+	if info.sampleRate <= 0 || info.channels <= 0 {
+		return nil, fmt.Errorf("unknown sample rate; cannot split into tiles")
+	}
+
+	tileSamples := int(int64(info.sampleRate)*int64(tileDuration)/int64(time.Second)) * info.channels
+	if tileSamples == 0 {
+		return nil, fmt.Errorf("tile duration %v is shorter than a single frame", tileDuration)
+	}
+
+	// Compute every tile's peaks first so they can share a common maximum
+	var tilePeaks [][]float64
+	for start := 0; start < len(samples); start += tileSamples {
+		end := min(start+tileSamples, len(samples))
+
+		// A partial tile gets proportionally fewer bars to keep the bar pitch
+		bars := max(config.Bars*(end-start)/tileSamples, 1)
+
+		peaks := computePeaks(context.Background(), samples[start:end], info, bars, config.Mode, config.CustomMode, config.Concurrent, nil)
+		tilePeaks = append(tilePeaks, peaks)
+	}
+
+	var maxPeak float64
+	for _, peaks := range tilePeaks {
+		maxPeak = max(maxPeak, peakMax(peaks))
+	}
+	maxPeak = fullScale(maxPeak, config)
+
+	tiles := make([][]byte, len(tilePeaks))
+	for i, peaks := range tilePeaks {
+		tileConfig := *config
+		tileConfig.Bars = len(peaks)
+		if len(peaks) < config.Bars && config.Orientation == OrientationVertical {
+			tileConfig.Height = max(config.Height*len(peaks)/config.Bars, 1)
+		} else if len(peaks) < config.Bars {
+			tileConfig.Width = max(config.Width*len(peaks)/config.Bars, 1)
+		}
+
+		tile, err := renderScaledSVG(peaks, maxPeak, &tileConfig)
+		if err != nil {
+			return nil, err
+		}
+		tiles[i] = tile
+	}
+	return tiles, nil
+}
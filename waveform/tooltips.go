@@ -0,0 +1,137 @@
+package waveform
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"regexp"
+	"time"
+)
+
+// svgShapePattern matches a self-closing path or rect element
+var svgShapePattern = regexp.MustCompile(`<(path|rect) [^>]*/>`)
+
+// addTooltips gives every bar element a <title> child with the bar's time
+// range and sample peak level, which browsers show on hover. Bars are found in
+// drawing order after the background and the base shadow's copies of them;
+// layouts that don't draw one element per bar in order are left alone.
+func (w *Waveform) addTooltips(data []byte) []byte {
+	if (w.Config.StereoSplit && len(w.channels) == 2) || w.Config.Butterfly || w.Config.DeviationView ||
+		w.Config.Style == StyleFilled || w.Config.Style == StyleLine {
+		return data
+	}
+
+	// Pick the bars out as draw does, in the layout of the orientation
+	layout := layoutConfig(w.Config)
+	peaks := capBars(w.Peaks, layout)
+	maxPeak := fullScale(peakMax(peaks), layout)
+	// Levels are only labelled in dBFS when the sample peaks are known
+	levels, err := w.samplePeaks()
+	if err == nil {
+		levels = capBars(levels, layout)
+	}
+	var titles []string
+	for i, peak := range peaks {
+		if !barDrawn(i, len(peaks), peak, maxPeak, layout) {
+			continue
+		}
+		level := math.NaN()
+		if levels != nil {
+			level = levels[i]
+		}
+		titles = append(titles, w.barTooltip(i, len(peaks), level))
+	}
+
+	start, skip := barsStart(data, w.Config)
+
+	var buf bytes.Buffer
+	buf.Write(data[:start])
+	last := start
+	for k, loc := range svgShapePattern.FindAllSubmatchIndex(data[start:], skip+len(titles)) {
+		if k < skip {
+			continue
+		}
+		elemEnd := start + loc[1]
+		name := data[start+loc[2] : start+loc[3]]
+
+		// Reopen the self-closing element to hold the title
+		buf.Write(data[last : elemEnd-len("/>")])
+		fmt.Fprintf(&buf, `><title>%s</title></%s>`, html.EscapeString(titles[k-skip]), name)
+		last = elemEnd
+	}
+	buf.Write(data[last:])
+	return buf.Bytes()
+}
+
+// barsStart returns where the elements drawn by renderDrawing start in data,
+// past the base shadow's copies of them, and how many of those elements come
+// before the bars: the background, if any
+func barsStart(data []byte, config *Config) (start, skip int) {
+	start = bytes.IndexByte(data, '>') + 1
+	if config.BaseShadow {
+		if end := bytes.Index(data, []byte("</g>")); end >= 0 {
+			start = end + len("</g>")
+		}
+	}
+	if config.BackgroundColor != "" {
+		skip = 1
+	}
+	return start, skip
+}
+
+// addBarClasses sets the BarClass of config as the class of every element
+// drawing the waveform, so pages can restyle it with CSS. The canvas renderer
+// has no notion of classes, so the rendered SVG is post-processed: the
+// elements after the background and the base shadow are the bars, or the
+// shape or line of StyleFilled and StyleLine, save the loudness curve drawn
+// over them when curve is set. It runs before the overlays are added.
+func addBarClasses(data []byte, config *Config, curve bool) []byte {
+	if config.BarClass == "" {
+		return data
+	}
+
+	start, skip := barsStart(data, config)
+	shapes := svgShapePattern.FindAllIndex(data[start:], -1)
+	end := len(shapes)
+	if curve {
+		end--
+	}
+	if skip >= end {
+		return data
+	}
+
+	class := []byte(fmt.Sprintf(` class="%s"/>`, html.EscapeString(config.BarClass)))
+	var buf bytes.Buffer
+	buf.Write(data[:start])
+	last := start
+	for _, loc := range shapes[skip:end] {
+		elemEnd := start + loc[1]
+		buf.Write(data[last : elemEnd-len("/>")])
+		buf.Write(class)
+		last = elemEnd
+	}
+	buf.Write(data[last:])
+	return buf.Bytes()
+}
+
+// barTooltip describes bar i of n: its time range when the sample rate is
+// known, its position otherwise, and the dBFS level of its sample peak unless
+// that is NaN
+func (w *Waveform) barTooltip(i, n int, samplePeak float64) string {
+	var level string
+	switch {
+	case math.IsNaN(samplePeak):
+	case samplePeak > 0:
+		level = fmt.Sprintf(": %.1f dBFS", 20*math.Log10(samplePeak))
+	default:
+		level = ": -inf dBFS"
+	}
+
+	first, last := bucketBounds(i, n, w.frames)
+	channels := max(w.info.channels, 1)
+	if start, end := w.info.duration(first*channels), w.info.duration(last*channels); end > 0 {
+		return fmt.Sprintf("%s–%s%s", start.Round(time.Millisecond*10), end.Round(time.Millisecond*10), level)
+	}
+	return fmt.Sprintf("bar %d%s", i+1, level)
+}
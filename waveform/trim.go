@@ -0,0 +1,91 @@
+package waveform
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// trimGateLUFS is the loudness below which a block counts as silence
+	trimGateLUFS = -60.0
+	// trimBlock and trimHop match the EBU R128 momentary loudness measurement
+	trimBlock = 400 * time.Millisecond
+	trimHop   = 100 * time.Millisecond
+	// trimMinSilence is the shortest silence at either end that gets trimmed
+	trimMinSilence = time.Second
+)
+
+// TrimmedStart returns how much silence TrimSilence cut from the start of the
+// audio. Adding it to a time along the waveform gives the time in the file.
+func (w *Waveform) TrimmedStart() time.Duration {
+	return w.trimmedStart
+}
+
+// TrimmedEnd returns how much silence TrimSilence cut from the end of the audio
+func (w *Waveform) TrimmedEnd() time.Duration {
+	return w.trimmedEnd
+}
+
+// trimSilence drops sustained silence from the start and end of the samples.
+// Instead of cutting at the first sample above an amplitude threshold, which
+// eats into fade-ins, it gates on the loudness of overlapping 400 ms blocks and
+// keeps everything from the first block above the gate. Leading or trailing
+// quiet stretches shorter than trimMinSilence are left alone.
+func trimSilence(samples []int16, info streamInfo) []int16 {
+	first, last := trimBounds(samples, info)
+	return samples[first:last]
+}
+
+// trimBounds returns the range of samples trimSilence keeps
+func trimBounds(samples []int16, info streamInfo) (first, last int) {
+	if info.sampleRate <= 0 || info.channels <= 0 {
+		return 0, len(samples)
+	}
+
+	frames := func(d time.Duration) int {
+		return int(int64(info.sampleRate)*int64(d)/int64(time.Second)) * info.channels
+	}
+	block, hop, minSilence := frames(trimBlock), frames(trimHop), frames(trimMinSilence)
+	if block == 0 || hop == 0 || len(samples) < block {
+		return 0, len(samples)
+	}
+
+	first, last = -1, -1
+	for start := 0; start+block <= len(samples); start += hop {
+		if blockLoudness(samples[start:start+block]) >= trimGateLUFS {
+			if first < 0 {
+				first = start
+			}
+			last = start + block
+		}
+	}
+
+	// Entirely silent; keep it rather than returning nothing to draw
+	if first < 0 {
+		return 0, len(samples)
+	}
+
+	if first < minSilence {
+		first = 0
+	}
+	if len(samples)-last < minSilence {
+		last = len(samples)
+	}
+	return first, last
+}
+
+// blockLoudness returns the loudness of a block of samples in LUFS, without
+// K-weighting. Digital silence is -Inf.
+func blockLoudness(samples []int16) float64 {
+	var sum float64
+	for _, s := range samples {
+		v := float64(s) / 32768.0
+		sum += v * v
+	}
+
+	meanSquare := sum / float64(len(samples))
+	if meanSquare == 0 {
+		return math.Inf(-1)
+	}
+	return -0.691 + 10*math.Log10(meanSquare)
+}
@@ -3,13 +3,17 @@
 package waveform
 
 import (
+	"context"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
 	"os"
-	"runtime"
 	"sync"
+	"time"
 
 	"github.com/hajimehoshi/go-mp3"
 	"github.com/tdewolff/canvas"
-	"github.com/tdewolff/canvas/renderers/svg"
 )
 
 // CalculationMode represents the different waveform calculation algorithms available
@@ -28,6 +32,82 @@ const (
 	ModeDynamic CalculationMode = "dynamic"
 	// ModeSmooth uses heavy filtering for clean, minimal aesthetics
 	ModeSmooth CalculationMode = "smooth"
+	// ModeMAD uses the mean absolute value, a cheaper envelope that reads softer than RMS
+	ModeMAD CalculationMode = "mad"
+	// ModeOnset shows the strength of transients (rises in short-term energy) rather than loudness
+	ModeOnset CalculationMode = "onset"
+	// ModeLUFSTrue shows the K-weighted RMS level of each bar as ITU-R BS.1770 measures loudness,
+	// rather than the stylized weighting of ModeLUFS; see IntegratedLoudness for the loudness in LUFS
+	ModeLUFSTrue CalculationMode = "lufs-true"
+	// ModeCustom measures each bar with Config.CustomMode
+	ModeCustom CalculationMode = "custom"
+)
+
+// Channel selects which channels of the decoded audio are visualized
+type Channel string
+
+const (
+	// ChannelMix draws the average of every channel
+	ChannelMix Channel = "mix"
+	// ChannelLeft uses only the left (first) channel
+	ChannelLeft Channel = "left"
+	// ChannelRight uses only the right (second) channel
+	ChannelRight Channel = "right"
+)
+
+// TimeAxis selects how bars are laid out along the time axis
+type TimeAxis string
+
+const (
+	// TimeAxisLinear gives every bar the same width
+	TimeAxisLinear TimeAxis = "linear"
+	// TimeAxisLog spaces bars logarithmically, widening the start of the audio and compressing the end
+	TimeAxisLog TimeAxis = "log"
+)
+
+// NormalizeMode selects what amplitude fills the full bar height
+type NormalizeMode string
+
+const (
+	// NormalizePerFile scales every waveform so its loudest bar fills the height
+	NormalizePerFile NormalizeMode = "perfile"
+	// NormalizeFixed scales against a fixed amplitude on the scale of the calculation mode (see
+	// Config.MaxAmplitude), so quiet audio draws short bars
+	NormalizeFixed NormalizeMode = "fixed"
+)
+
+// AmplitudeScale selects how peaks map onto bar heights
+type AmplitudeScale string
+
+const (
+	// ScaleLinear makes bar heights proportional to the peaks
+	ScaleLinear AmplitudeScale = "linear"
+	// ScaleLog makes bar heights proportional to the peaks' level in dB above DBFloor
+	ScaleLog AmplitudeScale = "log"
+)
+
+// Style selects how the peaks are drawn
+type Style string
+
+const (
+	// StyleBars draws a separate bar for each peak
+	StyleBars Style = "bars"
+	// StyleFilled fills a single shape whose outline traces the peaks, mirrored about the midline
+	StyleFilled Style = "filled"
+	// StyleLine strokes a single line tracing the tops of the peaks
+	StyleLine Style = "line"
+)
+
+// Alignment selects where bars are anchored vertically
+type Alignment string
+
+const (
+	// AlignCenter grows each bar symmetrically above and below the midline
+	AlignCenter Alignment = "center"
+	// AlignBottom stands each bar on the bottom edge, like a level meter
+	AlignBottom Alignment = "bottom"
+	// AlignTop hangs each bar from the top edge
+	AlignTop Alignment = "top"
 )
 
 // Config holds the configuration options for waveform generation
@@ -38,62 +118,531 @@ type Config struct {
 	Height int
 	// Bars is the number of bars in the waveform (default: 100)
 	Bars int
+	// BarsPerSecond derives the number of bars from the duration of audio files when > 0, overriding Bars,
+	// so recordings of any length show the same density. The Width stays fixed unless AutoWidth is set, so
+	// long files squeeze their bars together and may lose them to BarSpacing entirely. It has no effect
+	// where the sample rate is unknown, e.g. NewFromSamples (default: 0)
+	BarsPerSecond float64
+	// AutoWidth scales Width with the bars derived from BarsPerSecond, keeping the Width/Bars pixels per
+	// bar of the configuration; a 5 bars per second, 500/100 config renders a minute as 300 bars over
+	// 1500 pixels (default: false)
+	AutoWidth bool
 	// BarSpacing is the space between bars in pixels (default: 2)
 	BarSpacing int
+	// BarWidthRatio, when above 0, makes each bar this fraction of its slot of Width/Bars pixels wide, leaving
+	// the rest as the gap, so the bar-to-gap ratio holds at any width or bar count. It replaces BarSpacing,
+	// which is then ignored; values >= 1 fill the slot without gaps (default: 0)
+	BarWidthRatio float64
+	// MinBarHeight is the least a bar reaches out from the midline in pixels, so silence still shows as a
+	// thin sliver; centred bars are at least twice as tall. 0 means the default, so configs that leave it
+	// out keep the sliver; a negative value lets silent bars vanish, leaving hairlines for dense waveforms.
+	// Butterfly and DeviationView keep their own minimum (default: 3.0)
+	MinBarHeight float64
 	// BarColor is the bar color in hex format (default: "#3B82F6")
 	BarColor string
+	// BackgroundColor fills the full Width x Height behind the bars with this hex color, so dark bars stay
+	// visible on dark pages; empty leaves the background transparent. With BaseShadow the whole card,
+	// background included, casts the shadow (default: "")
+	BackgroundColor string
+	// BackgroundRadius rounds the corners of the background for a card look (default: 0)
+	BackgroundRadius float64
+	// BarColorFunc returns the color in hex format of each bar from its index and its amplitude relative to
+	// the bar that fills the height (see NormalizeMode), 0..1. It overrides BarColor, the gradient, MeterColors and SilenceColor; PerBarFade
+	// fades the color it returns. StereoSplit halves count their bars from 0, and Butterfly and
+	// DeviationView ignore it. It is left out of JSON exports; nil uses the other options (default: nil)
+	BarColorFunc func(index int, amplitude float64) string `json:"-"`
+	// GradientStart and GradientEnd, when both set, fill bars with a vertical linear gradient in hex format
+	// from GradientStart at the top edge to GradientEnd at the bottom edge, and BarColor is ignored.
+	// MeterColors, SilenceColor and PerBarFade still take precedence where they apply (default: "")
+	GradientStart string
+	GradientEnd   string
 	// CornerRadius is the bar corner radius for rounded bars (default: 8.0)
 	CornerRadius float64
+	// SilenceColor is the color in hex format of bars at or below SilenceThreshold, telling true silence
+	// apart from quiet content; empty draws them in BarColor like any other bar (default: "")
+	SilenceColor string
+	// SilenceThreshold is the peak at or below which a bar counts as silent for SilenceColor, on the scale
+	// of Mode (see MaxAmplitude) rather than relative to the loudest bar (default: 0.001)
+	SilenceThreshold float64
+	// Butterfly is a purely decorative layout: the first half of the track rises above the midline and the
+	// second half hangs below it, bar for bar, so Bars/2 bars span the width and the result no longer reads
+	// as a timeline. StereoSplit takes precedence (default: false)
+	Butterfly bool
+	// ProportionalRadius scales each bar's corner radius by its height relative to the tallest possible bar,
+	// so loud bars are soft and quiet ones stay nearly square; CornerRadius is then the largest radius (default: false)
+	ProportionalRadius bool
+	// MaxElements caps the number of bars drawn, protecting browsers from huge SVGs: with more Bars, the
+	// peaks are max-pooled down to MaxElements bars when rendering so short loud moments survive. Overlays
+	// such as the background, loudness curve and markers are not counted; 0 means no cap (default: 0)
+	MaxElements int
 	// Concurrent enables concurrent processing for large files (default: true)
 	Concurrent bool
 	// Mode is the calculation mode to use (default: ModeDynamic)
 	Mode CalculationMode
+	// CoordinatePrecision rounds SVG coordinates to this many decimal places; 0 keeps full precision (default: 0)
+	CoordinatePrecision int
+	// PerBarFade fades each bar from solid at the midline, or at its edge when edge-aligned, to transparent
+	// at its tips (default: false)
+	PerBarFade bool
+	// OpacityByAmplitude fades each bar to an opacity matching its amplitude relative to the bar that
+	// fills the height, so loud passages stand out and quiet ones recede. It applies to StyleBars on top
+	// of any other coloring (default: false)
+	OpacityByAmplitude bool
+	// MinOpacity is the opacity OpacityByAmplitude never fades a bar below, 0..1, so silent bars stay faintly
+	// visible instead of looking like missing data; 0 lets silence vanish (default: 0)
+	MinOpacity float64
+	// RoundTipsOnly rounds only the outer tips of each bar, keeping its sides straight through the midline.
+	// Without it a short bar's corners can meet at the midline and pinch it into a pill (default: false)
+	RoundTipsOnly bool
+	// NormalizeMode decides what fills the full bar height: the loudest bar of each waveform with
+	// NormalizePerFile, or MaxAmplitude with NormalizeFixed, so the relative loudness of different files
+	// stays visible. Louder bars are clamped to the height, and PerChannelNormalize is ignored.
+	// An empty value means NormalizePerFile (default: NormalizePerFile)
+	NormalizeMode NormalizeMode
+	// MaxAmplitude is the peak that fills the full bar height with NormalizeFixed, on the scale of Mode,
+	// used as is instead of scanning the peaks. Full-scale audio reaches 1.0 in the Peak, RMS, MAD, Smooth
+	// and Onset modes, which never exceed it. VU and Dynamic overshoot a little, to about 1.1 for a
+	// full-scale tone, and LUFS and LUFS-true exaggerate loud, bright audio well past 1, so with those
+	// modes pass a maximum taken from real peaks. FixedScaleMax overrides it; values <= 0 mean 1.0
+	// (default: 0)
+	MaxAmplitude float64
+	// FixedScaleMax, when > 0, is the peak that fills the full bar height, on the scale of Mode, used as is
+	// instead of scanning the peaks whatever the NormalizeMode. Passing a maximum precomputed across the
+	// tiles or zoom levels of one file draws them all to the same scale; louder bars are clamped (default: 0)
+	FixedScaleMax float64
+	// AmplitudeScale maps normalized peaks onto bar heights. ScaleLog brings out quiet detail such as speech
+	// by spreading the levels in dB from DBFloor to the bar filling the height. An empty value means
+	// ScaleLinear (default: ScaleLinear)
+	AmplitudeScale AmplitudeScale
+	// DBFloor is the level in dB, relative to the bar filling the height, at and below which bars shrink to
+	// their minimum with ScaleLog; values >= 0 mean -60 (default: -60)
+	DBFloor float64
+	// Gamma bends bar heights as level^Gamma, with levels relative to the bar filling the height and after
+	// AmplitudeScale, so the loudest bars keep their height: values below 1 lift quiet and mid-level
+	// detail, values above 1 emphasize the peaks. DeviationView ignores it; values <= 0 mean 1 (default: 1.0)
+	Gamma float64
+	// Style draws the peaks as separate bars, as one filled shape tracing them (the classic "blob"), or as a
+	// line along their tops. The shape and line pass through the centre of each bar and keep its
+	// height, alignment and fill, but ignore the options that style individual bars, such as CornerRadius,
+	// MeterColors, SilenceColor, BarColorFunc, PerBarFade, SkipThreshold and Tooltips. An empty value
+	// means StyleBars (default: StyleBars)
+	Style Style
+	// LineWidth is the stroke width in pixels of StyleLine (default: 2)
+	LineWidth float64
+	// Alignment anchors bars at the midline, the bottom edge or the top edge. Bottom and top aligned bars
+	// reach up to the full drawing height and are rounded only at their free end; DeviationView,
+	// StereoSplit halves and Butterfly keep their own layout. An empty value means AlignCenter (default: AlignCenter)
+	Alignment Alignment
+	// Orientation lays the waveform out horizontally or vertically. Width and Height stay the size of the
+	// image, so a vertical waveform for a sidebar is narrow and tall: its bars are laid out down the Height
+	// and reach across the Width. It is drawn as a horizontal waveform turned a quarter clockwise, so every
+	// style applies, with bottom aligned bars growing from the left edge and gradients running from
+	// GradientStart on the right to GradientEnd on the left. ClipOverlay, LoopMarkers and ShowAmplitudeAxis
+	// only exist for horizontal waveforms and are ignored. An empty value means OrientationHorizontal
+	// (default: OrientationHorizontal)
+	Orientation Orientation
+	// MeterColors colors each bar by its level like a broadcast meter, overriding BarColor (default: false)
+	MeterColors bool
+	// SkipThreshold omits bars whose peak, relative to the loudest bar, falls below this fraction.
+	// Skipped bars leave empty gaps instead of minimum-height stubs, shrinking quiet-heavy SVGs (default: 0)
+	SkipThreshold float64
+	// TrimSilence drops sustained silence from the start and end of decoded audio files, gating on
+	// short-block loudness so fade-ins and brief pauses are kept. Duration covers the trimmed audio, and
+	// TrimmedStart and TrimmedEnd report what was cut. Entirely silent audio is kept whole (default: false)
+	TrimSilence bool
+	// ApplyReplayGain scales decoded audio by the file's ReplayGain track gain (album gain if no track gain),
+	// read from FLAC/OGG Vorbis comments or MP3 ID3 tags. Positive gains clip at full scale (default: false)
+	ApplyReplayGain bool
+	// PreserveAspectRatio sets the preserveAspectRatio attribute of the root <svg> element, e.g. "none" (default: "")
+	PreserveAspectRatio string
+	// RootAttributes adds attributes such as class or extra xmlns declarations to the root <svg> element,
+	// replacing any the renderer already emits (default: nil)
+	RootAttributes map[string]string
+	// Title and Description, when set, are added as <title> and <desc> elements at the start of the SVG, with
+	// role="img" and an aria-label of the Title on the root element, so screen readers can announce the image.
+	// RootAttributes may override the role and label (default: "")
+	Title       string
+	Description string
+	// BarClass is set as the class attribute of every bar, or of the shape or line of StyleFilled and
+	// StyleLine, so the waveform can be restyled with CSS, e.g. for hover effects or theming. It is added
+	// by post-processing the rendered SVG (default: "")
+	BarClass string
+	// SVGID is set as the id of the root <svg> element, for targeting it from CSS or scripts. Every SVG
+	// rendered with the config gets it, including tiles, so give those configs their own ids (default: "")
+	SVGID string
+	// DeviationView draws each bar up or down from the midline by how far it lies above or below
+	// the average peak instead of centered on it; PerBarFade and RoundTipsOnly are ignored (default: false)
+	DeviationView bool
+	// BaseShadow draws a blurred, offset dark copy of the bars beneath them for a floating-card look (default: false)
+	BaseShadow bool
+	// ShadowOffset is how far the base shadow is shifted right and down in pixels (default: 2)
+	ShadowOffset float64
+	// ShadowBlur is the standard deviation of the base shadow's blur in pixels (default: 2)
+	ShadowBlur float64
+	// AllowOverlap honours a negative BarSpacing, widening bars so they overlap their neighbours
+	// for a dense texture. Otherwise negative spacing is treated as 0 (default: false)
+	AllowOverlap bool
+	// MaxDuration stops decoding after this much audio, rendering only the start of long files; 0 decodes everything (default: 0)
+	MaxDuration time.Duration
+	// StartTime and EndTime limit audio files to the stretch between them, e.g. for a chapter thumbnail. WAV and
+	// AIFF seek straight to StartTime; other formats are decoded from the start and the audio before it discarded.
+	// MaxDuration counts from StartTime. A zero EndTime means the end of the file (default: 0)
+	StartTime time.Duration
+	EndTime   time.Duration
+	// TargetSampleRate resamples audio files to this rate before they are split into bars, so modes whose
+	// filters and frames count in samples, such as LUFS, Smooth and Onset, and exports that count samples, such as
+	// GenerateDat, see the same sample spacing whatever the source rate. Bars still split the audio into
+	// Bars equal parts, so their duration follows the length of the file; use BarsPerSecond to fix it.
+	// Linear interpolation softens peaks a little; 0 keeps the source rate (default: 0)
+	TargetSampleRate int
+	// Streaming computes the bars of audio files while decoding, so memory stays bounded by the decode buffer
+	// instead of growing with the file. Bars can differ very slightly from a full decode, as the sums behind
+	// them are kept in blocks that may straddle two bars. It only applies to the RMS, LUFS, Peak, VU, Dynamic
+	// and MAD modes without TrimSilence, StereoSplit, TargetSampleRate or BarsPerSecond, and decodes
+	// everything otherwise. The waveform keeps no samples, so features that need them, such as Compare, ClipOverlay and
+	// LoudnessCurve, have nothing to work with (default: false)
+	Streaming bool
+	// RetainSamples keeps the decoded samples with the waveform for the analyses and exports that need them:
+	// GenerateDat, GenerateChannelsJSON, GenerateComparison, AmplitudeHistogram, ClipRegions, ShortTermLoudness,
+	// IntegratedLoudness, and PeaksDB outside ModePeak. At 2 bytes a sample an hour of 48kHz stereo takes about
+	// 700MB, so they are only kept on request, or when ClipOverlay or LoudnessCurve need them to render
+	// (default: false)
+	RetainSamples bool
+	// ProgressFunc is called with the fraction of the work done, from 0 to 1, while a waveform is created,
+	// e.g. for a progress bar. Decoding audio files takes up the first 90%, tracking the position in the file,
+	// and computing the bars the rest. Calls are never concurrent, but may come from worker goroutines, and
+	// only report advances of at least 1%; the last one reports 1. Nil reports nothing (default: nil)
+	ProgressFunc func(fraction float64) `json:"-"`
+	// CustomMode measures each bar when Mode is ModeCustom, so a config can bring its own algorithm
+	// without registering a process-wide mode name with RegisterMode. It sees each bar's samples whole,
+	// so Streaming falls back to decoding the file. See LoudnessFunc for the concurrency it must allow
+	// (default: nil)
+	CustomMode LoudnessFunc `json:"-"`
+	// NativeMonoDecode mixes audio files down to mono, asking the decoder to do so while decoding where it can
+	// (FLAC), which is cheaper than decoding every channel. Other formats are mixed after decoding. It only
+	// applies with ChannelMix; StereoSplit then has a single channel to draw (default: false)
+	NativeMonoDecode bool
+	// ViewBoxOnly omits width and height on the root <svg> element, leaving only the viewBox so the
+	// image scales to whatever contains it, e.g. in icon systems (default: false)
+	ViewBoxOnly bool
+	// Scale multiplies the pixel size of PNG output, e.g. 2 for retina images; values <= 0 mean 1 (default: 1.0)
+	Scale float64
+	// StereoSplit draws the left channel of stereo audio files in the top half and the right channel in the
+	// bottom half, each as its own waveform. Mono files render as usual (default: false)
+	StereoSplit bool
+	// PerChannelNormalize scales each half of a StereoSplit render to its own loudest bar so a quiet
+	// channel stays visible. The halves then no longer show how loud the channels are relative to each other (default: false)
+	PerChannelNormalize bool
+	// ClipOverlay marks each stretch of clipped audio with a translucent red band over its bars, labelled with
+	// its time range. Adjacent clipped bars share one band; see ClipRegions (default: false)
+	ClipOverlay bool
+	// Tooltips adds a <title> to each bar with its time range, shown when hovering the bar in a browser.
+	// The title also gives the bar's sample peak in dBFS when that is known: with ModePeak or
+	// RetainSamples. StereoSplit, Butterfly and DeviationView renders get none (default: false)
+	Tooltips bool
+	// ShowAmplitudeAxis draws a vertical axis left of the waveform in SVG output, with a labelled tick where
+	// bars reach each level of AmplitudeAxisTicks. The SVG is widened by 36 pixels to make room. 0 dB is the
+	// top of the drawing: the loudest bar, FixedScaleMax, or MaxAmplitude with NormalizeFixed.
+	// StereoSplit and DeviationView renders get no axis (default: false)
+	ShowAmplitudeAxis bool
+	// AmplitudeAxisTicks are the levels in dB marked on the amplitude axis; levels too quiet to reach the
+	// minimum bar height are left out. Empty means 0, -3, -6, -12 and -18 dB (default: nil)
+	AmplitudeAxisTicks []float64
+	// LoopMarkers shades the loops of audio files with loop points, e.g. from the smpl chunk of WAV files,
+	// and marks their start and end with lines; see LoopPoints (default: false)
+	LoopMarkers bool
+	// LoudnessCurve strokes a line over the bars tracing the short-term loudness of the audio, from -60 LUFS
+	// at the bottom edge to 0 LUFS at the top; see ShortTermLoudness (default: false)
+	LoudnessCurve bool
+	// LoudnessCurveColor is the loudness curve color in hex format (default: "#F59E0B")
+	LoudnessCurveColor string
+	// Channel selects the channels of audio files to visualize; mono files look the same with every choice.
+	// An empty value means ChannelMix (default: ChannelMix)
+	Channel Channel
+	// TimeAxis maps bar x-positions onto the width. With TimeAxisLog bar widths are no longer uniform: the
+	// first bar is about ten times wider than the last. An empty value means TimeAxisLinear
+	// (default: TimeAxisLinear)
+	TimeAxis TimeAxis
+	// DatBits is the resolution of the min/max pairs in audiowaveform .dat exports, 8 or 16 (default: 16)
+	DatBits int
 }
 
+// Orientation selects which way time runs across the waveform
+type Orientation string
+
+const (
+	// OrientationHorizontal runs time from left to right, with bars reaching up and down from a horizontal midline
+	OrientationHorizontal Orientation = "horizontal"
+	// OrientationVertical runs time from top to bottom, with bars reaching left and right from a vertical centerline
+	OrientationVertical Orientation = "vertical"
+)
+
 // DefaultConfig returns a Config with sensible default values
 func DefaultConfig() *Config {
 	return &Config{
-		Width:        500,
-		Height:       80,
-		Bars:         100,
-		BarSpacing:   2,
-		BarColor:     "#3B82F6",
-		CornerRadius: 8.0,
-		Concurrent:   true,
-		Mode:         ModeDynamic,
+		Width:              500,
+		Height:             80,
+		Bars:               100,
+		BarSpacing:         2,
+		BarColor:           "#3B82F6",
+		CornerRadius:       8.0,
+		Concurrent:         true,
+		Mode:               ModeDynamic,
+		ShadowOffset:       2,
+		ShadowBlur:         2,
+		Scale:              1.0,
+		LoudnessCurveColor: "#F59E0B",
+		DatBits:            16,
+		TimeAxis:           TimeAxisLinear,
+		SilenceThreshold:   0.001,
+		Alignment:          AlignCenter,
+		Orientation:        OrientationHorizontal,
+		NormalizeMode:      NormalizePerFile,
+		AmplitudeScale:     ScaleLinear,
+		Style:              StyleBars,
+		LineWidth:          2,
+		DBFloor:            -60,
+		Gamma:              1.0,
+		MinBarHeight:       defaultMinBarHeight,
 	}
 }
 
+// RecommendedHeight returns the height for an image width pixels wide with the
+// given width:height aspect ratio, rounded to whole pixels and at least 1. An
+// aspect of 0 or less uses that of DefaultConfig, 500:80.
+func RecommendedHeight(width int, aspect float64) int {
+	if aspect <= 0 {
+		aspect = 500.0 / 80.0
+	}
+	return max(int(math.Round(float64(width)/aspect)), 1)
+}
+
 // Waveform represents a processed audio waveform with peak data
 type Waveform struct {
 	Peaks  []float64
 	Config *Config
+
+	duration time.Duration
+	// frames is the number of samples per channel the waveform was built from
+	frames int
+	// samples is the decoded audio, kept only when retainsSamples allows
+	samples []int16
+	info    streamInfo
+	// channels holds the per-channel peaks of a StereoSplit waveform, nil otherwise
+	channels [][]float64
+	// trimmedStart and trimmedEnd are the silence TrimSilence cut from either end
+	trimmedStart time.Duration
+	trimmedEnd   time.Duration
+}
+
+// retainsSamples reports whether waveforms built with config keep their
+// samples; see RetainSamples
+func retainsSamples(config *Config) bool {
+	return config.RetainSamples || config.ClipOverlay || config.LoudnessCurve
+}
+
+// wantsMono reports whether the decoded audio should be mixed down to mono
+func wantsMono(config *Config) bool {
+	return config.NativeMonoDecode && (config.Channel == ChannelMix || config.Channel == "")
+}
+
+// decodeRange returns where decoding starts and, unless it is zero, ends for
+// config's StartTime, EndTime and MaxDuration
+func decodeRange(config *Config) (start, end time.Duration, err error) {
+	start, end = config.StartTime, config.EndTime
+	if start < 0 {
+		return 0, 0, fmt.Errorf("negative start time %s", start)
+	}
+	if end != 0 && end <= start {
+		return 0, 0, fmt.Errorf("end time %s is not after start time %s", end, start)
+	}
+	if config.MaxDuration > 0 && (end == 0 || start+config.MaxDuration < end) {
+		end = start + config.MaxDuration
+	}
+	return start, end, nil
 }
 
 // NewFromAudioFile creates a new Waveform from any supported audio file
 func NewFromAudioFile(filename string, config *Config) (*Waveform, error) {
+	return NewFromAudioFileContext(context.Background(), filename, config)
+}
+
+// NewFromAudioFileContext is like NewFromAudioFile, but gives up decoding and
+// computing the bars once ctx is done, returning ctx.Err()
+func NewFromAudioFileContext(ctx context.Context, filename string, config *Config) (*Waveform, error) {
 	if config == nil {
 		config = DefaultConfig()
 	}
 
-	samples, err := readSamplesFromFormat(filename)
+	start, end, err := decodeRange(config)
 	if err != nil {
 		return nil, err
 	}
 
-	var peaks []float64
-	if config.Concurrent {
-		peaks = downsampleConcurrent(samples, config.Bars, config.Mode)
-	} else {
-		peaks = downsample(samples, config.Bars, config.Mode)
+	p := newProgress(config.ProgressFunc)
+	if config.Streaming && canStream(config) {
+		// The bars are computed along the way, so decoding is all there is
+		p.phase(1)
+		peaks, info, total, err := streamPeaks(ctx, filename, start, end, config, 0, p)
+		if err != nil {
+			return nil, err
+		}
+		p.finish()
+
+		return &Waveform{
+			Peaks:    peaks,
+			Config:   config,
+			duration: info.duration(total),
+			frames:   total / max(info.channels, 1),
+			info:     info,
+		}, nil
+	}
+
+	p.phase(progressDecodeShare)
+	samples, info, err := readSamplesFromFormat(ctx, filename, start, end, config.Channel, wantsMono(config), p)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFromDecoded(ctx, samples, info, config, p)
+}
+
+// NewFromAudioReader creates a new Waveform from an audio file of any supported
+// format read from r until EOF, e.g. stdin or an HTTP body. The format is
+// detected from the content. Decoders need to seek, so the stream is spooled to
+// a temporary file first, which is removed again before returning.
+func NewFromAudioReader(r io.Reader, config *Config) (*Waveform, error) {
+	tmp, err := os.CreateTemp("", "gowaveform-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to buffer audio: %w", err)
 	}
+	defer os.Remove(tmp.Name())
 
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to buffer audio: %w", err)
+	}
+
+	return NewFromAudioFile(tmp.Name(), config)
+}
+
+// NewFromPCMReader creates a new Waveform from raw interleaved 16-bit
+// little-endian PCM read from r until EOF, e.g. an HTTP body or an ffmpeg pipe.
+// Config options for audio files, such as Channel, MaxDuration and StartTime, apply as well.
+func NewFromPCMReader(r io.Reader, sampleRate, channels int, config *Config) (*Waveform, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rate %d", sampleRate)
+	}
+	if channels <= 0 {
+		return nil, fmt.Errorf("invalid channel count %d", channels)
+	}
+
+	start, end, err := decodeRange(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The length of the stream isn't known, so only computing the bars reports progress
+	p := newProgress(config.ProgressFunc)
+	p.phase(progressDecodeShare)
+
+	decoder := &pcmReaderDecoder{r: r, sampleRate: sampleRate, channels: channels}
+	samples, info, err := readAllSamples(context.Background(), decoder, 0, start, end, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if wantsMono(config) {
+		samples, info = mixToMono(samples, info)
+	}
+
+	samples, info, err = selectChannel(samples, info, config.Channel)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFromDecoded(context.Background(), samples, info, config, p)
+}
+
+// newFromDecoded runs decoded audio through the processing shared by every
+// audio source and downsamples it into a Waveform, reporting the rest of the
+// work to p. It gives up once ctx is done, returning ctx.Err().
+func newFromDecoded(ctx context.Context, samples []int16, info streamInfo, config *Config, p *progress) (*Waveform, error) {
+	if config.TargetSampleRate > 0 && info.sampleRate > 0 && info.sampleRate != config.TargetSampleRate {
+		samples = resampleLinear(samples, info.channels, info.sampleRate, config.TargetSampleRate)
+		info.loops = scaleLoops(info.loops, info.sampleRate, config.TargetSampleRate)
+		info.sampleRate = config.TargetSampleRate
+	}
+
+	if config.ApplyReplayGain && info.replayGain != 0 {
+		applyGain(samples, info.replayGain)
+	}
+
+	var trimmedStart, trimmedEnd time.Duration
+	if config.TrimSilence {
+		first, last := trimBounds(samples, info)
+		trimmedStart, trimmedEnd = info.duration(first), info.duration(len(samples)-last)
+		samples = samples[first:last]
+		if len(info.loops) > 0 {
+			info.loops = shiftLoops(info.loops, first/max(info.channels, 1))
+		}
+	}
+
+	config = resolveBars(config, info.duration(len(samples)))
+
+	p.phase(1)
+	peaks := computePeaks(ctx, samples, info, config.Bars, config.Mode, config.CustomMode, config.Concurrent, p)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var channels [][]float64
+	if config.StereoSplit && info.channels == 2 {
+		channels = channelPeaks(samples, info, config)
+	}
+
+	p.finish()
+
+	retained := samples
+	if !retainsSamples(config) {
+		retained = nil
+	}
 	return &Waveform{
-		Peaks:  peaks,
-		Config: config,
+		Peaks:    peaks,
+		Config:   config,
+		duration: info.duration(len(samples)),
+		frames:   len(samples) / max(info.channels, 1),
+		samples:  retained,
+		info:     info,
+		channels: channels,
+
+		trimmedStart: trimmedStart,
+		trimmedEnd:   trimmedEnd,
 	}, nil
 }
 
+// resolveBars returns config with Bars derived from BarsPerSecond for audio of
+// the given duration, scaling Width along with it when AutoWidth is set. The
+// caller's config is left untouched.
+func resolveBars(config *Config, duration time.Duration) *Config {
+	if config.BarsPerSecond <= 0 || duration <= 0 {
+		return config
+	}
+
+	resolved := *config
+	resolved.Bars = max(int(math.Round(duration.Seconds()*config.BarsPerSecond)), 1)
+	if config.AutoWidth && config.Bars > 0 {
+		resolved.Width = max(int(math.Round(float64(config.Width)*float64(resolved.Bars)/float64(config.Bars))), 1)
+	}
+	return &resolved
+}
+
 // NewFromMP3File creates a new Waveform from an MP3 file (deprecated: use NewFromAudioFile)
 func NewFromMP3File(filename string, config *Config) (*Waveform, error) {
 	return NewFromAudioFile(filename, config)
@@ -105,53 +654,196 @@ func NewFromSamples(samples []int16, config *Config) *Waveform {
 		config = DefaultConfig()
 	}
 
-	var peaks []float64
-	if config.Concurrent {
-		peaks = downsampleConcurrent(samples, config.Bars, config.Mode)
-	} else {
-		peaks = downsample(samples, config.Bars, config.Mode)
-	}
+	p := newProgress(config.ProgressFunc)
+	p.phase(1)
+	peaks := computePeaks(context.Background(), samples, streamInfo{}, config.Bars, config.Mode, config.CustomMode, config.Concurrent, p)
+	p.finish()
 
+	retained := samples
+	if !retainsSamples(config) {
+		retained = nil
+	}
 	return &Waveform{
-		Peaks:  peaks,
-		Config: config,
+		Peaks:   peaks,
+		Config:  config,
+		frames:  len(samples),
+		samples: retained,
+	}
+}
+
+// NewFromFloatSamples creates a new Waveform from floating point audio samples,
+// where ±1.0 is full scale. Samples beyond full scale are not clipped: the whole
+// signal is attenuated until its loudest sample fits, keeping the relative level
+// of every bar intact.
+func NewFromFloatSamples(samples []float32, config *Config) *Waveform {
+	return NewFromSamples(floatToInt16(samples), config)
+}
+
+// floatToInt16 converts full-scale ±1.0 float samples to int16, scaling the
+// whole signal down if any sample exceeds full scale
+func floatToInt16(samples []float32) []int16 {
+	var peak float64
+	for _, s := range samples {
+		peak = math.Max(peak, math.Abs(float64(s)))
+	}
+
+	gain := 32767.0
+	if peak > 1 {
+		gain /= peak
+	}
+
+	out := make([]int16, len(samples))
+	for i, s := range samples {
+		out[i] = int16(math.Round(float64(s) * gain))
+	}
+	return out
+}
+
+// Duration returns the playback length of the decoded audio. It is zero for
+// waveforms created from raw samples, where the sample rate is unknown.
+func (w *Waveform) Duration() time.Duration {
+	return w.duration
+}
+
+// SampleRate returns the sample rate of the decoded audio in Hz, after any
+// resampling to TargetSampleRate. It is zero for waveforms created from raw
+// samples.
+func (w *Waveform) SampleRate() int {
+	return w.info.sampleRate
+}
+
+// Channels returns the number of channels the waveform was built from, 1 once
+// a single channel was selected or the audio mixed down to mono. It is zero
+// for waveforms created from raw samples.
+func (w *Waveform) Channels() int {
+	return w.info.channels
+}
+
+// SampleCount returns the number of samples per channel the waveform was built
+// from, after StartTime, EndTime, MaxDuration and TrimSilence
+func (w *Waveform) SampleCount() int {
+	return w.frames
+}
+
+// Reverse mirrors the waveform left-to-right by reversing the peaks in place
+func (w *Waveform) Reverse() {
+	reversePeaks(w.Peaks)
+	for _, peaks := range w.channels {
+		reversePeaks(peaks)
+	}
+}
+
+// reversePeaks reverses peaks in place
+func reversePeaks(peaks []float64) {
+	for i, j := 0, len(peaks)-1; i < j; i, j = i+1, j-1 {
+		peaks[i], peaks[j] = peaks[j], peaks[i]
 	}
 }
 
 // WriteSVG writes the waveform to an SVG file
 func (w *Waveform) WriteSVG(filename string) error {
-	return writeSVG(w.Peaks, filename, w.Config)
+	data, err := w.GenerateSVG()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, data, 0644)
 }
 
 // GenerateSVG returns the SVG content as a byte slice without writing to file
 func (w *Waveform) GenerateSVG() ([]byte, error) {
-	// Create a temporary buffer to capture SVG output
-	var buf []byte
-	file := &bytesWriter{data: &buf}
+	data, err := w.generateSVG()
+	if err != nil {
+		return nil, err
+	}
 
-	ctx := canvas.NewContext(svg.New(file, float64(w.Config.Width), float64(w.Config.Height), nil))
+	if w.Config.ShowAmplitudeAxis && w.Config.Orientation != OrientationVertical {
+		data = w.addAmplitudeAxis(data)
+	}
+	return addAccessibleText(data, w.Config), nil
+}
 
-	if err := drawWaveform(ctx, w.Peaks, w.Config); err != nil {
+// generateSVG renders the waveform with its overlays, leaving out the
+// amplitude axis so animations only cover the waveform itself
+func (w *Waveform) generateSVG() ([]byte, error) {
+	data, err := renderDrawing(w.Config, w.draw)
+	if err != nil {
 		return nil, err
 	}
 
-	// Add closing tag and newline
-	*file.data = append(*file.data, []byte("</svg>\n")...)
+	data = addBarClasses(data, w.Config, w.drawsLoudnessCurve())
+	if w.Config.Tooltips {
+		data = w.addTooltips(data)
+	}
+	vertical := w.Config.Orientation == OrientationVertical
+	if w.Config.ClipOverlay && !vertical {
+		data = w.addClipOverlays(data)
+	}
+	if w.Config.LoopMarkers && !vertical {
+		data = w.addLoopMarkers(data)
+	}
+	return data, nil
+}
+
+// draw draws the waveform on ctx, split by channel for StereoSplit waveforms,
+// with the loudness curve on top if enabled
+func (w *Waveform) draw(ctx *canvas.Context) error {
+	var err error
+	config := orient(ctx, w.Config)
+	peaks := capBars(w.Peaks, config)
+	if config.StereoSplit && len(w.channels) == 2 {
+		err = drawStereo(ctx, [][]float64{capBars(w.channels[0], config), capBars(w.channels[1], config)}, config)
+	} else if config.Butterfly {
+		drawButterfly(ctx, peaks, config)
+	} else {
+		err = drawWaveform(ctx, peaks, fullScale(peakMax(peaks), config), config)
+	}
+	if err != nil {
+		return err
+	}
+
+	if w.drawsLoudnessCurve() {
+		drawLoudnessCurve(ctx, w.ShortTermLoudness(), config)
+	}
+	return nil
+}
+
+// drawsLoudnessCurve reports whether draw strokes the loudness curve, which
+// takes the samples to measure: without them, as with Streaming, there is none
+func (w *Waveform) drawsLoudnessCurve() bool {
+	return w.Config.LoudnessCurve && len(w.samples) > 0 && len(w.Peaks) > 0
+}
+
+// GenerateAnimatedSVG returns SVG content that draws the waveform in from left
+// to right, or top to bottom when vertical, over durationMs milliseconds when
+// displayed
+func (w *Waveform) GenerateAnimatedSVG(durationMs int) ([]byte, error) {
+	data, err := w.generateSVG()
+	if err != nil {
+		return nil, err
+	}
 
-	return buf, nil
+	data = animateReveal(data, w.Config, durationMs)
+	if w.Config.ShowAmplitudeAxis && w.Config.Orientation != OrientationVertical {
+		data = w.addAmplitudeAxis(data)
+	}
+	return addAccessibleText(data, w.Config), nil
 }
 
-// UpdateConfig updates the waveform configuration and regenerates peaks if mode changed
+// UpdateConfig updates the waveform configuration and regenerates peaks if mode
+// changed. The samples are kept if the new config asks for them.
 func (w *Waveform) UpdateConfig(config *Config, samples []int16) {
 	oldMode := w.Config.Mode
 	w.Config = config
+	if samples != nil && retainsSamples(config) {
+		w.samples = samples
+	}
 
 	// If mode changed, regenerate peaks
 	if oldMode != config.Mode && samples != nil {
-		if config.Concurrent {
-			w.Peaks = downsampleConcurrent(samples, config.Bars, config.Mode)
-		} else {
-			w.Peaks = downsample(samples, config.Bars, config.Mode)
+		w.Peaks = computePeaks(context.Background(), samples, w.info, config.Bars, config.Mode, config.CustomMode, config.Concurrent, nil)
+		if w.channels != nil {
+			w.channels = channelPeaks(samples, w.info, config)
 		}
 	}
 }
@@ -203,53 +895,76 @@ func readSamples(path string) ([]int16, error) {
 	return pcm, nil
 }
 
-// downsampleConcurrent processes samples using multiple goroutines
-func downsampleConcurrent(samples []int16, buckets int, mode CalculationMode) []float64 {
-	if len(samples) == 0 || buckets == 0 {
-		return nil
+// splitBucketThreshold is the bucket size from which a single bucket is worth splitting across workers
+const splitBucketThreshold = 1 << 16
+
+// computePeaks downsamples samples into bars with mode, measured by custom for
+// ModeCustom, concurrently when concurrent is set. ModeLUFSTrue goes through loudnessPeaks, as K-weighting
+// depends on the format of the audio.
+func computePeaks(ctx context.Context, samples []int16, info streamInfo, bars int, mode CalculationMode, custom LoudnessFunc, concurrent bool, p *progress) []float64 {
+	if _, custom := customMode(mode); mode == ModeLUFSTrue && !custom {
+		return loudnessPeaks(ctx, samples, info, bars, p)
+	}
+	// The other modes measure a single signal, so channels are mixed first:
+	// the bars of a stereo file match those of the same audio in mono
+	samples, _ = mixToMono(samples, info)
+	if concurrent {
+		return downsampleConcurrent(ctx, samples, bars, mode, custom, p)
 	}
+	return downsample(ctx, samples, bars, mode, custom, p)
+}
 
-	samplesPerBucket := len(samples) / buckets
-	if samplesPerBucket == 0 {
-		samplesPerBucket = 1
+// downsampleConcurrent processes samples using multiple goroutines, stepping
+// p once per bucket. Once ctx is done the workers stop, leaving the remaining
+// buckets at 0; callers check ctx.Err().
+func downsampleConcurrent(ctx context.Context, samples []int16, buckets int, mode CalculationMode, custom LoudnessFunc, p *progress) []float64 {
+	if len(samples) == 0 || buckets == 0 {
+		return nil
 	}
 
-	// For small datasets, use sequential processing
-	if len(samples) < 50000 {
-		return downsample(samples, buckets, mode)
+	// For small datasets, use sequential processing, as for the smooth mode
+	// filter, which can't be split up
+	if len(samples) < 50000 || filtersAcrossBuckets(mode) {
+		return downsample(ctx, samples, buckets, mode, custom, p)
 	}
 
 	peaks := make([]float64, buckets)
-	numWorkers := runtime.NumCPU()
+	numWorkers := workersPerCall()
+	measure := modeFunc(mode, custom)
+
+	// With fewer buckets than workers, split each bucket across the workers instead
+	if buckets < numWorkers && len(samples)/buckets >= splitBucketThreshold && supportsSplitBuckets(mode) {
+		for bucket := 0; bucket < buckets && ctx.Err() == nil; bucket++ {
+			startSample, endSample := bucketBounds(bucket, buckets, len(samples))
+			peaks[bucket] = calculateLoudnessParallel(samples, startSample, endSample, mode, numWorkers)
+			p.step(buckets)
+		}
+		return peaks
+	}
 
-	// Calculate work chunks
-	bucketsPerWorker := buckets / numWorkers
-	if bucketsPerWorker == 0 {
-		bucketsPerWorker = 1
+	// Never start workers that would have no buckets
+	if numWorkers > buckets {
 		numWorkers = buckets
 	}
 
 	var wg sync.WaitGroup
 
 	for worker := 0; worker < numWorkers; worker++ {
+		// Wait for a slot in the shared pool before spawning, keeping the goroutine count bounded
+		workers.acquire()
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
+			defer workers.release()
 
-			startBucket := workerID * bucketsPerWorker
-			endBucket := startBucket + bucketsPerWorker
-			if workerID == numWorkers-1 {
-				endBucket = buckets // Last worker takes remaining buckets
-			}
+			startBucket, endBucket := workerBuckets(workerID, numWorkers, buckets)
 
-			for bucket := startBucket; bucket < endBucket; bucket++ {
-				startSample := bucket * samplesPerBucket
-				endSample := startSample + samplesPerBucket
-				if endSample > len(samples) {
-					endSample = len(samples)
+			for bucket := startBucket; bucket < endBucket && ctx.Err() == nil; bucket++ {
+				startSample, endSample := bucketBounds(bucket, buckets, len(samples))
+				if startSample < endSample {
+					peaks[bucket] = measure(samples, startSample, endSample)
 				}
-
-				peaks[bucket] = calculateLoudness(samples, startSample, endSample, mode)
+				p.step(buckets)
 			}
 		}(worker)
 	}
@@ -258,95 +973,603 @@ func downsampleConcurrent(samples []int16, buckets int, mode CalculationMode) []
 	return peaks
 }
 
-// downsample processes samples sequentially
-func downsample(samples []int16, buckets int, mode CalculationMode) []float64 {
+// downsample processes samples sequentially, stepping p once per bucket. Once
+// ctx is done it stops, leaving the remaining buckets at 0; callers check
+// ctx.Err().
+func downsample(ctx context.Context, samples []int16, buckets int, mode CalculationMode, custom LoudnessFunc, p *progress) []float64 {
 	if len(samples) == 0 || buckets == 0 {
 		return nil
 	}
 
-	samplesPerBucket := len(samples) / buckets
-	if samplesPerBucket == 0 {
-		samplesPerBucket = 1
+	if filtersAcrossBuckets(mode) {
+		return smoothPeaks(ctx, samples, buckets, p)
 	}
 
 	peaks := make([]float64, buckets)
+	measure := modeFunc(mode, custom)
 
-	for bucket := 0; bucket < buckets; bucket++ {
-		start := bucket * samplesPerBucket
-		end := start + samplesPerBucket
-		if end > len(samples) {
-			end = len(samples)
+	for bucket := 0; bucket < buckets && ctx.Err() == nil; bucket++ {
+		start, end := bucketBounds(bucket, buckets, len(samples))
+		if start < end {
+			peaks[bucket] = measure(samples, start, end)
 		}
-
-		peaks[bucket] = calculateLoudness(samples, start, end, mode)
+		p.step(buckets)
 	}
 	return peaks
 }
 
-// writeSVG writes peaks to an SVG file
-func writeSVG(peaks []float64, filename string, config *Config) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
+// bucketBounds returns the samples [start, end) of bucket when n samples are
+// spread over buckets. Every sample lands in exactly one bucket and bucket
+// sizes differ by at most one sample; with fewer samples than buckets some
+// buckets are empty.
+func bucketBounds(bucket, buckets, n int) (start, end int) {
+	return bucket * n / buckets, (bucket + 1) * n / buckets
+}
+
+// bucketOf returns the bucket holding sample i of n, the inverse of bucketBounds
+func bucketOf(i, buckets, n int) int {
+	return ((i+1)*buckets - 1) / n
+}
+
+// workerBuckets returns the range of buckets [start, end) handled by worker. The
+// remainder of an uneven split is spread over the first buckets%numWorkers workers,
+// one bucket each, so no worker trails the others by more than a single bucket.
+func workerBuckets(worker, numWorkers, buckets int) (start, end int) {
+	size, extra := buckets/numWorkers, buckets%numWorkers
+	start = worker*size + min(worker, extra)
+	end = start + size
+	if worker < extra {
+		end++
+	}
+	return start, end
+}
+
+// drawWaveform draws the waveform bars on the canvas context, scaling them so
+// that maxPeak reaches the full bar height
+func drawWaveform(ctx *canvas.Context, peaks []float64, maxPeak float64, config *Config) error {
+	// Nothing to draw; leave an empty but valid canvas instead of dividing by zero
+	if len(peaks) == 0 {
+		return nil
 	}
-	defer file.Close()
 
-	ctx := canvas.NewContext(svg.New(file, float64(config.Width), float64(config.Height), nil))
+	// Define colors for clean, flat design; any background is drawn beforehand
+	waveColor := canvas.Hex(config.BarColor)
 
-	if err := drawWaveform(ctx, peaks, config); err != nil {
-		return err
+	// Pre-calculate all constants
+	mid := float64(config.Height) / 2.0
+	maxHeight := float64(config.Height) * 0.48
+
+	// Draw main waveform bars with rounded corners
+	setBarFill(ctx, config)
+
+	cornerRad := config.CornerRadius
+
+	if config.DeviationView {
+		drawDeviation(ctx, peaks, config)
+		return nil
 	}
 
-	// Important: Ensure SVG ends with a newline. Do not remove!
-	file.Write([]byte("</svg>\n"))
+	if config.Style == StyleFilled || config.Style == StyleLine {
+		drawEnvelope(ctx, peaks, maxPeak, config)
+		return nil
+	}
+
+	for i, peak := range peaks {
+		if !barDrawn(i, len(peaks), peak, maxPeak, config) {
+			continue
+		}
+
+		x, span := barSpan(i, len(peaks), config)
+		effectiveBarWidth := barInnerWidth(span, config)
+
+		h := barHalfHeight(peak, maxPeak, config)
+		edgeAligned := config.Alignment == AlignBottom || config.Alignment == AlignTop
+		y, length := barExtent(h, config)
+
+		barColor := waveColor
+		if config.MeterColors {
+			barColor = meterColor(peak)
+		}
+
+		// Silence gets its own faint color so it reads apart from quiet content
+		silent := config.SilenceColor != "" && peak <= config.SilenceThreshold
+		if silent {
+			barColor = canvas.Hex(config.SilenceColor)
+		}
+
+		amplitude := 0.0
+		if maxPeak > 0 {
+			amplitude = math.Min(peak/maxPeak, 1)
+		}
+
+		// The callback has the final say, so every bar is filled individually
+		custom := config.BarColorFunc != nil
+		if custom {
+			barColor = canvas.Hex(config.BarColorFunc(i, amplitude))
+		}
+
+		opacity := 1.0
+		if config.OpacityByAmplitude {
+			opacity = barOpacity(amplitude, config)
+			barColor = withOpacity(barColor, opacity)
+		}
+
+		if config.PerBarFade {
+			if edgeAligned {
+				ctx.SetFillGradient(edgeFadeGradient(x, y, length, config.Alignment == AlignTop, barColor))
+			} else {
+				ctx.SetFillGradient(barFadeGradient(x, mid, h, barColor))
+			}
+		} else if config.MeterColors || silent || custom {
+			ctx.SetFillColor(barColor)
+		} else if config.OpacityByAmplitude {
+			ctx.SetFill(fadedBarPaint(config, opacity))
+		} else if config.SilenceColor != "" {
+			// Back to the usual fill after a silent bar
+			setBarFill(ctx, config)
+		}
+
+		// Clamp the radius to half the bar's smaller side so oversized radii can't
+		// produce degenerate or self-intersecting corners
+		rad := clampRadius(barRadius(cornerRad, h, maxHeight, config), effectiveBarWidth, h*2)
+
+		// Edge-aligned bars are a single solid run from their edge, so only the
+		// free end is rounded
+		if edgeAligned {
+			bar := roundedEndBar(effectiveBarWidth, length, rad, config.Alignment == AlignBottom)
+			if config.Alignment == AlignTop {
+				// Hang the bar from the top edge itself, as height-length+length
+				// can fall short of the edge by a rounding error
+				ctx.DrawPath(x, float64(config.Height), bar.Translate(0, -length))
+			} else {
+				ctx.DrawPath(x, y, bar)
+			}
+			continue
+		}
+
+		// Create rounded rectangle for smooth, modern look
+		var barPath *canvas.Path
+		if config.RoundTipsOnly {
+			barPath = roundedTipBar(effectiveBarWidth, h, rad)
+		} else {
+			barPath = canvas.RoundedRectangle(effectiveBarWidth, h*2, rad)
+		}
+		ctx.DrawPath(x, mid-h, barPath)
+	}
 
 	return nil
 }
 
-// drawWaveform draws the waveform bars on the canvas context
-func drawWaveform(ctx *canvas.Context, peaks []float64, config *Config) error {
-	// Define colors for clean, flat design (no background)
-	waveColor := canvas.Hex(config.BarColor)
+// barDrawn reports whether drawWaveform draws bar i of n, leaving a gap for
+// bars below SkipThreshold and for bars that spacing swallows entirely
+func barDrawn(i, n int, peak, maxPeak float64, config *Config) bool {
+	if config.SkipThreshold > 0 && maxPeak > 0 && peak/maxPeak < config.SkipThreshold {
+		return false
+	}
+	_, span := barSpan(i, n, config)
+	return barInnerWidth(span, config) > 0
+}
 
-	// Pre-calculate all constants
-	barWidth := float64(config.Width) / float64(len(peaks))
-	mid := float64(config.Height) / 2.0
+// barHalfHeight returns how far the bar for peak reaches above and below the
+// midline when maxPeak fills the height, never less than MinBarHeight
+func barHalfHeight(peak, maxPeak float64, config *Config) float64 {
+	peak = applyAmplitudeScale(peak, maxPeak, config)
+
+	// Direct scaling instead of normalize then multiply
 	maxHeight := float64(config.Height) * 0.48
-	barSpacingFloat := float64(config.BarSpacing)
-	minHeight := 3.0
+	scaleFactor := 1.0
+	if maxPeak > 0 {
+		scaleFactor = maxHeight / maxPeak
+	}
+	// Only a fixed scale lets peaks overshoot; keep them within the height
+	return math.Max(math.Min(peak*scaleFactor, maxHeight), minBarHeight(config))
+}
+
+// defaultMinBarHeight is the MinBarHeight in pixels an unset value stands for
+const defaultMinBarHeight = 3.0
+
+// minBarHeight returns the MinBarHeight of config in pixels: the default when
+// it is unset, none when it is negative
+func minBarHeight(config *Config) float64 {
+	switch {
+	case config.MinBarHeight < 0:
+		return 0
+	case config.MinBarHeight == 0:
+		return defaultMinBarHeight
+	}
+	return config.MinBarHeight
+}
+
+// applyAmplitudeScale remaps peak, relative to maxPeak, onto the amplitude scale
+// of config. On a log scale the peak's level in dB below maxPeak is spread
+// linearly from DBFloor, at 0, up to maxPeak itself. Gamma then bends the
+// result, with maxPeak staying where it is.
+func applyAmplitudeScale(peak, maxPeak float64, config *Config) float64 {
+	gamma := barGamma(config)
+	if (config.AmplitudeScale != ScaleLog && gamma == 1) || maxPeak <= 0 {
+		return peak
+	}
+
+	level := math.Min(peak/maxPeak, 1)
+	if config.AmplitudeScale == ScaleLog {
+		level = dbLevel(level, config.DBFloor)
+	}
+	return math.Pow(level, gamma) * maxPeak
+}
+
+// barGamma returns the Gamma of config, 1 for values <= 0
+func barGamma(config *Config) float64 {
+	if config.Gamma <= 0 {
+		return 1
+	}
+	return config.Gamma
+}
+
+// dbLevel maps a level in 0..1 to its position between floor dB, at 0, and
+// 0 dB, at 1. A floor that isn't negative means -60 dB.
+func dbLevel(level, floor float64) float64 {
+	if floor >= 0 {
+		floor = -60
+	}
+	if level <= 0 {
+		return 0
+	}
+	db := 20 * math.Log10(level)
+	if db <= floor {
+		return 0
+	}
+	return 1 - db/floor
+}
+
+// logTimeScale sets how strongly a logarithmic time axis favours the start: the
+// first bar ends up roughly logTimeScale+1 times wider than the last
+const logTimeScale = 9.0
+
+// barSpan returns the left edge and the width of the slot for bar i of n. On a
+// linear time axis every bar gets an equal share of the width; on a log axis
+// the slots shrink from left to right.
+func barSpan(i, n int, config *Config) (x, width float64) {
+	total := float64(config.Width)
+	if config.TimeAxis != TimeAxisLog {
+		width = total / float64(n)
+		return float64(i) * width, width
+	}
+
+	x0 := logTime(float64(i)/float64(n)) * total
+	x1 := logTime(float64(i+1)/float64(n)) * total
+	return x0, x1 - x0
+}
+
+// logTime maps t in [0, 1] onto a logarithmic curve that also spans [0, 1]
+func logTime(t float64) float64 {
+	return math.Log1p(logTimeScale*t) / math.Log1p(logTimeScale)
+}
+
+// barInnerWidth returns the drawn width of a bar within a slot of the given
+// width once the bar spacing is taken off, or its BarWidthRatio share of it
+func barInnerWidth(span float64, config *Config) float64 {
+	if config.BarWidthRatio > 0 {
+		return span * math.Min(config.BarWidthRatio, 1)
+	}
+	// Negative spacing makes neighbouring bars overlap; treat it as no spacing unless asked for
+	if config.BarSpacing < 0 && !config.AllowOverlap {
+		return span
+	}
+	return span - float64(config.BarSpacing)
+}
+
+// barRadius returns the corner radius r for a bar reaching h of maxHeight from
+// the midline, shrinking it with the bar when ProportionalRadius is set
+func barRadius(r, h, maxHeight float64, config *Config) float64 {
+	if !config.ProportionalRadius || maxHeight <= 0 {
+		return r
+	}
+	return r * math.Min(h/maxHeight, 1)
+}
+
+// clampRadius limits the corner radius r to half the smaller of the bar's width
+// and height, keeping its sign
+func clampRadius(r, w, h float64) float64 {
+	limit := math.Max(math.Min(w, h)/2.0, 0)
+	if math.Abs(r) <= limit {
+		return r
+	}
+	return math.Copysign(limit, r)
+}
+
+// drawDeviation draws each bar from the midline by how far its peak deviates from
+// the mean peak: sections louder than average rise above the midline and quieter
+// ones hang below it. The largest deviation fills half the height.
+func drawDeviation(ctx *canvas.Context, peaks []float64, config *Config) {
+	var mean float64
+	for _, peak := range peaks {
+		mean += peak
+	}
+	mean /= float64(len(peaks))
+
+	var maxDev float64
+	for _, peak := range peaks {
+		maxDev = math.Max(maxDev, math.Abs(peak-mean))
+	}
 
-	// Find the maximum peak to normalize the waveform (single pass)
+	mid := float64(config.Height) / 2.0
+	scaleFactor := 0.0
+	if maxDev > 0 {
+		scaleFactor = float64(config.Height) * 0.48 / maxDev
+	}
+
+	// Bars right at the average still get a sliver so the timeline stays readable
+	const minHeight = 1.5
+
+	for i, peak := range peaks {
+		x, span := barSpan(i, len(peaks), config)
+		effectiveBarWidth := barInnerWidth(span, config)
+		if effectiveBarWidth <= 0 {
+			continue
+		}
+
+		dev := peak - mean
+		h := math.Max(math.Abs(dev)*scaleFactor, minHeight)
+
+		if config.MeterColors {
+			ctx.SetFillColor(meterColor(peak))
+		}
+
+		// The canvas y axis points up, so above-average bars start at the midline
+		y := mid
+		if dev < 0 {
+			y = mid - h
+		}
+
+		rad := clampRadius(barRadius(config.CornerRadius, h, float64(config.Height)*0.48, config), effectiveBarWidth, h)
+		ctx.DrawPath(x, y, canvas.RoundedRectangle(effectiveBarWidth, h, rad))
+	}
+}
+
+// fullScale returns the peak that fills the full bar height: maxPeak, the
+// loudest peak of the waveform, unless config fixes the scale
+func fullScale(maxPeak float64, config *Config) float64 {
+	if config.FixedScaleMax > 0 {
+		return config.FixedScaleMax
+	}
+	if config.NormalizeMode != NormalizeFixed {
+		return maxPeak
+	}
+	if config.MaxAmplitude > 0 {
+		return config.MaxAmplitude
+	}
+	return 1.0
+}
+
+// peakMax returns the maximum peak, used to normalize the waveform
+func peakMax(peaks []float64) float64 {
 	var maxPeak float64
 	for _, peak := range peaks {
 		if peak > maxPeak {
 			maxPeak = peak
 		}
 	}
+	return maxPeak
+}
 
-	// Calculate scaling factor once
-	scaleFactor := 1.0
-	if maxPeak > 0 {
-		scaleFactor = maxHeight / maxPeak // Direct scaling instead of normalize then multiply
+// Broadcast meter zones: green below -18 dBFS, yellow up to -6 dBFS, red above
+var (
+	meterGreen  = canvas.Hex("#22C55E")
+	meterYellow = canvas.Hex("#EAB308")
+	meterRed    = canvas.Hex("#EF4444")
+)
+
+const (
+	meterYellowDB = -18.0
+	meterRedDB    = -6.0
+)
+
+// meterColor maps a linear peak level (1.0 = full scale) to its meter zone color
+func meterColor(peak float64) color.RGBA {
+	db := math.Inf(-1)
+	if peak > 0 {
+		db = 20 * math.Log10(peak)
 	}
 
-	// Draw main waveform bars with rounded corners
-	ctx.SetFillColor(waveColor)
+	switch {
+	case db >= meterRedDB:
+		return meterRed
+	case db >= meterYellowDB:
+		return meterYellow
+	default:
+		return meterGreen
+	}
+}
 
-	// Pre-calculate corner radius and bar width
-	cornerRad := config.CornerRadius
-	effectiveBarWidth := barWidth - barSpacingFloat
+// orient turns ctx for drawing a waveform the way config.Orientation lays it
+// out and returns the config to draw it with. Vertical waveforms are drawn as
+// a horizontal one of Height by Width pixels, which the view turns a quarter
+// clockwise: its left edge becomes the top and its bottom edge the left.
+func orient(ctx *canvas.Context, config *Config) *Config {
+	if config.Orientation != OrientationVertical {
+		return config
+	}
 
-	for i, peak := range peaks {
-		x := float64(i) * barWidth
-		h := peak * scaleFactor
-		if h < minHeight {
-			h = minHeight
+	// The canvas y axis points up, so time runs down from y = Height
+	ctx.ComposeView(canvas.Matrix{{0, 1, 0}, {-1, 0, float64(config.Height)}})
+	return layoutConfig(config)
+}
+
+// layoutConfig returns the config a waveform is laid out with before orient
+// turns it: config itself, or a copy with Width and Height swapped when vertical
+func layoutConfig(config *Config) *Config {
+	if config.Orientation != OrientationVertical {
+		return config
+	}
+
+	layout := *config
+	layout.Width, layout.Height = config.Height, config.Width
+	return &layout
+}
+
+// drawBackground fills the whole canvas with BackgroundColor, rounding its
+// corners by BackgroundRadius; it draws nothing without a BackgroundColor
+func drawBackground(ctx *canvas.Context, config *Config) {
+	if config.BackgroundColor == "" {
+		return
+	}
+
+	width, height := float64(config.Width), float64(config.Height)
+	ctx.SetFillColor(canvas.Hex(config.BackgroundColor))
+	ctx.DrawPath(0, 0, canvas.RoundedRectangle(width, height, clampRadius(config.BackgroundRadius, width, height)))
+}
+
+// hasGradient reports whether config fills bars with a gradient instead of BarColor
+func hasGradient(config *Config) bool {
+	return config.GradientStart != "" && config.GradientEnd != ""
+}
+
+// setBarFill sets the fill bars are drawn with; see barPaint
+func setBarFill(ctx *canvas.Context, config *Config) {
+	ctx.SetFill(barPaint(config))
+}
+
+// barPaint returns the paint of the waveform: a vertical gradient running from
+// GradientStart at the top edge to GradientEnd at the bottom edge when both are
+// set, BarColor otherwise. The gradient spans the whole height rather than each
+// bar, so quiet bars only show the colors around the midline.
+func barPaint(config *Config) canvas.Paint {
+	if !hasGradient(config) {
+		return canvas.Paint{Color: canvas.Hex(config.BarColor)}
+	}
+
+	// The canvas y axis points up, so the top edge is at y = Height
+	gradient := canvas.NewLinearGradient(canvas.Point{X: 0, Y: float64(config.Height)}, canvas.Point{X: 0, Y: 0})
+	gradient.Add(0.0, canvas.Hex(config.GradientStart))
+	gradient.Add(1.0, canvas.Hex(config.GradientEnd))
+	return canvas.Paint{Gradient: gradient}
+}
+
+// barOpacity returns the opacity OpacityByAmplitude gives a bar of amplitude
+// 0..1 relative to the bar that fills the height, floored at MinOpacity
+func barOpacity(amplitude float64, config *Config) float64 {
+	return math.Min(math.Max(amplitude, config.MinOpacity), 1)
+}
+
+// withOpacity scales col to opacity 0..1. Colors are premultiplied by their
+// alpha, so every component scales along.
+func withOpacity(col color.RGBA, opacity float64) color.RGBA {
+	scale := func(v uint8) uint8 { return uint8(math.Round(float64(v) * opacity)) }
+	return color.RGBA{R: scale(col.R), G: scale(col.G), B: scale(col.B), A: scale(col.A)}
+}
+
+// fadedBarPaint is barPaint with every color scaled to opacity
+func fadedBarPaint(config *Config, opacity float64) canvas.Paint {
+	paint := barPaint(config)
+	if gradient, ok := paint.Gradient.(*canvas.LinearGradient); ok {
+		for i := range gradient.Stops {
+			gradient.Stops[i].Color = withOpacity(gradient.Stops[i].Color, opacity)
 		}
+	}
+	paint.Color = withOpacity(paint.Color, opacity)
+	return paint
+}
 
-		// Create rounded rectangle for smooth, modern look
-		barPath := canvas.RoundedRectangle(effectiveBarWidth, h*2, cornerRad)
-		ctx.DrawPath(x, mid-h, barPath)
+// barExtent returns the lower edge, in canvas coordinates, and the full length
+// of a bar reaching h above and below the midline when centered. Edge-aligned
+// bars keep that length but start at their edge, so the loudest bar spans the
+// same 96% of the height in every alignment.
+func barExtent(h float64, config *Config) (y, length float64) {
+	height := float64(config.Height)
+	switch config.Alignment {
+	case AlignBottom:
+		// The canvas y axis points up, so the bottom edge of the SVG is y = 0
+		return 0, h * 2
+	case AlignTop:
+		return height - h*2, h * 2
+	default:
+		return height/2 - h, h * 2
 	}
+}
 
-	return nil
+// barFadeGradient returns a vertical gradient spanning a single bar that is solid
+// at the midline and fades to transparent at both tips. Since the gradient spans
+// the bar itself, the fade region grows with the bar's amplitude.
+func barFadeGradient(x, mid, h float64, col color.RGBA) *canvas.LinearGradient {
+	gradient := canvas.NewLinearGradient(canvas.Point{X: x, Y: mid - h}, canvas.Point{X: x, Y: mid + h})
+	gradient.Add(0.0, canvas.Transparent)
+	gradient.Add(0.5, col)
+	gradient.Add(1.0, canvas.Transparent)
+	return gradient
+}
+
+// edgeFadeGradient is the barFadeGradient of an edge-aligned bar spanning y to
+// y+length: solid where the bar meets its edge, transparent at its free end.
+func edgeFadeGradient(x, y, length float64, fromTop bool, col color.RGBA) *canvas.LinearGradient {
+	start, end := canvas.Point{X: x, Y: y}, canvas.Point{X: x, Y: y + length}
+	if fromTop {
+		start, end = end, start
+	}
+	gradient := canvas.NewLinearGradient(start, end)
+	gradient.Add(0.0, col)
+	gradient.Add(1.0, canvas.Transparent)
+	return gradient
+}
+
+// roundedTipBar builds a bar of width w extending h above and below its midline.
+// Only the outer tips are rounded: the radius is held to half of each half-bar, so
+// the sides always run straight through the midline, even on bars too short for
+// a full corner.
+func roundedTipBar(w, h, r float64) *canvas.Path {
+	if w <= 0 || h <= 0 {
+		return &canvas.Path{}
+	}
+
+	r = math.Min(math.Abs(r), w/2.0)
+	r = math.Min(r, h/2.0)
+	if r == 0 {
+		return canvas.Rectangle(w, h*2)
+	}
+
+	p := &canvas.Path{}
+	p.MoveTo(0, h)
+	p.LineTo(0, r)
+	p.ArcTo(r, r, 0, false, true, r, 0)
+	p.LineTo(w-r, 0)
+	p.ArcTo(r, r, 0, false, true, w, r)
+	p.LineTo(w, h*2-r)
+	p.ArcTo(r, r, 0, false, true, w-r, h*2)
+	p.LineTo(r, h*2)
+	p.ArcTo(r, r, 0, false, true, 0, h*2-r)
+	p.Close()
+	return p
+}
+
+// roundedEndBar builds a bar of width w and height h rounded only at one end:
+// the top when roundTop is set, the bottom otherwise. The opposite end stays
+// square where it meets the edge the bar is anchored to.
+func roundedEndBar(w, h, r float64, roundTop bool) *canvas.Path {
+	if w <= 0 || h <= 0 {
+		return &canvas.Path{}
+	}
+
+	r = math.Min(math.Abs(r), w/2.0)
+	r = math.Min(r, h)
+	if r == 0 {
+		return canvas.Rectangle(w, h)
+	}
+
+	p := &canvas.Path{}
+	if roundTop {
+		p.MoveTo(0, 0)
+		p.LineTo(w, 0)
+		p.LineTo(w, h-r)
+		p.ArcTo(r, r, 0, false, true, w-r, h)
+		p.LineTo(r, h)
+		p.ArcTo(r, r, 0, false, true, 0, h-r)
+	} else {
+		p.MoveTo(0, h)
+		p.LineTo(0, r)
+		p.ArcTo(r, r, 0, false, true, r, 0)
+		p.LineTo(w-r, 0)
+		p.ArcTo(r, r, 0, false, true, w, r)
+		p.LineTo(w, h)
+	}
+	p.Close()
+	return p
 }
@@ -1,8 +1,29 @@
 package waveform
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"math/bits"
+	"math/rand"
 	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/tdewolff/canvas"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -21,6 +42,27 @@ func TestDefaultConfig(t *testing.T) {
 	}
 }
 
+func TestRecommendedHeight(t *testing.T) {
+	tests := []struct {
+		width  int
+		aspect float64
+		want   int
+	}{
+		{800, 4, 200},
+		{1000, 3, 333},
+		{500, 6, 83}, // 83.3 rounds down
+		{500, 8, 63}, // 62.5 rounds up
+		{500, 0, 80}, // DefaultConfig's aspect
+		{10, 100, 1}, // never below a pixel
+		{1920, 16.0 / 9, 1080},
+	}
+	for _, tt := range tests {
+		if got := RecommendedHeight(tt.width, tt.aspect); got != tt.want {
+			t.Errorf("RecommendedHeight(%d, %g) = %d, want %d", tt.width, tt.aspect, got, tt.want)
+		}
+	}
+}
+
 func TestCalculationModes(t *testing.T) {
 	modes := []CalculationMode{
 		ModeRMS,
@@ -29,6 +71,9 @@ func TestCalculationModes(t *testing.T) {
 		ModeVU,
 		ModeDynamic,
 		ModeSmooth,
+		ModeMAD,
+		ModeOnset,
+		ModeLUFSTrue,
 	}
 
 	// Test with dummy samples
@@ -45,6 +90,156 @@ func TestCalculationModes(t *testing.T) {
 	}
 }
 
+func TestSmoothAcrossBuckets(t *testing.T) {
+	// A square wave of constant amplitude should give bars of constant height
+	// once the filter has settled in the first bar
+	samples := make([]int16, 200000)
+	for i := range samples {
+		samples[i] = 10000
+		if i/50%2 == 1 {
+			samples[i] = -10000
+		}
+	}
+
+	serial := downsample(context.Background(), samples, 100, ModeSmooth, nil, nil)
+	concurrent := downsampleConcurrent(context.Background(), samples, 100, ModeSmooth, nil, nil)
+	if !slices.Equal(serial, concurrent) {
+		t.Error("Expected the concurrent path to filter the samples exactly like the serial one")
+	}
+
+	if serial[0] >= serial[1] {
+		t.Errorf("Expected the first bar to include the filter settling, got %f >= %f", serial[0], serial[1])
+	}
+	for i := 2; i < len(serial); i++ {
+		if math.Abs(serial[i]-serial[1]) > 1e-9 {
+			t.Errorf("Expected bar %d to match bar 1 without a dip at its start, got %f and %f", i, serial[i], serial[1])
+		}
+	}
+}
+
+func TestRegisterMode(t *testing.T) {
+	const mode CalculationMode = "test-constant"
+	var calls int
+	var mu sync.Mutex
+	RegisterMode(mode, func(samples []int16, start, end int) float64 {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return float64(end-start) / 1000
+	})
+	defer RegisterMode(mode, nil)
+
+	// Enough samples for the concurrent path to spread the buckets over its workers
+	samples := make([]int16, 100000)
+	for name, fn := range map[string]func(context.Context, []int16, int, CalculationMode, LoudnessFunc, *progress) []float64{
+		"serial":     downsample,
+		"concurrent": downsampleConcurrent,
+	} {
+		calls = 0
+		peaks := fn(context.Background(), samples, 1000, mode, nil, nil)
+		if calls != 1000 {
+			t.Errorf("Expected the %s custom mode to run once per bucket, got %d calls", name, calls)
+		}
+		for i, peak := range peaks {
+			if peak != 0.1 {
+				t.Errorf("Expected bucket %d to use the custom mode, got %f", i, peak)
+			}
+		}
+	}
+}
+
+func TestCustomMode(t *testing.T) {
+	// Enough samples for the concurrent path to spread the buckets over its workers
+	samples := make([]int16, 100000)
+	for i := range samples {
+		samples[i] = int16(i % 1000)
+	}
+
+	// Two configs measure the same audio their own way at the same time,
+	// without a process-wide name to clash on
+	constant := func(samples []int16, start, end int) float64 { return 0.25 }
+	first := func(samples []int16, start, end int) float64 { return float64(samples[start]) / 1000 }
+	funcs := []LoudnessFunc{constant, first}
+	results := make([][]float64, len(funcs))
+	var wg sync.WaitGroup
+	for i, fn := range funcs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			config := DefaultConfig()
+			config.Bars = 1000
+			config.Mode = ModeCustom
+			config.CustomMode = fn
+			results[i] = NewFromSamples(samples, config).Peaks
+		}()
+	}
+	wg.Wait()
+
+	for i := range results[0] {
+		if results[0][i] != 0.25 {
+			t.Fatalf("Expected bucket %d to use the constant mode, got %f", i, results[0][i])
+		}
+		if want := float64(i*100%1000) / 1000; results[1][i] != want {
+			t.Fatalf("Expected bucket %d to use the first-sample mode, %f, got %f", i, want, results[1][i])
+		}
+	}
+	if _, ok := customMode(ModeCustom); ok {
+		t.Error("Expected CustomMode to leave the mode registry alone")
+	}
+
+	// Custom modes see whole bars, so they can't be streamed
+	config := DefaultConfig()
+	config.Mode = ModeCustom
+	config.CustomMode = constant
+	if canStream(config) {
+		t.Error("Expected ModeCustom to decode the whole file")
+	}
+}
+
+func TestSqrt(t *testing.T) {
+	for _, x := range []float64{1e-9, 0.001, 0.25, 0.5, 1, 2, 1234.5} {
+		want := math.Sqrt(x)
+		if got := fastSqrt(x); math.Abs(got-want)/want > 0.002 {
+			t.Errorf("fastSqrt(%g) = %g, more than 0.2%% off %g", x, got, want)
+		}
+		if got := sqrt(x); preciseMath && got != want {
+			t.Errorf("sqrt(%g) = %g with precisemath, expected exactly %g", x, got, want)
+		}
+	}
+	if sqrt(0) != 0 || sqrt(-1) != 0 {
+		t.Error("Expected the square root of non-positive values to be 0")
+	}
+}
+
+// BenchmarkFastSqrt and BenchmarkMathSqrt compare the two square roots behind
+// the calculation modes; see the precisemath build tag
+func BenchmarkFastSqrt(b *testing.B) {
+	var sum float64
+	for i := 0; i < b.N; i++ {
+		sum += fastSqrt(float64(i%1000) + 0.5)
+	}
+	_ = sum
+}
+
+func BenchmarkMathSqrt(b *testing.B) {
+	var sum float64
+	for i := 0; i < b.N; i++ {
+		sum += math.Sqrt(float64(i%1000) + 0.5)
+	}
+	_ = sum
+}
+
+func BenchmarkRMSMode(b *testing.B) {
+	samples := make([]int16, 1<<20)
+	for i := range samples {
+		samples[i] = int16(rand.Intn(65536) - 32768)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		downsample(context.Background(), samples, 4096, ModeRMS, nil, nil)
+	}
+}
+
 func TestNewFromSamples(t *testing.T) {
 	// Create dummy samples
 	samples := make([]int16, 1000)
@@ -149,12 +344,2656 @@ func TestInvalidInputs(t *testing.T) {
 	}
 }
 
-// Helper function since Go doesn't have strings.Contains in older versions
-func containsString(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
+func TestPerBarFade(t *testing.T) {
+	col := color.RGBA{R: 0x3B, G: 0x82, B: 0xF6, A: 0xFF}
+
+	short := barFadeGradient(0, 40, 5, col)
+	tall := barFadeGradient(0, 40, 30, col)
+
+	shortFade := short.End.Y - short.Start.Y
+	tallFade := tall.End.Y - tall.Start.Y
+	if tallFade <= shortFade {
+		t.Errorf("Expected taller bar to have a longer fade region, got %f <= %f", tallFade, shortFade)
+	}
+
+	if tall.Stops.At(0.5) != col {
+		t.Error("Expected bar to be solid at the midline")
+	}
+	if tall.Stops.At(0.0).A != 0 || tall.Stops.At(1.0).A != 0 {
+		t.Error("Expected bar to be transparent at its tips")
+	}
+
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = int16(i % 300)
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.PerBarFade = true
+
+	svgData, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	if !containsString(string(svgData), "<linearGradient") {
+		t.Error("SVG data doesn't contain per-bar gradients")
+	}
+}
+
+func TestMinOpacity(t *testing.T) {
+	// Five silent bars followed by five loud ones
+	samples := make([]int16, 1000)
+	for i := 500; i < len(samples); i++ {
+		samples[i] = int16(20000 * math.Sin(float64(i)))
+	}
+
+	alpha := regexp.MustCompile(`fill="rgba\(\d+,\d+,\d+,([.\d]+)\)"`)
+	opacities := func(config *Config) []float64 {
+		t.Helper()
+		svgData, err := NewFromSamples(samples, config).GenerateSVG()
+		if err != nil {
+			t.Fatalf("GenerateSVG failed: %v", err)
+		}
+		var out []float64
+		for _, m := range alpha.FindAllStringSubmatch(string(svgData), -1) {
+			a, _ := strconv.ParseFloat(m[1], 64)
+			out = append(out, a)
+		}
+		return out
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.Mode = ModePeak
+	config.OpacityByAmplitude = true
+	config.MinOpacity = 0.2
+
+	// Opaque bars keep their plain hex fill, so only the silent ones match
+	faded := opacities(config)
+	if len(faded) != 5 {
+		t.Fatalf("Expected the 5 silent bars to be translucent, got %d: %v", len(faded), faded)
+	}
+	for i, a := range faded {
+		if math.Abs(a-0.2) > 0.01 {
+			t.Errorf("Expected silent bar %d at MinOpacity 0.2, got %.3f", i, a)
+		}
+	}
+
+	// Without a floor silence vanishes completely
+	config.MinOpacity = 0
+	svgData, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	if n := strings.Count(string(svgData), "<path"); n != 5 {
+		t.Errorf("Expected only the 5 loud bars to be drawn without MinOpacity, got %d paths", n)
+	}
+}
+
+// midlineSides counts the arcs of a bar path extending h either side of its
+// midline and the sides that run straight through the midline
+func midlineSides(t *testing.T, path *canvas.Path, h float64) (arcs, sides int) {
+	t.Helper()
+	straight := map[float64]bool{}
+	for scanner := path.Scanner(); scanner.Scan(); {
+		start, end := scanner.Start(), scanner.End()
+		switch scanner.Cmd() {
+		case canvas.ArcToCmd:
+			arcs++
+		case canvas.LineToCmd, canvas.CloseCmd:
+			if start.X == end.X && start.Y != end.Y && (start.Y-h)*(end.Y-h) <= 0 {
+				straight[start.X] = true
+			}
+		}
+	}
+	return arcs, len(straight)
+}
+
+func TestRoundedTipBar(t *testing.T) {
+	// A short, wide bar: a full corner radius would reach the midline
+	w, h := 8.0, 2.0
+	r := clampRadius(8, w, h*2)
+
+	if _, sides := midlineSides(t, canvas.RoundedRectangle(w, h*2, r), h); sides != 0 {
+		t.Fatalf("Expected the plain bar's corners to meet at the midline, got %d straight sides", sides)
+	}
+
+	path := roundedTipBar(w, h, r)
+	arcs, sides := midlineSides(t, path, h)
+	if arcs != 4 {
+		t.Errorf("Expected 4 rounded corners, got %d", arcs)
+	}
+	if sides != 2 {
+		t.Errorf("Expected both sides straight through the midline, got %d", sides)
+	}
+	for scanner := path.Scanner(); scanner.Scan(); {
+		if scanner.Cmd() != canvas.ArcToCmd {
+			continue
+		}
+		for _, p := range []canvas.Point{scanner.Start(), scanner.End()} {
+			if p.Y > h/2 && p.Y < h*2-h/2 {
+				t.Errorf("Arc point %v lies outside the bar tips", p)
+			}
+		}
+	}
+
+	bounds := path.Bounds()
+	if bounds.W() != w || bounds.H() != h*2 {
+		t.Errorf("Expected bar bounds %vx%v, got %vx%v", w, h*2, bounds.W(), bounds.H())
+	}
+
+	// Tall bars draw the same either way
+	tall := 30.0
+	if got, want := roundedTipBar(w, tall, 3).Bounds(), canvas.RoundedRectangle(w, tall*2, 3).Bounds(); got != want {
+		t.Errorf("Expected a tall tip-rounded bar to span %v, got %v", want, got)
+	}
+
+	// The option reaches the drawn bars
+	config := DefaultConfig()
+	config.Width, config.Height, config.Bars, config.CornerRadius = 40, 40, 4, 8
+	peaks := []float64{0.05, 1, 0.05, 1}
+	plain, err := renderSVG(peaks, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.RoundTipsOnly = true
+	tips, err := renderSVG(peaks, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(plain, tips) {
+		t.Error("Expected RoundTipsOnly to change how short bars are drawn")
+	}
+}
+
+func TestReverse(t *testing.T) {
+	w := &Waveform{Peaks: []float64{0.1, 0.2, 0.3, 0.4, 0.5}, Config: DefaultConfig()}
+	w.Reverse()
+
+	expected := []float64{0.5, 0.4, 0.3, 0.2, 0.1}
+	for i, peak := range w.Peaks {
+		if peak != expected[i] {
+			t.Errorf("Expected peak %d to be %f, got %f", i, expected[i], peak)
+		}
+	}
+}
+
+func TestCoordinatePrecision(t *testing.T) {
+	samples := make([]int16, 3000)
+	for i := range samples {
+		samples[i] = int16((i * 37) % 1000)
+	}
+
+	config := DefaultConfig()
+	config.Width = 333
+	config.Bars = 70
+
+	full, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	config.CoordinatePrecision = 1
+	rounded, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	if len(rounded) >= len(full) {
+		t.Errorf("Expected rounded SVG to be smaller, got %d >= %d bytes", len(rounded), len(full))
+	}
+
+	if tooPrecise := regexp.MustCompile(`\.\d{2,}`).Find(rounded); tooPrecise != nil {
+		t.Errorf("Found coordinate with more than 1 decimal: %s", tooPrecise)
+	}
+
+	if !containsString(string(rounded), `version="1.1"`) {
+		t.Error("Expected SVG version attribute to be left untouched")
+	}
+
+	// Minified arc flags run into the next coordinate and must survive rounding
+	path := string(roundPathData([]byte("M0 70.4321A8 8 0 0056.6123 1.06z"), 1))
+	if expected := "M0 70.4A8 8 0 0 0 56.6 1.1z"; path != expected {
+		t.Errorf("Expected path %q, got %q", expected, path)
+	}
+}
+
+func TestSplitBucketMatchesSerial(t *testing.T) {
+	samples := make([]int16, 100003)
+	for i := range samples {
+		samples[i] = int16((i*7919)%20000 - 10000)
+	}
+
+	modes := []CalculationMode{ModeRMS, ModeLUFS, ModePeak, ModeVU, ModeDynamic, ModeMAD}
+	for _, mode := range modes {
+		serial := calculateLoudness(samples, 0, len(samples), mode)
+		for _, workers := range []int{2, 3, 8} {
+			parallel := calculateLoudnessParallel(samples, 0, len(samples), mode, workers)
+			if diff := math.Abs(parallel - serial); diff > serial*1e-6 {
+				t.Errorf("Mode %s with %d workers: expected %f, got %f", mode, workers, serial, parallel)
+			}
+		}
+	}
+}
+
+func BenchmarkFewLargeBuckets(b *testing.B) {
+	samples := make([]int16, 100_000_000)
+	for i := range samples {
+		samples[i] = int16(i % 30000)
+	}
+	samplesPerBucket := len(samples) / 10
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			downsample(context.Background(), samples, 10, ModeRMS, nil, nil)
+		}
+	})
+
+	b.Run("split", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for bucket := 0; bucket < 10; bucket++ {
+				start := bucket * samplesPerBucket
+				calculateLoudnessParallel(samples, start, start+samplesPerBucket, ModeRMS, runtime.NumCPU())
+			}
+		}
+	})
+}
+
+func TestWorkerBucketsCoverAll(t *testing.T) {
+	for _, numWorkers := range []int{1, 3, 8} {
+		for buckets := numWorkers; buckets <= 50; buckets++ {
+			next := 0
+			for worker := 0; worker < numWorkers; worker++ {
+				start, end := workerBuckets(worker, numWorkers, buckets)
+				if start != next {
+					t.Fatalf("%d buckets over %d workers: worker %d starts at %d, expected %d", buckets, numWorkers, worker, start, next)
+				}
+				if size := end - start; size < buckets/numWorkers || size > buckets/numWorkers+1 {
+					t.Errorf("%d buckets over %d workers: worker %d got %d buckets", buckets, numWorkers, worker, size)
+				}
+				next = end
+			}
+			if next != buckets {
+				t.Errorf("%d buckets over %d workers: covered %d", buckets, numWorkers, next)
+			}
+		}
+	}
+
+	// The concurrent path still matches the serial one on an uneven split
+	samples := make([]int16, 200_003)
+	for i := range samples {
+		samples[i] = int16((i*7919)%20000 - 10000)
+	}
+	serial := downsample(context.Background(), samples, 2*runtime.NumCPU()-1, ModeRMS, nil, nil)
+	concurrent := downsampleConcurrent(context.Background(), samples, len(serial), ModeRMS, nil, nil)
+	for i := range serial {
+		if serial[i] != concurrent[i] {
+			t.Fatalf("Bucket %d: expected %f, got %f", i, serial[i], concurrent[i])
+		}
+	}
+}
+
+// BenchmarkUnevenWorkerSplit compares the wall time of 15 buckets over 8 workers
+// when the last worker takes the whole remainder against the balanced split
+func BenchmarkUnevenWorkerSplit(b *testing.B) {
+	const numWorkers, buckets = 8, 15
+	samples := make([]int16, 15_000_000)
+	for i := range samples {
+		samples[i] = int16(i % 30000)
+	}
+	samplesPerBucket := len(samples) / buckets
+
+	lastTakesRemainder := func(worker, numWorkers, buckets int) (int, int) {
+		size := buckets / numWorkers
+		if worker == numWorkers-1 {
+			return worker * size, buckets
+		}
+		return worker * size, (worker + 1) * size
+	}
+
+	run := func(b *testing.B, split func(worker, numWorkers, buckets int) (int, int)) {
+		for i := 0; i < b.N; i++ {
+			var wg sync.WaitGroup
+			for worker := 0; worker < numWorkers; worker++ {
+				wg.Add(1)
+				go func(worker int) {
+					defer wg.Done()
+					start, end := split(worker, numWorkers, buckets)
+					for bucket := start; bucket < end; bucket++ {
+						calculateLoudness(samples, bucket*samplesPerBucket, (bucket+1)*samplesPerBucket, ModeRMS)
+					}
+				}(worker)
+			}
+			wg.Wait()
+		}
+	}
+
+	b.Run("last-takes-remainder", func(b *testing.B) { run(b, lastTakesRemainder) })
+	b.Run("balanced", func(b *testing.B) { run(b, workerBuckets) })
+}
+
+func TestGenerateAnimatedSVG(t *testing.T) {
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = int16(i % 400)
+	}
+
+	config := DefaultConfig()
+	config.Bars = 30
+
+	svgData, err := NewFromSamples(samples, config).GenerateAnimatedSVG(1500)
+	if err != nil {
+		t.Fatalf("GenerateAnimatedSVG failed: %v", err)
+	}
+
+	svgStr := string(svgData)
+	if !containsString(svgStr, "<animate") || !containsString(svgStr, `dur="1500ms"`) {
+		t.Error("Animated SVG doesn't contain the reveal animation")
+	}
+
+	if bars := strings.Count(svgStr, "<path"); bars != 30 {
+		t.Errorf("Expected 30 bars, got %d", bars)
+	}
+
+	if !strings.HasSuffix(svgStr, "</g></svg>\n") {
+		t.Error("Expected animated group to be closed before the SVG end tag")
+	}
+}
+
+func TestMeterColors(t *testing.T) {
+	tests := []struct {
+		db       float64
+		expected color.RGBA
+	}{
+		{-20, meterGreen},
+		{-12, meterYellow},
+		{-3, meterRed},
+	}
+
+	for _, tt := range tests {
+		peak := math.Pow(10, tt.db/20)
+		if got := meterColor(peak); got != tt.expected {
+			t.Errorf("Expected %v at %v dBFS, got %v", tt.expected, tt.db, got)
+		}
+	}
+
+	if got := meterColor(0); got != meterGreen {
+		t.Errorf("Expected silence to be green, got %v", got)
+	}
+}
+
+func TestMaxWorkersBoundsGoroutines(t *testing.T) {
+	SetMaxWorkers(2)
+	defer SetMaxWorkers(0)
+
+	if MaxWorkers() != 2 {
+		t.Fatalf("Expected max workers 2, got %d", MaxWorkers())
+	}
+
+	samples := make([]int16, 200000)
+	for i := range samples {
+		samples[i] = int16(i % 3000)
+	}
+
+	const callers = 16
+	baseline := runtime.NumGoroutine()
+
+	done := make(chan struct{})
+	maxSeen := make(chan int)
+	go func() {
+		highest := 0
+		for {
+			select {
+			case <-done:
+				maxSeen <- highest
+				return
+			default:
+				if n := runtime.NumGoroutine(); n > highest {
+					highest = n
+				}
+				runtime.Gosched()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			NewFromSamples(samples, DefaultConfig())
+		}()
+	}
+	wg.Wait()
+	close(done)
+
+	// Baseline, the sampler, the callers and at most two workers
+	if highest, limit := <-maxSeen, baseline+1+callers+2; highest > limit {
+		t.Errorf("Expected at most %d goroutines, saw %d", limit, highest)
+	}
+}
+
+func TestMADFullScaleSine(t *testing.T) {
+	samples := make([]int16, 44100)
+	for i := range samples {
+		samples[i] = int16(32767 * math.Sin(2*math.Pi*441*float64(i)/44100))
+	}
+
+	// The mean absolute value of a full-scale sine is 2/pi
+	mad := calculateLoudness(samples, 0, len(samples), ModeMAD)
+	if math.Abs(mad-2/math.Pi) > 0.001 {
+		t.Errorf("Expected MAD of %f, got %f", 2/math.Pi, mad)
+	}
+}
+
+func TestGenerateSVGEmptyPeaks(t *testing.T) {
+	w := &Waveform{Peaks: []float64{}, Config: DefaultConfig()}
+
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	svgStr := string(svgData)
+	if !containsString(svgStr, "<svg") || !strings.HasSuffix(svgStr, "</svg>\n") {
+		t.Errorf("Expected a valid empty SVG document, got %q", svgStr)
+	}
+
+	if containsString(svgStr, "NaN") || containsString(svgStr, "Inf") || containsString(svgStr, "<path") {
+		t.Errorf("Expected no geometry for empty peaks, got %q", svgStr)
+	}
+}
+
+func TestResizeMaxPoolingPreservesPeaks(t *testing.T) {
+	peaks := make([]float64, 100)
+	for i := range peaks {
+		peaks[i] = 0.1
+	}
+	peaks[51] = 1.0
+
+	linear := resamplePeaks(peaks, 10, InterpolationLinear)
+	pooled := resamplePeaks(peaks, 10, InterpolationMax)
+
+	maxOf := func(values []float64) float64 {
+		highest := 0.0
+		for _, v := range values {
+			highest = math.Max(highest, v)
+		}
+		return highest
+	}
+
+	if maxOf(pooled) != 1.0 {
+		t.Errorf("Expected max-pooling to preserve the sharp peak, got %f", maxOf(pooled))
+	}
+	if maxOf(linear) >= 1.0 {
+		t.Errorf("Expected linear interpolation to attenuate the sharp peak, got %f", maxOf(linear))
+	}
+
+	config := DefaultConfig()
+	w := &Waveform{Peaks: peaks, Config: config}
+	w.Resize(25, InterpolationNearest)
+	if len(w.Peaks) != 25 {
+		t.Errorf("Expected 25 peaks after resize, got %d", len(w.Peaks))
+	}
+	if w.Config.Bars != 25 {
+		t.Errorf("Expected Config.Bars to follow the resize to 25, got %d", w.Config.Bars)
+	}
+	if config.Bars != 100 {
+		t.Errorf("Expected the shared config to keep its 100 bars, got %d", config.Bars)
+	}
+
+	data, err := w.GenerateJSON()
+	if err != nil {
+		t.Fatalf("GenerateJSON failed: %v", err)
+	}
+	var exported struct {
+		Bars   int       `json:"bars"`
+		Peaks  []float64 `json:"peaks"`
+		Config Config    `json:"config"`
+	}
+	if err := json.Unmarshal(data, &exported); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if exported.Bars != 25 || len(exported.Peaks) != 25 || exported.Config.Bars != 25 {
+		t.Errorf("Expected the JSON export to hold 25 bars, got bars %d, %d peaks and config bars %d",
+			exported.Bars, len(exported.Peaks), exported.Config.Bars)
+	}
+}
+
+func TestPeaksDB(t *testing.T) {
+	// Without samples, the bars of ModePeak are the sample peaks
+	w := &Waveform{Peaks: []float64{1, 0.5, 0.1, 0, 1e-9}, Config: &Config{Mode: ModePeak}}
+
+	want := []float64{0, -6.0206, -20, peakDBFloor, peakDBFloor}
+	got, err := w.PeaksDB()
+	if err != nil {
+		t.Fatalf("PeaksDB failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d levels, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 0.001 {
+			t.Errorf("Expected bar %d at %.4f dBFS, got %.4f", i, want[i], got[i])
+		}
+	}
+	if w.Peaks[1] != 0.5 {
+		t.Error("Expected PeaksDB to leave the peaks untouched")
+	}
+
+	if peak, err := w.PeakDBFS(); err != nil || peak != 0 {
+		t.Errorf("Expected the loudest bar at 0 dBFS, got %.4f (%v)", peak, err)
+	}
+	silent := &Waveform{Peaks: make([]float64, 4), Config: &Config{Mode: ModePeak}}
+	if peak, err := silent.PeakDBFS(); err != nil || peak != peakDBFloor {
+		t.Errorf("Expected silence at the %v dBFS floor, got %.4f (%v)", peakDBFloor, peak, err)
+	}
+
+	// Dynamic bars of a half-scale tone overshoot its level; the samples don't
+	samples := make([]int16, 44100)
+	for i := range samples {
+		samples[i] = int16(16384 * math.Sin(2*math.Pi*1000*float64(i)/44100))
+	}
+	config := DefaultConfig()
+	config.Mode = ModeDynamic
+	config.Bars = 10
+	w = NewFromSamples(samples, config)
+	if _, err := w.PeakDBFS(); err == nil {
+		t.Error("Expected an error for dynamic bars without samples")
+	}
+
+	config.RetainSamples = true
+	w = NewFromSamples(samples, config)
+	levels, err := w.PeaksDB()
+	if err != nil || len(levels) != 10 {
+		t.Fatalf("Expected 10 levels, got %d (%v)", len(levels), err)
+	}
+	for i, level := range levels {
+		if math.Abs(level+6.02) > 0.01 {
+			t.Errorf("Expected bar %d of the half-scale tone at -6.02 dBFS, got %.4f", i, level)
+		}
+	}
+}
+
+func TestSignature(t *testing.T) {
+	envelope := func(i int, seed float64) float64 {
+		return math.Abs(math.Sin(float64(i)*seed/1000)) + 0.5*math.Abs(math.Sin(float64(i)*seed/370))
+	}
+
+	original := make([]int16, 200000)
+	modified := make([]int16, len(original))
+	different := make([]int16, len(original))
+	for i := range original {
+		carrier := math.Sin(float64(i) * 0.3)
+		original[i] = int16(15000 * envelope(i, 1.3) * carrier)
+		modified[i] = int16(15000*envelope(i, 1.3)*carrier*0.9) + int16(i%7-3)
+		different[i] = int16(15000 * envelope(i, 2.9) * carrier)
+	}
+
+	config := DefaultConfig()
+	config.Bars = 400
+	config.Mode = ModeRMS
+
+	sigOriginal := NewFromSamples(original, config).Signature()
+	sigModified := NewFromSamples(modified, config).Signature()
+	sigDifferent := NewFromSamples(different, config).Signature()
+
+	if d := bits.OnesCount64(sigOriginal ^ sigModified); d > 6 {
+		t.Errorf("Expected similar signals to have close signatures, distance %d", d)
+	}
+	if d := bits.OnesCount64(sigOriginal ^ sigDifferent); d < 16 {
+		t.Errorf("Expected different signals to have distant signatures, distance %d", d)
+	}
+}
+
+func TestZeroRadiusUsesRects(t *testing.T) {
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = int16(i % 250)
+	}
+
+	config := DefaultConfig()
+	config.Bars = 20
+	config.CornerRadius = 0
+
+	svgData, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	svgStr := string(svgData)
+	if rects := strings.Count(svgStr, "<rect"); rects != 20 {
+		t.Errorf("Expected 20 rect elements, got %d", rects)
+	}
+	if containsString(svgStr, "<path") {
+		t.Error("Expected no path elements for zero-radius bars")
+	}
+	if !containsString(svgStr, `<rect x="100" y="1.6" width="23" height="76.8" fill="#3b82f6"/>`) {
+		t.Errorf("Unexpected rect geometry in %s", svgStr)
+	}
+}
+
+func TestProportionalRadius(t *testing.T) {
+	// Ten sections getting steadily louder
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = int16(i / 100 * 3000)
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.Width = 400
+	config.CornerRadius = 20
+	config.ProportionalRadius = true
+
+	svgData, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	// The first arc of each bar carries its corner radius
+	arcs := regexp.MustCompile(`<path d="M[\d.]+ [\d.]+A([\d.]+) `).FindAllStringSubmatch(string(svgData), -1)
+	if len(arcs) != 10 {
+		t.Fatalf("Expected 10 rounded bars, got %d", len(arcs))
+	}
+
+	var prev float64
+	for i, arc := range arcs {
+		radius, _ := strconv.ParseFloat(arc[1], 64)
+		if i > 0 && radius <= prev {
+			t.Errorf("Expected bar %d to be rounder than bar %d, got radius %g after %g", i, i-1, radius, prev)
+		}
+		prev = radius
+	}
+	if prev > config.CornerRadius {
+		t.Errorf("Expected radii up to CornerRadius %g, got %g", config.CornerRadius, prev)
+	}
+}
+
+func TestSilenceColor(t *testing.T) {
+	// Silence, then a tone
+	samples := make([]int16, 1000)
+	for i := 500; i < len(samples); i++ {
+		samples[i] = int16(20000 * math.Sin(float64(i)/3))
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.CornerRadius = 0
+	config.SilenceColor = "#E5E7EB"
+
+	svgData, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	fills := regexp.MustCompile(`<rect [^>]*fill="(#[0-9a-f]+)"`).FindAllStringSubmatch(string(svgData), -1)
+	if len(fills) != 10 {
+		t.Fatalf("Expected 10 bars, got %d", len(fills))
+	}
+	for i, fill := range fills {
+		want := "#3b82f6"
+		if i < 5 {
+			want = "#e5e7eb"
+		}
+		if fill[1] != want {
+			t.Errorf("Bar %d: expected fill %s, got %s", i, want, fill[1])
+		}
+	}
+}
+
+func TestGradientFill(t *testing.T) {
+	// Silence, then a tone
+	samples := make([]int16, 1000)
+	for i := 500; i < len(samples); i++ {
+		samples[i] = int16(20000 * math.Sin(float64(i)/3))
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.CornerRadius = 0
+	config.GradientStart = "#FF0000"
+	config.GradientEnd = "#0000FF"
+
+	svgData, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	svg := string(svgData)
+
+	// One gradient from top to bottom, shared by every bar
+	gradients := regexp.MustCompile(`<linearGradient id="(\w+)"[^>]* y1="0" x2="0" y2="80"><stop offset="0" stop-color="#f00"/><stop offset="1" stop-color="#00f"/>`).FindAllStringSubmatch(svg, -1)
+	if len(gradients) != 1 {
+		t.Fatalf("Expected a single top-to-bottom gradient, got %d in %s", len(gradients), svg)
+	}
+	if n := strings.Count(svg, `fill="url(#`+gradients[0][1]+`)"`); n != 10 {
+		t.Errorf("Expected all 10 bars filled with the gradient, got %d", n)
+	}
+	if strings.Contains(svg, "#3b82f6") {
+		t.Error("Expected BarColor to be ignored with a gradient")
+	}
+
+	// Silent bars keep their own color, and the gradient resumes after them
+	config.SilenceColor = "#E5E7EB"
+	svgData, err = NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	fills := regexp.MustCompile(`<rect [^>]*fill="([^"]+)"`).FindAllStringSubmatch(string(svgData), -1)
+	if len(fills) != 10 {
+		t.Fatalf("Expected 10 bars, got %d", len(fills))
+	}
+	for i, fill := range fills {
+		if silent := fill[1] == "#e5e7eb"; silent != (i < 5) {
+			t.Errorf("Bar %d: unexpected fill %s", i, fill[1])
+		}
+	}
+
+	// A lone GradientStart is not a gradient
+	config = DefaultConfig()
+	config.GradientStart = "#FF0000"
+	svgData, err = NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	if strings.Contains(string(svgData), "linearGradient") {
+		t.Error("Expected a solid fill with only GradientStart set")
+	}
+}
+
+func TestBarColorFunc(t *testing.T) {
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = int16(i / 100 * 3000)
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.Mode = ModePeak
+	config.CornerRadius = 0
+	config.MeterColors = true
+	var amplitudes []float64
+	config.BarColorFunc = func(index int, amplitude float64) string {
+		amplitudes = append(amplitudes, amplitude)
+		if index%2 == 0 {
+			return "#FF0000"
+		}
+		return "#0000FF"
+	}
+
+	svgData, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	fills := regexp.MustCompile(`<rect [^>]*fill="(#[0-9a-f]+)"`).FindAllStringSubmatch(string(svgData), -1)
+	if len(fills) != 10 {
+		t.Fatalf("Expected 10 bars, got %d", len(fills))
+	}
+	for i, fill := range fills {
+		want := "#f00"
+		if i%2 == 1 {
+			want = "#00f"
+		}
+		if fill[1] != want {
+			t.Errorf("Bar %d: expected fill %s, got %s", i, want, fill[1])
+		}
+	}
+
+	// Amplitudes are relative to the loudest bar
+	if len(amplitudes) != 10 || amplitudes[0] != 0 || amplitudes[9] != 1 {
+		t.Errorf("Expected amplitudes rising from 0 to 1, got %v", amplitudes)
+	}
+}
+
+func TestBackground(t *testing.T) {
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = int16(20000 * math.Sin(float64(i)/3))
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.CornerRadius = 0
+
+	// Transparent by default: nothing but the bars
+	svgData, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	if n := strings.Count(string(svgData), "<rect "); n != 10 {
+		t.Errorf("Expected only the 10 bars without a background, got %d rects", n)
+	}
+
+	config.BackgroundColor = "#111827"
+	svgData, err = NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	first := regexp.MustCompile(`^<svg[^>]*>(<[^>]*>)`).FindStringSubmatch(string(svgData))
+	if first == nil || first[1] != `<rect x="0" y="0" width="500" height="80" fill="#111827"/>` {
+		t.Errorf("Expected a full-size background beneath the bars, got %v", first)
+	}
+	if !strings.Contains(string(svgData), `fill="#3b82f6"`) {
+		t.Error("Expected the bars to keep BarColor over the background")
+	}
+
+	// A rounded card, with the radius clamped to half the height
+	config.BackgroundRadius = 1000
+	svgData, err = NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	if !strings.Contains(string(svgData), `A40 40 0 `) {
+		t.Errorf("Expected background corners rounded by 40, got %s", svgData)
+	}
+
+	// Silence keeps the bars to stubs at the midline, clear of the corners
+	pngData, err := NewFromSamples(make([]int16, 1000), config).GeneratePNG()
+	if err != nil {
+		t.Fatalf("GeneratePNG failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		t.Fatalf("Decoding PNG failed: %v", err)
+	}
+	if _, _, _, a := img.At(1, 1).RGBA(); a != 0 {
+		t.Errorf("Expected the rounded corner to stay transparent, got alpha %d", a)
+	}
+	if r, g, b, _ := img.At(250, 5).RGBA(); r>>8 != 0x11 || g>>8 != 0x18 || b>>8 != 0x27 {
+		t.Errorf("Expected the background color above the bars, got %d %d %d", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestNormalizeFixed(t *testing.T) {
+	tone := func(amplitude float64) []int16 {
+		samples := make([]int16, 1000)
+		for i := range samples {
+			samples[i] = int16(amplitude * 32767 * math.Sin(float64(i)/3))
+		}
+		return samples
+	}
+
+	heights := func(samples []int16, config *Config) []float64 {
+		svgData, err := NewFromSamples(samples, config).GenerateSVG()
+		if err != nil {
+			t.Fatalf("GenerateSVG failed: %v", err)
+		}
+		var heights []float64
+		for _, m := range regexp.MustCompile(`<rect [^>]*height="([\d.]+)"`).FindAllStringSubmatch(string(svgData), -1) {
+			h, _ := strconv.ParseFloat(m[1], 64)
+			heights = append(heights, h)
+		}
+		return heights
+	}
+
+	config := DefaultConfig()
+	config.Bars = 5
+	config.Mode = ModePeak
+	config.CornerRadius = 0
+
+	// Per file, a quiet and a loud clip both fill the height
+	if quiet, loud := heights(tone(0.25), config), heights(tone(1), config); quiet[0] != loud[0] {
+		t.Errorf("Expected per-file normalization to hide the loudness difference, got %v and %v", quiet, loud)
+	}
+
+	// Against full scale the quiet clip stays a quarter of the height
+	config.NormalizeMode = NormalizeFixed
+	full := float64(config.Height) * 0.96
+	for _, h := range heights(tone(0.25), config) {
+		if math.Abs(h-full/4) > 0.5 {
+			t.Errorf("Expected a quarter-scale tone to reach %.1f, got %.1f", full/4, h)
+		}
+	}
+
+	// Peaks above MaxAmplitude are clamped to the height
+	config.MaxAmplitude = 0.5
+	for _, h := range heights(tone(1), config) {
+		if h != full {
+			t.Errorf("Expected an overshooting bar clamped to %.1f, got %.1f", full, h)
+		}
+	}
+}
+
+func TestFixedScaleMax(t *testing.T) {
+	heights := func(peaks []float64, config *Config) []float64 {
+		w := &Waveform{Peaks: peaks, Config: config}
+		svgData, err := w.GenerateSVG()
+		if err != nil {
+			t.Fatalf("GenerateSVG failed: %v", err)
+		}
+		var heights []float64
+		for _, m := range regexp.MustCompile(`<rect [^>]*height="([\d.]+)"`).FindAllStringSubmatch(string(svgData), -1) {
+			h, _ := strconv.ParseFloat(m[1], 64)
+			heights = append(heights, h)
+		}
+		return heights
+	}
+
+	// Two tiles of one file, the second holding its loudest moment
+	quiet := []float64{0.1, 0.2, 0.15, 0.05}
+	loud := []float64{0.3, 0.6, 0.4, 0.2}
+
+	config := DefaultConfig()
+	config.Bars = 4
+	config.CornerRadius = 0
+	config.FixedScaleMax = max(peakMax(quiet), peakMax(loud))
+
+	full := float64(config.Height) * 0.96
+	for name, peaks := range map[string][]float64{"quiet": quiet, "loud": loud} {
+		got := heights(peaks, config)
+		if len(got) != len(peaks) {
+			t.Fatalf("Expected %d bars in the %s tile, got %d", len(peaks), name, len(got))
+		}
+		for i, h := range got {
+			if want := full * peaks[i] / config.FixedScaleMax; math.Abs(h-want) > 0.01 {
+				t.Errorf("%s tile bar %d: expected height %.2f on the shared scale, got %.2f", name, i, want, h)
+			}
+		}
+	}
+
+	// The same peaks in both tiles come out the same height
+	if a, b := heights([]float64{0.2}, config), heights([]float64{0.2}, config); a[0] != b[0] || a[0] != heights(quiet, config)[1] {
+		t.Errorf("Expected equal peaks drawn equally across renders, got %v, %v and %v", a, b, heights(quiet, config))
+	}
+
+	// It takes precedence over MaxAmplitude
+	config.NormalizeMode = NormalizeFixed
+	config.MaxAmplitude = 1
+	if got, want := heights(loud, config)[1], full; got != want {
+		t.Errorf("Expected the loudest bar to fill the height, got %.2f of %.2f", got, want)
+	}
+}
+
+func TestAmplitudeScaleLog(t *testing.T) {
+	// Full scale, then -6 dB, -40 dB and -80 dB
+	levels := []float64{1, 0.5, 0.01, 0.0001}
+	samples := make([]int16, 400)
+	for i := range samples {
+		samples[i] = int16(32767 * levels[i/100])
+	}
+
+	config := DefaultConfig()
+	config.Bars = 4
+	config.Mode = ModePeak
+	config.AmplitudeScale = ScaleLog
+	w := NewFromSamples(samples, config)
+
+	maxHeight := float64(config.Height) * 0.48
+	for i, want := range []float64{1, 0.9, 1.0 / 3, 0} {
+		got := barHalfHeight(w.Peaks[i], peakMax(w.Peaks), config)
+		if want = math.Max(want*maxHeight, 3); math.Abs(got-want) > 0.05 {
+			t.Errorf("Bar %d at %.0f dB: expected half height %.2f, got %.2f", i, 20*math.Log10(levels[i]), want, got)
+		}
+	}
+
+	// A higher floor drops more of the quiet detail
+	config.DBFloor = -30
+	if got := barHalfHeight(w.Peaks[2], peakMax(w.Peaks), config); got != 3 {
+		t.Errorf("Expected -40 dB below a -30 dB floor to get the minimum height, got %.2f", got)
+	}
+}
+
+func TestTooltips(t *testing.T) {
+	// One second of 8kHz audio: a half-scale tone, then silence
+	pcm := make([]byte, 16000)
+	for i := 0; i < 4000; i++ {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(16384*math.Sin(float64(i)/3))))
+	}
+
+	config := DefaultConfig()
+	config.Bars = 4
+	config.Mode = ModePeak
+	config.Tooltips = true
+	config.BackgroundColor = "#000000"
+	w, err := NewFromPCMReader(bytes.NewReader(pcm), 8000, 1, config)
+	if err != nil {
+		t.Fatalf("NewFromPCMReader failed: %v", err)
+	}
+
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	titles := regexp.MustCompile(`<path [^>]*><title>([^<]*)</title></path>`).FindAllStringSubmatch(string(svgData), -1)
+	want := []string{"0s–250ms: -6.0 dBFS", "250ms–500ms: -6.0 dBFS", "500ms–750ms: -inf dBFS", "750ms–1s: -inf dBFS"}
+	if len(titles) != len(want) {
+		t.Fatalf("Expected a title in each of the %d bars, got %d in %s", len(want), len(titles), svgData)
+	}
+	for i, title := range titles {
+		if title[1] != want[i] {
+			t.Errorf("Bar %d: expected title %q, got %q", i, want[i], title[1])
+		}
+	}
+	if strings.Count(string(svgData), "<title>") != len(want) {
+		t.Error("Expected the background to get no title")
+	}
+
+	// RMS bars aren't sample peaks, so without the samples there is no level to give
+	config.Mode = ModeRMS
+	w, err = NewFromPCMReader(bytes.NewReader(pcm), 8000, 1, config)
+	if err != nil {
+		t.Fatalf("NewFromPCMReader failed: %v", err)
+	}
+	svgData, err = w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	titles = regexp.MustCompile(`<title>([^<]*)</title>`).FindAllStringSubmatch(string(svgData), -1)
+	if len(titles) != 4 || titles[0][1] != "0s–250ms" {
+		t.Errorf("Expected time ranges alone for RMS bars, got %v", titles)
+	}
+
+	// Vertical bars are laid out along the height, so the last log-time bar is
+	// drawn even though it would be too thin across the width
+	config.Mode = ModePeak
+	config.Orientation = OrientationVertical
+	config.Width, config.Height = 40, 400
+	config.TimeAxis = TimeAxisLog
+	config.BarSpacing = 5
+	config.BackgroundColor = ""
+	w, err = NewFromPCMReader(bytes.NewReader(pcm), 8000, 1, config)
+	if err != nil {
+		t.Fatalf("NewFromPCMReader failed: %v", err)
+	}
+	svgData, err = w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	bars := regexp.MustCompile(`<path [^>]*/?>`).FindAllString(string(svgData), -1)
+	titles = regexp.MustCompile(`<path [^>]*><title>([^<]*)</title></path>`).FindAllStringSubmatch(string(svgData), -1)
+	if len(bars) != 4 || len(titles) != len(bars) {
+		t.Fatalf("Expected a title in each of the drawn bars, got %d titles for %d bars", len(titles), len(bars))
+	}
+	if last := titles[len(titles)-1][1]; last != want[3] {
+		t.Errorf("Expected the last bar to be titled %q, got %q", want[3], last)
+	}
+}
+
+func TestEnvelopeStyles(t *testing.T) {
+	// A zigzag, so no three bar tips line up
+	levels := []int16{1000, 9000, 4000, 20000, 2000, 15000, 7000, 30000, 5000, 12000}
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = levels[i/100]
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.Mode = ModePeak
+	config.Style = StyleFilled
+	w := NewFromSamples(samples, config)
+
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	paths := regexp.MustCompile(`<path d="([^"]*)"`).FindAllStringSubmatch(string(svgData), -1)
+	if len(paths) != 1 || !strings.HasSuffix(paths[0][1], "z") {
+		t.Fatalf("Expected a single closed shape, got %v", paths)
+	}
+	// The outline passes through the tip of each bar, mirrored below the midline.
+	// The last tip runs level into the edge, so its coordinates are written apart.
+	for _, point := range w.Points()[:config.Bars-1] {
+		mirrored := float64(config.Height) - point[1]
+		for _, y := range []float64{point[1], mirrored} {
+			coord := svgNumber(point[0]) + " " + svgNumber(y)
+			if !strings.Contains(paths[0][1], coord) {
+				t.Errorf("Expected the outline to pass through %s, got %s", coord, paths[0][1])
+			}
+		}
+	}
+
+	config.Style = StyleLine
+	config.LineWidth = 3
+	svgData, err = NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	svg := string(svgData)
+	if strings.Count(svg, "<path ") != 1 || strings.HasSuffix(regexp.MustCompile(`d="([^"]*)"`).FindStringSubmatch(svg)[1], "z") {
+		t.Errorf("Expected a single open line, got %s", svg)
+	}
+	if !strings.Contains(svg, "fill:none;stroke:#3b82f6;stroke-width:3") {
+		t.Errorf("Expected an unfilled line stroked 3px wide in BarColor, got %s", svg)
+	}
+}
+
+func TestChannelPeaks(t *testing.T) {
+	// A loud left channel against a quiet right one
+	pcm := make([]byte, 4000)
+	for i := 0; i < len(pcm)/4; i++ {
+		v := math.Sin(float64(i) / 3)
+		binary.LittleEndian.PutUint16(pcm[4*i:], uint16(int16(20000*v)))
+		binary.LittleEndian.PutUint16(pcm[4*i+2:], uint16(int16(5000*v)))
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.Mode = ModePeak
+	config.StereoSplit = true
+	w, err := NewFromPCMReader(bytes.NewReader(pcm), 8000, 2, config)
+	if err != nil {
+		t.Fatalf("NewFromPCMReader failed: %v", err)
+	}
+
+	channels := w.ChannelPeaks()
+	if len(channels) != 2 || len(channels[0]) != 10 || len(channels[1]) != 10 {
+		t.Fatalf("Expected two channels of 10 peaks, got %v", channels)
+	}
+	if l, r := peakMax(channels[0]), peakMax(channels[1]); math.Abs(l/r-4) > 0.01 {
+		t.Errorf("Expected the left channel 4 times as loud as the right, got %.3f and %.3f", l, r)
+	}
+
+	// Mono audio has a single waveform to draw
+	mono, err := NewFromPCMReader(bytes.NewReader(pcm), 8000, 1, config)
+	if err != nil {
+		t.Fatalf("NewFromPCMReader failed: %v", err)
+	}
+	if mono.ChannelPeaks() != nil {
+		t.Errorf("Expected no channel peaks for mono audio, got %v", mono.ChannelPeaks())
+	}
+}
+
+func TestGamma(t *testing.T) {
+	config := DefaultConfig()
+	maxHeight := float64(config.Height) * 0.48
+
+	height := func(peak, gamma float64) float64 {
+		config.Gamma = gamma
+		return barHalfHeight(peak, 1, config)
+	}
+
+	if got := height(0.25, 0.5); math.Abs(got-maxHeight*0.5) > 1e-9 {
+		t.Errorf("Expected gamma 0.5 to lift a quarter-height bar to half height, got %f of %f", got, maxHeight)
+	}
+	if got := height(0.5, 2); math.Abs(got-maxHeight*0.25) > 1e-9 {
+		t.Errorf("Expected gamma 2 to lower a half-height bar to a quarter, got %f of %f", got, maxHeight)
+	}
+	for _, gamma := range []float64{0.5, 2} {
+		if got := height(1, gamma); got != maxHeight {
+			t.Errorf("Expected the loudest bar to keep its height with gamma %v, got %f", gamma, got)
+		}
+	}
+	if height(0.3, 0) != height(0.3, 1) {
+		t.Error("Expected a zero Gamma to mean 1")
+	}
+
+	// The dB axis follows the bent heights
+	config.Gamma = 0.5
+	ys := amplitudeTickYs(-6, config)
+	want := float64(config.Height)/2 - maxHeight*math.Sqrt(math.Pow(10, -6.0/20))
+	if len(ys) != 2 || math.Abs(ys[0]-want) > 1e-9 {
+		t.Errorf("Expected the -6 dB tick at %f with gamma 0.5, got %v", want, ys)
+	}
+}
+
+func TestMinBarHeight(t *testing.T) {
+	config := DefaultConfig()
+	if h := barHalfHeight(0, 1, config); h != 3 {
+		t.Errorf("Expected silent bars to keep the default 3px minimum, got %f", h)
+	}
+
+	// Configs that leave it out, such as &Config literals and older JSON, keep the default
+	config.MinBarHeight = 0
+	if h := barHalfHeight(0, 1, config); h != 3 {
+		t.Errorf("Expected an unset minimum to mean the default 3px, got %f", h)
+	}
+
+	config.MinBarHeight = -1
+	if h := barHalfHeight(0, 1, config); h != 0 {
+		t.Errorf("Expected silent bars to vanish without a minimum, got %f", h)
+	}
+	if h := barHalfHeight(0.01, 1, config); h <= 0 || h >= 1 {
+		t.Errorf("Expected a quiet bar to become a hairline without a minimum, got %f", h)
+	}
+
+	config.MinBarHeight = 8
+	if h := barHalfHeight(0.01, 1, config); h != 8 {
+		t.Errorf("Expected quiet bars to reach the 8px minimum, got %f", h)
+	}
+}
+
+func TestBarWidthRatio(t *testing.T) {
+	config := DefaultConfig()
+	config.Bars = 10
+	config.CornerRadius = 0
+
+	widths := func() []string {
+		t.Helper()
+		w := &Waveform{Peaks: []float64{0.2, 0.5, 0.8, 0.4, 0.6, 0.3, 0.9, 0.1, 0.7, 1}, Config: config}
+		svgData, err := w.GenerateSVG()
+		if err != nil {
+			t.Fatalf("GenerateSVG failed: %v", err)
+		}
+		var out []string
+		for _, m := range regexp.MustCompile(`<rect [^>]*width="([\d.]+)"`).FindAllStringSubmatch(string(svgData), -1) {
+			out = append(out, m[1])
+		}
+		return out
+	}
+
+	// Slots are 50px wide; a ratio of 0.3 leaves 15px bars whatever the spacing
+	for _, spacing := range []int{2, 20} {
+		config.BarSpacing = spacing
+		config.BarWidthRatio = 0.3
+		got := widths()
+		if len(got) != 10 {
+			t.Fatalf("Expected 10 bars, got %d", len(got))
+		}
+		for i, width := range got {
+			if width != "15" {
+				t.Errorf("Expected bar %d to be 15px wide with spacing %d, got %s", i, spacing, width)
+			}
+		}
+	}
+
+	config.BarWidthRatio = 2
+	if got := widths(); got[0] != "50" {
+		t.Errorf("Expected a ratio above 1 to fill the slot, got %s", got[0])
+	}
+
+	config.BarWidthRatio = 0
+	config.BarSpacing = 10
+	if got := widths(); got[0] != "40" {
+		t.Errorf("Expected BarSpacing to apply without a ratio, got %s", got[0])
+	}
+}
+
+func TestAmplitudeAxis(t *testing.T) {
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = int16(20000 * math.Sin(float64(i)/5))
+	}
+
+	config := DefaultConfig()
+	config.Height = 100
+	config.Bars = 10
+	config.ShowAmplitudeAxis = true
+	config.AmplitudeAxisTicks = []float64{0, -6, -60}
+	w := NewFromSamples(samples, config)
+
+	data, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	svg := string(data)
+
+	if !strings.Contains(svg, fmt.Sprintf(`viewBox="0 0 %d 100"`, config.Width+amplitudeAxisWidth)) {
+		t.Errorf("Expected the SVG widened for the axis, got root %q", svg[:strings.IndexByte(svg, '>')+1])
+	}
+	if !strings.Contains(svg, fmt.Sprintf(`<g transform="translate(%d 0)">`, amplitudeAxisWidth)) {
+		t.Error("Expected the waveform shifted right of the axis")
+	}
+
+	// -60 dB is below the minimum bar height; the others get a tick on either side of the midline
+	ticks := regexp.MustCompile(`<line x1="31" y1="([\d.]+)"`).FindAllStringSubmatch(svg, -1)
+	wantYs := []float64{2, 98, 50 - 48*math.Pow(10, -0.3), 50 + 48*math.Pow(10, -0.3)}
+	if len(ticks) != len(wantYs) {
+		t.Fatalf("Expected %d ticks, got %d", len(wantYs), len(ticks))
+	}
+	for i, tick := range ticks {
+		if y, _ := strconv.ParseFloat(tick[1], 64); math.Abs(y-wantYs[i]) > 0.01 {
+			t.Errorf("Tick %d: expected y %.2f, got %s", i, wantYs[i], tick[1])
+		}
+	}
+	labels := regexp.MustCompile(`<text x="29" y="[\d.]+">([^<]*)</text>`).FindAllStringSubmatch(svg, -1)
+	var got []string
+	for _, label := range labels {
+		got = append(got, label[1])
+	}
+	if want := []string{"0 dB", "0 dB", "-6 dB", "-6 dB"}; !slices.Equal(got, want) {
+		t.Errorf("Expected labels %v, got %v", want, got)
+	}
+
+	// Bottom-aligned bars grow from the bottom edge, so each level has a single tick
+	config.Alignment = AlignBottom
+	data, err = w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	ticks = regexp.MustCompile(`<line x1="31" y1="([\d.]+)"`).FindAllStringSubmatch(string(data), -1)
+	if len(ticks) != 2 || ticks[0][1] != "4" {
+		t.Errorf("Expected 2 ticks starting at y 4 for bottom-aligned bars, got %v", ticks)
+	}
+
+	config.ShowAmplitudeAxis = false
+	if data, _ := w.GenerateSVG(); strings.Contains(string(data), "waveform-axis") {
+		t.Error("Expected no axis without ShowAmplitudeAxis")
+	}
+}
+
+func TestMaxElements(t *testing.T) {
+	// A swell and fade with a single loud click in the fade
+	samples := make([]int16, 100000)
+	for i := range samples {
+		envelope := 1 - math.Abs(float64(i)/50000-1)
+		samples[i] = int16(20000 * envelope * math.Sin(float64(i)/3))
+	}
+	samples[80003] = 32000
+
+	config := DefaultConfig()
+	config.Width = 2000
+	config.Bars = 10000
+	config.BarSpacing = 0
+	config.CornerRadius = 0
+	config.Mode = ModePeak
+	config.MaxElements = 1000
+
+	w := NewFromSamples(samples, config)
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	svg := string(svgData)
+	if n := strings.Count(svg, "<rect ") + strings.Count(svg, "<path "); n > config.MaxElements {
+		t.Fatalf("Expected at most %d elements, got %d", config.MaxElements, n)
+	}
+	if len(w.Peaks) != 10000 {
+		t.Errorf("Expected the waveform to keep its 10000 peaks, got %d", len(w.Peaks))
+	}
+
+	// Each drawn bar, in a 2px slot, is as tall as the loudest of the ten bars it pools
+	maxPeak := peakMax(w.Peaks)
+	rects := regexp.MustCompile(`<rect x="([\d.]+)" y="[\d.]+" width="[\d.]+" height="([\d.]+)"`).FindAllStringSubmatch(svg, -1)
+	if len(rects) < 900 {
+		t.Fatalf("Expected most bars as rects, got %d", len(rects))
+	}
+	for _, rect := range rects {
+		x, _ := strconv.ParseFloat(rect[1], 64)
+		height, _ := strconv.ParseFloat(rect[2], 64)
+		i := int(x / 2)
+		want := 2 * math.Max(slices.Max(w.Peaks[i*10:(i+1)*10])/maxPeak*float64(config.Height)*0.48, 3)
+		if math.Abs(height-want) > 0.01 {
+			t.Errorf("Bar %d: expected the pooled height %.2f, got %.2f", i, want, height)
+		}
+		// The click is not averaged away
+		if i == 800 && height != 76.8 {
+			t.Errorf("Expected the click to fill the height, got %.2f", height)
+		}
+	}
+}
+
+func TestButterfly(t *testing.T) {
+	// The first half swells while the second half fades, each at its own pace
+	samples := make([]int16, 1000)
+	for i := range samples {
+		level := float64(i%500) / 500
+		if i >= 500 {
+			level = 0.5 - level/2
+		}
+		samples[i] = int16(30000 * level * math.Sin(float64(i)))
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.Mode = ModePeak
+	config.CornerRadius = 0
+	config.Butterfly = true
+
+	w := NewFromSamples(samples, config)
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	rects := regexp.MustCompile(`<rect x="[\d.]+" y="([\d.]+)" width="[\d.]+" height="([\d.]+)"`).FindAllStringSubmatch(string(svgData), -1)
+	if len(rects) != 5 {
+		t.Fatalf("Expected a bar per pair of halves, got %d", len(rects))
+	}
+
+	mid := float64(config.Height) / 2
+	scale := float64(config.Height) * 0.48 / peakMax(w.Peaks)
+	for i, rect := range rects {
+		y, _ := strconv.ParseFloat(rect[1], 64)
+		height, _ := strconv.ParseFloat(rect[2], 64)
+		up, down := mid-y, y+height-mid
+
+		if want := math.Max(w.Peaks[i]*scale, 1.5); math.Abs(up-want) > 0.01 {
+			t.Errorf("Bar %d: expected the first half to rise %.2f, got %.2f", i, want, up)
+		}
+		if want := math.Max(w.Peaks[i+5]*scale, 1.5); math.Abs(down-want) > 0.01 {
+			t.Errorf("Bar %d: expected the second half to hang %.2f, got %.2f", i, want, down)
+		}
+	}
+}
+
+func TestAlignment(t *testing.T) {
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = int16(i / 100 * 3000)
+	}
+
+	rectPattern := regexp.MustCompile(`<rect x="[\d.]+" y="([\d.]+)" width="[\d.]+" height="([\d.]+)"`)
+	for _, alignment := range []Alignment{AlignBottom, AlignTop} {
+		config := DefaultConfig()
+		config.Bars = 10
+		config.Mode = ModePeak
+		config.CornerRadius = 0
+		config.Alignment = alignment
+
+		w := NewFromSamples(samples, config)
+		svgData, err := w.GenerateSVG()
+		if err != nil {
+			t.Fatalf("%s: GenerateSVG failed: %v", alignment, err)
+		}
+
+		rects := rectPattern.FindAllStringSubmatch(string(svgData), -1)
+		if len(rects) != config.Bars {
+			t.Fatalf("%s: expected %d bars, got %d", alignment, config.Bars, len(rects))
+		}
+
+		points := w.Points()
+		height := float64(config.Height)
+		for i, rect := range rects {
+			y, _ := strconv.ParseFloat(rect[1], 64)
+			length, _ := strconv.ParseFloat(rect[2], 64)
+
+			// Bars keep their centered length, silent ones included
+			want := 2 * math.Max(w.Peaks[i]/peakMax(w.Peaks)*height*0.48, 3)
+			if math.Abs(length-want) > 0.01 {
+				t.Errorf("%s: bar %d expected length %.2f, got %.2f", alignment, i, want, length)
+			}
+
+			edge, tip := y+length, y
+			if alignment == AlignTop {
+				edge, tip = y, y+length
+			}
+			if alignment == AlignBottom && math.Abs(edge-height) > 0.01 || alignment == AlignTop && edge > 0.01 {
+				t.Errorf("%s: bar %d is not anchored to its edge (y %.2f, height %.2f)", alignment, i, y, length)
+			}
+			if math.Abs(points[i][1]-tip) > 0.01 {
+				t.Errorf("%s: point %d expected at the tip %.2f, got %.2f", alignment, i, tip, points[i][1])
+			}
+		}
+	}
+
+	// Rounded bottom-aligned bars stay square where they meet the bottom edge
+	config := DefaultConfig()
+	config.Bars = 10
+	config.Alignment = AlignBottom
+	path := roundedEndBar(10, 40, 4, true)
+	bounds := path.Bounds()
+	if bounds.X0 != 0 || bounds.Y0 != 0 || bounds.X1 != 10 || bounds.Y1 != 40 {
+		t.Errorf("Expected a 10x40 bar, got bounds %v", bounds)
+	}
+	if !strings.HasPrefix(path.String(), "M0 0L10 0") {
+		t.Errorf("Expected a square base, got path %s", path.String())
+	}
+	if _, err := NewFromSamples(samples, config).GenerateSVG(); err != nil {
+		t.Fatalf("GenerateSVG with rounded bottom-aligned bars failed: %v", err)
+	}
+}
+
+func TestLargeCornerRadius(t *testing.T) {
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = int16(i % 250)
+	}
+
+	// Bars are 3px wide after spacing, so any radius beyond 1.5 must be clamped
+	config := DefaultConfig()
+	config.Bars = 100
+	config.CornerRadius = 1e9
+
+	huge, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	config.CornerRadius = 1.5
+	clamped, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	if string(huge) != string(clamped) {
+		t.Error("Expected an oversized radius to render the same as the clamped radius")
+	}
+	if arcs := strings.Count(string(huge), "A1.5 1.5"); arcs != 4*config.Bars {
+		t.Errorf("Expected %d clamped corner arcs, got %d", 4*config.Bars, arcs)
+	}
+	if containsString(string(huge), "NaN") || containsString(string(huge), "Inf") {
+		t.Error("SVG contains non-finite coordinates")
+	}
+
+	if r := clampRadius(-10, 4, 60); r != -2 {
+		t.Errorf("Expected negative radius to keep its sign when clamped, got %v", r)
+	}
+}
+
+func TestRetainSamples(t *testing.T) {
+	samples := make([]int16, 2000)
+	for i := range samples {
+		samples[i] = int16((i * 53) % 4000)
+	}
+
+	config := DefaultConfig()
+	config.Bars = 20
+	w := NewFromSamples(samples, config)
+	if w.samples != nil {
+		t.Error("Expected the samples to be dropped by default")
+	}
+	if w.SampleCount() != len(samples) {
+		t.Errorf("Expected the sample count %d without the samples, got %d", len(samples), w.SampleCount())
+	}
+	if _, err := w.GenerateDat(); err == nil || !strings.Contains(err.Error(), "RetainSamples") {
+		t.Errorf("Expected GenerateDat to point at RetainSamples, got %v", err)
+	}
+
+	for name, configure := range map[string]func(*Config){
+		"RetainSamples": func(c *Config) { c.RetainSamples = true },
+		"ClipOverlay":   func(c *Config) { c.ClipOverlay = true },
+		"LoudnessCurve": func(c *Config) { c.LoudnessCurve = true },
+	} {
+		retaining := *config
+		configure(&retaining)
+		if w := NewFromSamples(samples, &retaining); len(w.samples) != len(samples) {
+			t.Errorf("%s: expected the samples to be kept, got %d", name, len(w.samples))
+		}
+	}
+
+	// Switching to a config that wants them keeps the samples passed along
+	retaining := *config
+	retaining.RetainSamples = true
+	w.UpdateConfig(&retaining, samples)
+	if _, err := w.GenerateDat(); err != nil {
+		t.Errorf("Expected GenerateDat to work once the samples are kept, got %v", err)
+	}
+}
+
+func TestGenerateComparison(t *testing.T) {
+	samples := make([]int16, 2000)
+	for i := range samples {
+		samples[i] = int16((i * 53) % 4000)
+	}
+
+	config := DefaultConfig()
+	config.Bars = 20
+	config.PerBarFade = true
+	config.RetainSamples = true
+
+	w := NewFromSamples(samples, config)
+	svgData, err := w.GenerateComparison([]CalculationMode{ModeRMS, ModePeak}, nil)
+	if err != nil {
+		t.Fatalf("GenerateComparison failed: %v", err)
+	}
+
+	svgStr := string(svgData)
+	for _, label := range []string{">rms</text>", ">peak</text>"} {
+		if !containsString(svgStr, label) {
+			t.Errorf("Expected comparison to contain row label %s", label)
+		}
+	}
+	if rows := strings.Count(svgStr, "<g transform="); rows != 2 {
+		t.Errorf("Expected 2 rows, got %d", rows)
+	}
+	if bars := strings.Count(svgStr, "<path"); bars != 2*config.Bars {
+		t.Errorf("Expected %d bars across both rows, got %d", 2*config.Bars, bars)
+	}
+	if !containsString(svgStr, `viewBox="0 0 500 192"`) {
+		t.Error("Expected the SVG to be tall enough for both labeled rows")
+	}
+	if !containsString(svgStr, `id="r1p1"`) || !containsString(svgStr, `url(#r1p1)`) {
+		t.Error("Expected gradient ids to be prefixed per row")
+	}
+	if w.Config.Mode != ModeDynamic {
+		t.Error("Expected the waveform's own config to be left untouched")
+	}
+
+	if _, err := (&Waveform{Config: config}).GenerateComparison([]CalculationMode{ModeRMS}, nil); err == nil {
+		t.Error("Expected an error without retained samples")
+	}
+}
+
+func TestSkipThreshold(t *testing.T) {
+	w := &Waveform{Peaks: []float64{1.0, 0.01, 0.5, 0.02, 0.8}, Config: DefaultConfig()}
+	w.Config.CornerRadius = 0
+
+	full, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	w.Config.SkipThreshold = 0.1
+	sparse, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	if bars := strings.Count(string(sparse), "<rect"); bars != 3 {
+		t.Errorf("Expected 3 bars above the threshold, got %d", bars)
+	}
+	if len(sparse) >= len(full) {
+		t.Errorf("Expected sparse SVG to be smaller, got %d >= %d bytes", len(sparse), len(full))
+	}
+
+	// Bars are 100px apart; the quiet bars at x=100 and x=300 must be gone
+	for _, x := range []string{`x="0"`, `x="200"`, `x="400"`} {
+		if !containsString(string(sparse), x) {
+			t.Errorf("Expected bar at %s to remain", x)
+		}
+	}
+	for _, x := range []string{`x="100"`, `x="300"`} {
+		if containsString(string(sparse), x) {
+			t.Errorf("Expected sub-threshold bar at %s to be omitted", x)
+		}
+	}
+}
+
+func TestTrimSilenceKeepsFadeIn(t *testing.T) {
+	const rate = 8000
+	info := streamInfo{sampleRate: rate, channels: 1}
+
+	// 2s silence, 2s linear fade-in, 2s full level, 2s silence
+	samples := make([]int16, 8*rate)
+	for i := 2 * rate; i < 6*rate; i++ {
+		gain := math.Min(float64(i-2*rate)/float64(2*rate), 1)
+		samples[i] = int16(gain * 30000 * math.Sin(2*math.Pi*440*float64(i)/rate))
+	}
+
+	// The result is a subslice, so the capacity difference is the number of samples cut from the start
+	trimmed := trimSilence(samples, info)
+	leading := cap(samples) - cap(trimmed)
+
+	if leading > 2*rate {
+		t.Errorf("Trim cut into the fade-in: removed %d leading samples, fade starts at %d", leading, 2*rate)
+	}
+	if leading < 2*rate-rate/2 {
+		t.Errorf("Expected most of the leading silence to be trimmed, removed only %d samples", leading)
+	}
+	if end := leading + len(trimmed); end < 6*rate || end > 6*rate+rate/2 {
+		t.Errorf("Expected trailing silence to be trimmed near %d, ended at %d", 6*rate, end)
+	}
+
+	// A brief quiet moment at the start is not sustained silence
+	short := append(make([]int16, rate/2), samples[2*rate:6*rate]...)
+	if got := trimSilence(short, info); len(got) != len(short) {
+		t.Errorf("Expected a short leading pause to be kept, got %d of %d samples", len(got), len(short))
+	}
+}
+
+func TestTrimmedOffsets(t *testing.T) {
+	const rate = 8000
+
+	// 2s silence, 3s tone, 1.5s silence of mono PCM
+	samples := make([]int16, 13*rate/2)
+	for i := 2 * rate; i < 5*rate; i++ {
+		samples[i] = int16(20000 * math.Sin(2*math.Pi*440*float64(i)/rate))
+	}
+	pcm := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(s))
+	}
+
+	config := DefaultConfig()
+	config.TrimSilence = true
+	w, err := NewFromPCMReader(bytes.NewReader(pcm), rate, 1, config)
+	if err != nil {
+		t.Fatalf("NewFromPCMReader failed: %v", err)
+	}
+
+	// Trimming works in 100ms hops and keeps whole 400ms blocks around the tone
+	start, end := w.TrimmedStart(), w.TrimmedEnd()
+	if start < 1500*time.Millisecond || start > 2*time.Second {
+		t.Errorf("Expected about 2s trimmed from the start, got %v", start)
+	}
+	if end < time.Second || end > 1500*time.Millisecond {
+		t.Errorf("Expected about 1.5s trimmed from the end, got %v", end)
+	}
+	if total := start + w.Duration() + end; total != 6500*time.Millisecond {
+		t.Errorf("Expected the offsets and duration to add up to 6.5s, got %v", total)
+	}
+
+	// Entirely silent audio is drawn flat rather than trimmed away
+	silent, err := NewFromPCMReader(bytes.NewReader(make([]byte, len(pcm))), rate, 1, config)
+	if err != nil {
+		t.Fatalf("NewFromPCMReader failed: %v", err)
+	}
+	if silent.TrimmedStart() != 0 || silent.TrimmedEnd() != 0 || len(silent.Peaks) != config.Bars {
+		t.Errorf("Expected silent audio kept whole, got offsets %v and %v and %d bars",
+			silent.TrimmedStart(), silent.TrimmedEnd(), len(silent.Peaks))
+	}
+	if _, err := silent.GenerateSVG(); err != nil {
+		t.Errorf("GenerateSVG of silent audio failed: %v", err)
+	}
+
+	untrimmed, err := NewFromPCMReader(bytes.NewReader(pcm), rate, 1, DefaultConfig())
+	if err != nil {
+		t.Fatalf("NewFromPCMReader failed: %v", err)
+	}
+	if untrimmed.TrimmedStart() != 0 || untrimmed.TrimmedEnd() != 0 {
+		t.Errorf("Expected no offsets without TrimSilence, got %v and %v", untrimmed.TrimmedStart(), untrimmed.TrimmedEnd())
+	}
+}
+
+func TestGenerateDatBits(t *testing.T) {
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = int16((i%100 - 50) * 600)
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.RetainSamples = true
+	w := NewFromSamples(samples, config)
+
+	dat16, err := w.GenerateDat()
+	if err != nil {
+		t.Fatalf("GenerateDat failed: %v", err)
+	}
+
+	config.DatBits = 8
+	dat8, err := w.GenerateDat()
+	if err != nil {
+		t.Fatalf("GenerateDat failed: %v", err)
+	}
+
+	const headerSize = 20
+	if expected := headerSize + config.Bars*2*2; len(dat16) != expected {
+		t.Errorf("Expected 16-bit export of %d bytes, got %d", expected, len(dat16))
+	}
+	if expected := headerSize + config.Bars*2; len(dat8) != expected {
+		t.Errorf("Expected 8-bit export of %d bytes, got %d", expected, len(dat8))
+	}
+
+	if flags := binary.LittleEndian.Uint32(dat16[4:8]); flags != 0 {
+		t.Errorf("Expected 16-bit flags 0, got %d", flags)
+	}
+	if flags := binary.LittleEndian.Uint32(dat8[4:8]); flags != datFlag8Bit {
+		t.Errorf("Expected 8-bit flags %d, got %d", datFlag8Bit, flags)
+	}
+	if spp := binary.LittleEndian.Uint32(dat16[12:16]); spp != 100 {
+		t.Errorf("Expected 100 samples per pixel, got %d", spp)
+	}
+
+	// Each bucket spans one full ramp from -30000 to 29400
+	lo, hi := int16(binary.LittleEndian.Uint16(dat16[20:22])), int16(binary.LittleEndian.Uint16(dat16[22:24]))
+	if lo != -30000 || hi != 29400 {
+		t.Errorf("Expected 16-bit pair (-30000, 29400), got (%d, %d)", lo, hi)
+	}
+	if lo8, hi8 := int8(dat8[20]), int8(dat8[21]); lo8 != int8(-30000>>8) || hi8 != int8(29400>>8) {
+		t.Errorf("Expected 8-bit pair (%d, %d), got (%d, %d)", int8(-30000>>8), int8(29400>>8), lo8, hi8)
+	}
+
+	config.DatBits = 24
+	if _, err := w.GenerateDat(); err == nil {
+		t.Error("Expected an error for an unsupported bit depth")
+	}
+}
+
+func TestOnsetClickTrain(t *testing.T) {
+	// A steady tone with a click every 2000 samples, landing in every fourth bar
+	samples := make([]int16, 20000)
+	for i := range samples {
+		samples[i] = int16(3000 * math.Sin(2*math.Pi*float64(i)/64))
+		if i%2000 >= 1000 && i%2000 < 1050 {
+			samples[i] = 25000
+		}
+	}
+
+	// The tone itself starts in bar 0, which is a genuine onset; skip it
+	peaks := downsample(context.Background(), samples, 40, ModeOnset, nil, nil)
+	for i := 1; i < len(peaks); i++ {
+		peak := peaks[i]
+		if i%4 == 2 {
+			if peak < 0.2 {
+				t.Errorf("Expected bar %d to spike at the click, got %f", i, peak)
+			}
+		} else if peak > 0.02 {
+			t.Errorf("Expected bar %d between clicks to stay low, got %f", i, peak)
+		}
+	}
+}
+
+func TestRootAttributes(t *testing.T) {
+	config := DefaultConfig()
+	config.PreserveAspectRatio = "none"
+	config.RootAttributes = map[string]string{
+		"class":     "wave & more",
+		"xmlns:ex":  "http://example.com/ns",
+		"xmlns":     "http://www.w3.org/2000/svg",
+		"data-bars": "3",
+	}
+
+	w := &Waveform{Peaks: []float64{0.2, 0.5, 0.8}, Config: config}
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	root := string(svgData[:strings.IndexByte(string(svgData), '>')])
+	for _, attr := range []string{
+		`preserveAspectRatio="none"`,
+		`class="wave &amp; more"`,
+		`xmlns:ex="http://example.com/ns"`,
+		`data-bars="3"`,
+		`viewBox="0 0 500 80"`,
+	} {
+		if !containsString(root, attr) {
+			t.Errorf("Expected root element to carry %s, got %s", attr, root)
+		}
+	}
+	if n := strings.Count(root, `xmlns="`); n != 1 {
+		t.Errorf("Expected an existing attribute to be replaced rather than duplicated, got %d xmlns", n)
+	}
+}
+
+func TestAccessibleText(t *testing.T) {
+	w := &Waveform{Peaks: []float64{0.2, 0.5, 0.8}, Config: DefaultConfig()}
+	plain, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	if containsString(string(plain), "<title>") || containsString(string(plain), `role="img"`) {
+		t.Error("Expected no accessibility elements without a Title or Description")
+	}
+
+	config := DefaultConfig()
+	config.Title = `Kick & "snare"`
+	config.Description = "Loud <intro>, quiet outro"
+	for _, axis := range []bool{false, true} {
+		config.ShowAmplitudeAxis = axis
+		w := &Waveform{Peaks: []float64{0.2, 0.5, 0.8}, Config: config}
+		svgData, err := w.GenerateSVG()
+		if err != nil {
+			t.Fatalf("GenerateSVG failed: %v", err)
+		}
+
+		text := string(svgData)
+		openEnd := strings.IndexByte(text, '>') + 1
+		root := text[:openEnd]
+		for _, attr := range []string{`role="img"`, `aria-label="Kick &amp; &#34;snare&#34;"`} {
+			if !containsString(root, attr) {
+				t.Errorf("Expected root element to carry %s, got %s", attr, root)
+			}
+		}
+		want := `<title>Kick &amp; &#34;snare&#34;</title><desc>Loud &lt;intro&gt;, quiet outro</desc>`
+		if !strings.HasPrefix(text[openEnd:], want) {
+			t.Errorf("Expected %s right after the opening tag, got %.120s", want, text[openEnd:])
+		}
+		if n := strings.Count(text, "<title>"); n != 1 {
+			t.Errorf("Expected a single <title> with axis %v, got %d", axis, n)
+		}
+
+		// The whole document stays well-formed XML
+		decoder := xml.NewDecoder(bytes.NewReader(svgData))
+		for {
+			if _, err := decoder.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Expected well-formed XML, got %v", err)
+			}
+		}
+	}
+}
+
+func TestBarClass(t *testing.T) {
+	peaks := []float64{0.2, 0.5, 0.8, 0.4}
+	for _, tc := range []struct {
+		name    string
+		setup   func(*Config)
+		samples []int16
+		shape   string
+		want    int
+	}{
+		{"paths", func(c *Config) {}, nil, "path", 4},
+		{"rects", func(c *Config) { c.CornerRadius = 0 }, nil, "rect", 4},
+		{"background and shadow", func(c *Config) {
+			c.BackgroundColor = "#000000"
+			c.BaseShadow = true
+		}, nil, "path", 4},
+		{"tooltips and loudness curve", func(c *Config) {
+			c.Tooltips = true
+			c.LoudnessCurve = true
+		}, make([]int16, 400), "path", 4},
+		// Without samples, as when streaming, no curve is drawn to leave out
+		{"loudness curve without samples", func(c *Config) {
+			c.LoudnessCurve = true
+		}, nil, "path", 4},
+		{"filled", func(c *Config) { c.Style = StyleFilled }, nil, "path", 1},
+	} {
+		config := DefaultConfig()
+		config.BarClass = `wave-bar "x"`
+		config.SVGID = "track-1"
+		tc.setup(config)
+
+		w := &Waveform{Peaks: peaks, Config: config, samples: tc.samples}
+		svgData, err := w.GenerateSVG()
+		if err != nil {
+			t.Fatalf("%s: GenerateSVG failed: %v", tc.name, err)
+		}
+		text := string(svgData)
+
+		classed := regexp.MustCompile(`<(\w+) [^>]*class="wave-bar &#34;x&#34;"`).FindAllStringSubmatch(text, -1)
+		if len(classed) != tc.want {
+			t.Errorf("%s: expected %d classed elements, got %d", tc.name, tc.want, len(classed))
+		}
+		for _, m := range classed {
+			if m[1] != tc.shape {
+				t.Errorf("%s: expected the class on <%s> elements, got <%s>", tc.name, tc.shape, m[1])
+			}
+		}
+		if root := text[:strings.IndexByte(text, '>')]; !containsString(root, `id="track-1"`) {
+			t.Errorf("%s: expected the root element to carry the id, got %s", tc.name, root)
+		}
+	}
+
+	plain, err := (&Waveform{Peaks: peaks, Config: DefaultConfig()}).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	if containsString(string(plain), "class=") || containsString(string(plain), "id=") {
+		t.Error("Expected no class or id without BarClass and SVGID")
+	}
+}
+
+func TestGenerateThumbnail(t *testing.T) {
+	samples := make([]int16, 500000)
+	for i := range samples {
+		samples[i] = int16((i * 31) % 20000)
+	}
+
+	thumb, err := NewFromSamples(samples, DefaultConfig()).GenerateThumbnail(80, 20, 40)
+	if err != nil {
+		t.Fatalf("GenerateThumbnail failed: %v", err)
+	}
+
+	svgStr := string(thumb)
+	if !containsString(svgStr, `viewBox="0 0 80 20"`) {
+		t.Errorf("Expected an 80x20 thumbnail, got %s", svgStr[:strings.IndexByte(svgStr, '>')+1])
+	}
+	if bars := strings.Count(svgStr, "<path") + strings.Count(svgStr, "<rect"); bars != 40 {
+		t.Errorf("Expected 40 bars, got %d", bars)
+	}
+	if tooPrecise := regexp.MustCompile(`\.\d{2,}`).FindString(svgStr); tooPrecise != "" {
+		t.Errorf("Expected thumbnail coordinates rounded to 1 decimal, found %s", tooPrecise)
+	}
+}
+
+func benchmarkThumbnailSamples() []int16 {
+	samples := make([]int16, 10_000_000)
+	for i := range samples {
+		samples[i] = int16((i*7919)%30000 - 15000)
+	}
+	return samples
+}
+
+func BenchmarkGenerateThumbnail(b *testing.B) {
+	w := NewFromSamples(benchmarkThumbnailSamples(), &Config{Bars: 1, Mode: ModePeak})
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := w.GenerateThumbnail(80, 20, 40); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkThumbnailFullPath(b *testing.B) {
+	samples := benchmarkThumbnailSamples()
+	config := DefaultConfig()
+	config.Width, config.Height, config.Bars = 80, 20, 40
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := NewFromSamples(samples, config).GenerateSVG(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestAllowOverlap(t *testing.T) {
+	config := DefaultConfig()
+	config.Width = 100
+	config.BarSpacing = -4
+	config.CornerRadius = 0
+
+	w := &Waveform{Peaks: []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5}, Config: config}
+
+	clamped, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	if !containsString(string(clamped), `x="10" y="1.6" width="10"`) {
+		t.Errorf("Expected negative spacing to be treated as 0 by default, got %s", clamped)
+	}
+
+	config.AllowOverlap = true
+	overlapping, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	// Bars start every 10px but are 14px wide, so each covers 4px of the next
+	if !containsString(string(overlapping), `x="0" y="1.6" width="14"`) || !containsString(string(overlapping), `x="10" y="1.6" width="14"`) {
+		t.Errorf("Expected overlapping 14px bars every 10px, got %s", overlapping)
+	}
+}
+
+func TestTilesShareNormalization(t *testing.T) {
+	// One loud second followed by one quiet second at 1kHz mono
+	samples := make([]int16, 2000)
+	for i := range samples {
+		amp := 20000.0
+		if i >= 1000 {
+			amp = 5000
+		}
+		samples[i] = int16(amp * math.Sin(2*math.Pi*float64(i)/20))
+	}
+
+	config := DefaultConfig()
+	config.Bars = 5
+	config.Mode = ModePeak
+	config.CornerRadius = 0
+
+	tiles, err := renderTiles(samples, streamInfo{sampleRate: 1000, channels: 1}, time.Second, config)
+	if err != nil {
+		t.Fatalf("renderTiles failed: %v", err)
+	}
+	if len(tiles) != 2 {
+		t.Fatalf("Expected 2 tiles, got %d", len(tiles))
+	}
+
+	// Full height is 0.48*80*2 = 76.8; the quiet tile must reach only a quarter of that
+	if !containsString(string(tiles[0]), `height="76.8"`) {
+		t.Error("Expected the loud tile to reach full height")
+	}
+	if containsString(string(tiles[1]), `height="76.8"`) {
+		t.Error("Expected the quiet tile to be scaled against the loud one, not to its own maximum")
+	}
+}
+
+func TestDeviationView(t *testing.T) {
+	config := DefaultConfig()
+	config.Width = 400
+	config.BarSpacing = 0
+	config.CornerRadius = 0
+	config.DeviationView = true
+
+	// Mean is 0.5: bar 0 is above average, bar 1 below, bar 2 above, bar 3 below
+	w := &Waveform{Peaks: []float64{0.9, 0.1, 0.7, 0.3}, Config: config}
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	rects := regexp.MustCompile(`<rect x="([\d.]+)" y="([\d.]+)" width="[\d.]+" height="([\d.]+)"`).FindAllStringSubmatch(string(svgData), -1)
+	if len(rects) != 4 {
+		t.Fatalf("Expected 4 bars, got %d in %s", len(rects), svgData)
+	}
+
+	for i, rect := range rects {
+		y, _ := strconv.ParseFloat(rect[2], 64)
+		h, _ := strconv.ParseFloat(rect[3], 64)
+		above := w.Peaks[i] > 0.5
+
+		// SVG y grows downwards: upward bars end at the midline, downward bars start there
+		if above && math.Abs(y+h-40) > 1e-6 {
+			t.Errorf("Bar %d is above average and should rise from the midline, got y=%v h=%v", i, y, h)
+		}
+		if !above && math.Abs(y-40) > 1e-6 {
+			t.Errorf("Bar %d is below average and should hang from the midline, got y=%v h=%v", i, y, h)
+		}
+	}
+
+	// The largest deviation fills half the height
+	if rects[0][3] != "38.4" || rects[1][3] != "38.4" {
+		t.Errorf("Expected the outermost bars to be 38.4 high, got %s and %s", rects[0][3], rects[1][3])
+	}
+}
+
+func TestBaseShadow(t *testing.T) {
+	config := DefaultConfig()
+	config.PerBarFade = true
+	w := &Waveform{Peaks: []float64{0.2, 0.9, 0.5}, Config: config}
+
+	plain, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	if containsString(string(plain), "waveform-shadow") {
+		t.Error("Expected no shadow by default")
+	}
+
+	config.BaseShadow = true
+	shadowed, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	svgStr := string(shadowed)
+	for _, part := range []string{
+		`<filter id="waveform-shadow"`,
+		`<feGaussianBlur in="SourceAlpha" stdDeviation="2"/>`,
+		`<g class="waveform-shadow" transform="translate(2 2)" filter="url(#waveform-shadow)">`,
+	} {
+		if !containsString(svgStr, part) {
+			t.Errorf("Expected shadow SVG to contain %s", part)
+		}
+	}
+
+	// The shadow duplicates every bar, but not the gradient definitions
+	if bars := strings.Count(svgStr, "<path"); bars != 2*len(w.Peaks) {
+		t.Errorf("Expected %d bars including the shadow copy, got %d", 2*len(w.Peaks), bars)
+	}
+	if ids := strings.Count(svgStr, `<linearGradient id="p1"`); ids != 1 {
+		t.Errorf("Expected gradient ids to stay unique, found p1 defined %d times", ids)
+	}
+}
+
+func TestViewBoxOnly(t *testing.T) {
+	config := DefaultConfig()
+	config.ViewBoxOnly = true
+
+	w := &Waveform{Peaks: []float64{0.2, 0.5, 0.8}, Config: config}
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	root := string(svgData[:strings.IndexByte(string(svgData), '>')])
+	if containsString(root, ` width="`) || containsString(root, ` height="`) {
+		t.Errorf("Expected no width/height on the root element, got %s", root)
+	}
+	if !containsString(root, `viewBox="0 0 500 80"`) {
+		t.Errorf("Expected the root element to keep its viewBox, got %s", root)
+	}
+
+	// Bars keep their own dimensions
+	if !containsString(string(svgData), `<path`) {
+		t.Error("Expected bars to be drawn")
+	}
+}
+
+func TestAmplitudeHistogram(t *testing.T) {
+	// Uniform noise over the full range spreads evenly across amplitude bins
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]int16, 200000)
+	for i := range samples {
+		samples[i] = int16(rng.Intn(65536) - 32768)
+	}
+
+	const bins = 16
+	counts := AmplitudeHistogram(samples, bins)
+	if len(counts) != bins {
+		t.Fatalf("Expected %d bins, got %d", bins, len(counts))
+	}
+
+	total := 0
+	expected := float64(len(samples)) / bins
+	for i, c := range counts {
+		total += c
+		if math.Abs(float64(c)-expected) > expected*0.05 {
+			t.Errorf("Bin %d holds %d samples, expected about %.0f", i, c, expected)
+		}
+	}
+	if total != len(samples) {
+		t.Errorf("Expected every sample counted once, got %d of %d", total, len(samples))
+	}
+
+	// Extremes land in the outer bins
+	counts = AmplitudeHistogram([]int16{0, -32768, 32767}, 4)
+	if counts[0] != 1 || counts[3] != 2 {
+		t.Errorf("Expected silence in bin 0 and full scale in bin 3, got %v", counts)
+	}
+
+	if AmplitudeHistogram(samples, 0) != nil {
+		t.Error("Expected nil for zero bins")
+	}
+}
+
+func TestGeneratePNG(t *testing.T) {
+	config := DefaultConfig()
+	config.Width = 100
+	config.Height = 40
+	config.Bars = 10
+	config.CornerRadius = 0
+	config.Scale = 2
+
+	peaks := make([]float64, config.Bars)
+	for i := range peaks {
+		peaks[i] = 1
+	}
+
+	w := &Waveform{Peaks: peaks, Config: config}
+	data, err := w.GeneratePNG()
+	if err != nil {
+		t.Fatalf("GeneratePNG failed: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected a valid PNG: %v", err)
+	}
+	if size := img.Bounds().Size(); size.X != 200 || size.Y != 80 {
+		t.Fatalf("Expected a 200x80 image at scale 2, got %dx%d", size.X, size.Y)
+	}
+
+	// Full-height bars cover the middle of the first bar and leave the gap after it clear
+	if _, _, _, a := img.At(4, 40).RGBA(); a == 0 {
+		t.Error("Expected the first bar to be drawn")
+	}
+	if _, _, _, a := img.At(19, 40).RGBA(); a != 0 {
+		t.Error("Expected the spacing between bars to stay transparent")
+	}
+
+	config.Width = 0
+	if _, err := w.GeneratePNG(); err == nil {
+		t.Error("Expected an error for an empty image")
+	}
+}
+
+func TestClipOverlay(t *testing.T) {
+	samples := make([]int16, 20000)
+	for i := range samples {
+		amplitude := 20000.0
+		if i >= 6000 && i < 10000 {
+			amplitude = 40000 // driven past full scale
+		}
+		v := amplitude * math.Sin(2*math.Pi*float64(i)/50)
+		samples[i] = int16(math.Max(-32767, math.Min(32767, v)))
+	}
+
+	config := DefaultConfig()
+	config.Bars = 20
+	config.ClipOverlay = true
+
+	w := NewFromSamples(samples, config)
+	regions := w.ClipRegions()
+	if len(regions) != 1 || regions[0] != (ClipRegion{Start: 6, End: 10}) {
+		t.Fatalf("Expected one clipped region over bars 6-9, got %v", regions)
+	}
+
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	svgStr := string(svgData)
+
+	start := strings.Index(svgStr, `<g class="waveform-clips">`)
+	if start < 0 {
+		t.Fatalf("Expected a clip overlay group in %s", svgStr)
+	}
+	overlay := svgStr[start:]
+	if count := strings.Count(overlay, "<rect"); count != 1 {
+		t.Errorf("Expected a single overlay band, got %d", count)
+	}
+	if !containsString(overlay, `<rect x="150" y="0" width="100" height="80"`) {
+		t.Errorf("Expected the band to span bars 6-9, got %s", overlay)
+	}
+	if !containsString(overlay, ">clipped</text>") {
+		t.Errorf("Expected the band to be labelled, got %s", overlay)
+	}
+
+	config.ClipOverlay = false
+	plain, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	if containsString(string(plain), "waveform-clips") {
+		t.Error("Expected no overlay when ClipOverlay is off")
+	}
+}
+
+func TestNewFromFloatSamples(t *testing.T) {
+	// A tone at full scale, then twice as loud: beyond what int16 can hold
+	samples := make([]float32, 20000)
+	for i := range samples {
+		amplitude := 1.0
+		if i >= 10000 {
+			amplitude = 2.0
+		}
+		samples[i] = float32(amplitude * math.Sin(2*math.Pi*float64(i)/50))
+	}
+
+	config := DefaultConfig()
+	config.Bars = 20
+	config.Mode = ModePeak
+	config.Concurrent = false
+	config.RetainSamples = true
+
+	w := NewFromFloatSamples(samples, config)
+	if len(w.Peaks) != 20 {
+		t.Fatalf("Expected 20 peaks, got %d", len(w.Peaks))
+	}
+
+	// Clipping would flatten both halves to the same level
+	if ratio := w.Peaks[15] / w.Peaks[5]; math.Abs(ratio-2) > 0.01 {
+		t.Errorf("Expected the loud half to peak twice as high, got ratio %.3f", ratio)
+	}
+
+	// In-range input keeps its level
+	quiet := NewFromFloatSamples([]float32{0.5, -0.5, 1, -1}, config)
+	if quiet.samples[0] != 16384 || quiet.samples[3] != -32767 {
+		t.Errorf("Expected in-range floats to map to int16 full scale, got %v", quiet.samples)
+	}
+}
+
+func TestLoudnessCurve(t *testing.T) {
+	// A tone fading in, so the loudness climbs across the waveform
+	samples := make([]int16, 20000)
+	for i := range samples {
+		samples[i] = int16(20000 * float64(i) / float64(len(samples)) * math.Sin(float64(i)/5))
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.LoudnessCurve = true
+
+	w := NewFromSamples(samples, config)
+	series := w.ShortTermLoudness()
+	if len(series) != 10 {
+		t.Fatalf("Expected a loudness value per bar, got %d", len(series))
+	}
+	for i := 1; i < len(series); i++ {
+		if series[i] < series[i-1] {
+			t.Errorf("Expected loudness to rise with the fade-in, bar %d: %.1f after %.1f", i, series[i], series[i-1])
+		}
+	}
+
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	curve := regexp.MustCompile(`<path d="([^"]*)" style="fill:none;stroke:#f59e0b[^"]*"/>`).FindStringSubmatch(string(svgData))
+	if curve == nil {
+		t.Fatalf("Expected a stroked loudness curve in %s", svgData)
+	}
+	// One point per bar: a move followed by a line (or its H/V shorthand) to each next bar
+	if points := len(regexp.MustCompile(`[MLHV]`).FindAllString(curve[1], -1)); points != 10 {
+		t.Errorf("Expected 10 points on the loudness curve, got %d in %q", points, curve[1])
+	}
+}
+
+func TestLogTimeAxis(t *testing.T) {
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = 10000
+	}
+
+	config := DefaultConfig()
+	config.Bars = 20
+	config.CornerRadius = 0
+	config.TimeAxis = TimeAxisLog
+
+	svgData, err := NewFromSamples(samples, config).GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+
+	rects := regexp.MustCompile(`<rect x="([\d.]+)" y="[\d.]+" width="([\d.]+)"`).FindAllStringSubmatch(string(svgData), -1)
+	if len(rects) != 20 {
+		t.Fatalf("Expected 20 bars, got %d", len(rects))
+	}
+
+	var prevWidth, end float64
+	for i, rect := range rects {
+		x, _ := strconv.ParseFloat(rect[1], 64)
+		width, _ := strconv.ParseFloat(rect[2], 64)
+		if i > 0 && width >= prevWidth {
+			t.Errorf("Expected bar %d to be narrower than bar %d, got %g after %g", i, i-1, width, prevWidth)
+		}
+		prevWidth, end = width, x+width+float64(config.BarSpacing)
+	}
+	if math.Abs(end-float64(config.Width)) > 0.01 {
+		t.Errorf("Expected the bars to span the full width, last slot ends at %g", end)
+	}
+}
+
+func TestPoints(t *testing.T) {
+	samples := make([]int16, 1000)
+	for i := range samples {
+		samples[i] = int16(i / 100 * 3000)
+	}
+
+	config := DefaultConfig()
+	config.Bars = 10
+	config.Mode = ModePeak
+	w := NewFromSamples(samples, config)
+
+	points := w.Points()
+	if len(points) != config.Bars {
+		t.Fatalf("Expected %d points, got %d", config.Bars, len(points))
+	}
+
+	maxPeak := peakMax(w.Peaks)
+	mid := float64(config.Height) / 2
+	for i, point := range points {
+		// 50px slots hold 48px bars after the 2px spacing
+		if want := float64(i)*50 + 24; point[0] != want {
+			t.Errorf("Point %d: expected x at the bar centre %g, got %g", i, want, point[0])
+		}
+		// The first bar is silent and only gets the minimum height
+		want := mid - math.Max(w.Peaks[i]/maxPeak*float64(config.Height)*0.48, 3)
+		if math.Abs(point[1]-want) > 1e-9 {
+			t.Errorf("Point %d: expected y %g for peak %g, got %g", i, want, w.Peaks[i], point[1])
+		}
+	}
+	if points[len(points)-1][1] != mid-float64(config.Height)*0.48 {
+		t.Errorf("Expected the loudest bar to reach the top, got y %g", points[len(points)-1][1])
+	}
+}
+
+func TestOrientation(t *testing.T) {
+	peaks := []float64{0.2, 0.5, 1, 0.4, 0.8}
+	rectPattern := regexp.MustCompile(`<rect x="([\d.]+)" y="([\d.]+)" width="([\d.]+)" height="([\d.]+)"`)
+
+	for _, tc := range []struct {
+		orientation   Orientation
+		width, height int
+	}{
+		{OrientationHorizontal, 300, 80},
+		{OrientationVertical, 80, 300},
+	} {
+		config := DefaultConfig()
+		config.Width, config.Height = tc.width, tc.height
+		config.Bars = len(peaks)
+		config.CornerRadius = 0
+		config.Orientation = tc.orientation
+		w := &Waveform{Peaks: peaks, Config: config}
+
+		svgData, err := w.GenerateSVG()
+		if err != nil {
+			t.Fatalf("%s: GenerateSVG failed: %v", tc.orientation, err)
+		}
+		decoder := xml.NewDecoder(bytes.NewReader(svgData))
+		for {
+			if _, err := decoder.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: invalid SVG: %v\n%s", tc.orientation, err, svgData)
+			}
+		}
+		if want := fmt.Sprintf(`viewBox="0 0 %d %d"`, tc.width, tc.height); !containsString(string(svgData), want) {
+			t.Errorf("%s: expected %s in %s", tc.orientation, want, svgData)
+		}
+
+		rects := rectPattern.FindAllStringSubmatch(string(svgData), -1)
+		if len(rects) != len(peaks) {
+			t.Fatalf("%s: expected %d bars, got %d in %s", tc.orientation, len(peaks), len(rects), svgData)
+		}
+		points := w.Points()
+		for i, rect := range rects {
+			var v [4]float64
+			for j := range v {
+				v[j], _ = strconv.ParseFloat(rect[j+1], 64)
+			}
+			x, y, width, height := v[0], v[1], v[2], v[3]
+			// Time runs along the long side in 60px slots, the loudest bar reaching across 96% of the short one
+			along, across, pos, centre := x, height, y+height/2, float64(tc.height)/2
+			thickness := width
+			if tc.orientation == OrientationVertical {
+				along, across, pos, centre = y, width, x+width/2, float64(tc.width)/2
+				thickness = height
+			}
+			if along != float64(i)*60 || thickness != 58 {
+				t.Errorf("%s: expected bar %d at %d along the time axis and 58px thick, got %g and %g", tc.orientation, i, i*60, along, thickness)
+			}
+			if math.Abs(pos-centre) > 1e-6 {
+				t.Errorf("%s: expected bar %d centred on %g, got %g", tc.orientation, i, centre, pos)
+			}
+			if want := peaks[i] * 0.96 * 80; math.Abs(across-want) > 1e-6 {
+				t.Errorf("%s: expected bar %d to reach %g across, got %g", tc.orientation, i, want, across)
+			}
+
+			// The tip of a vertical bar is the middle of its right end
+			tip := [2]float64{x + width/2, y}
+			if tc.orientation == OrientationVertical {
+				tip = [2]float64{x + width, y + height/2}
+			}
+			if math.Abs(points[i][0]-tip[0]) > 1e-6 || math.Abs(points[i][1]-tip[1]) > 1e-6 {
+				t.Errorf("%s: expected point %d at %v, got %v", tc.orientation, i, tip, points[i])
+			}
+		}
+	}
+
+	// Rounded corners, gradients and PNG output carry over to vertical waveforms
+	config := DefaultConfig()
+	config.Width, config.Height = 80, 300
+	config.Orientation = OrientationVertical
+	config.GradientStart, config.GradientEnd = "#FF0000", "#0000FF"
+	w := &Waveform{Peaks: peaks, Config: config}
+	svgData, err := w.GenerateSVG()
+	if err != nil {
+		t.Fatalf("GenerateSVG failed: %v", err)
+	}
+	if !containsString(string(svgData), "linearGradient") {
+		t.Errorf("Expected the gradient to apply to vertical waveforms, got %s", svgData)
+	}
+	pngData, err := w.GeneratePNG()
+	if err != nil {
+		t.Fatalf("GeneratePNG failed: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		t.Fatalf("Invalid PNG: %v", err)
+	}
+	if size := img.Bounds().Size(); size.X != 80 || size.Y != 300 {
+		t.Errorf("Expected an 80x300 PNG, got %v", size)
+	}
+}
+
+func TestBucketsCoverEverySample(t *testing.T) {
+	for _, tc := range []struct{ n, buckets int }{{100003, 100}, {1000, 7}, {5, 10}, {100, 100}} {
+		total, next := 0, 0
+		for bucket := 0; bucket < tc.buckets; bucket++ {
+			start, end := bucketBounds(bucket, tc.buckets, tc.n)
+			if start != next {
+				t.Errorf("%d samples in %d buckets: bucket %d starts at %d, expected %d", tc.n, tc.buckets, bucket, start, next)
+			}
+			for i := start; i < end; i++ {
+				if got := bucketOf(i, tc.buckets, tc.n); got != bucket {
+					t.Fatalf("%d samples in %d buckets: sample %d is in bucket %d, bucketOf says %d", tc.n, tc.buckets, i, bucket, got)
+				}
+			}
+			total += end - start
+			next = end
+		}
+		if total != tc.n {
+			t.Errorf("%d samples in %d buckets: buckets hold %d samples", tc.n, tc.buckets, total)
+		}
+	}
+
+	// The final bar must reflect the very end of the audio, even a short burst after the even split
+	samples := make([]int16, 100003)
+	for i := len(samples) - 3; i < len(samples); i++ {
+		samples[i] = 30000
+	}
+	peaks := downsample(context.Background(), samples, 100, ModePeak, nil, nil)
+	if peaks[99] == 0 {
+		t.Error("Expected the last samples to reach the last bar")
+	}
+	if concurrent := downsampleConcurrent(context.Background(), samples, 100, ModePeak, nil, nil); !slices.Equal(concurrent, peaks) {
+		t.Errorf("Expected concurrent downsampling to match, got %v", concurrent)
+	}
+}
+
+// Helper function since Go doesn't have strings.Contains in older versions
+func containsString(s, substr string) bool {
+	for i := 0; i <= len(s)-len(substr); i++ {
+		if s[i:i+len(substr)] == substr {
+			return true
+		}
+	}
+	return false
+}
+
+// sineTone returns seconds of a sine at freq Hz peaking level dB below full
+// scale, interleaved over channels
+func sineTone(rate, channels int, freq, level, seconds float64) []int16 {
+	amplitude := 32767 * math.Pow(10, level/20)
+	frames := int(seconds * float64(rate))
+	samples := make([]int16, frames*channels)
+	for i := 0; i < frames; i++ {
+		v := int16(math.Round(amplitude * math.Sin(2*math.Pi*freq*float64(i)/float64(rate))))
+		for c := 0; c < channels; c++ {
+			samples[i*channels+c] = v
+		}
+	}
+	return samples
+}
+
+func TestIntegratedLoudness(t *testing.T) {
+	// EBU Tech 3341: a stereo 1kHz sine at -23 dBFS measures -23 LUFS
+	for _, rate := range []int{48000, 44100} {
+		w := &Waveform{
+			samples: sineTone(rate, 2, 1000, -23, 10),
+			info:    streamInfo{sampleRate: rate, channels: 2},
+		}
+		if got, err := w.IntegratedLoudness(); err != nil || math.Abs(got+23) > 0.1 {
+			t.Errorf("Expected a -23 dBFS tone at %d Hz to measure -23 LUFS, got %.2f (%v)", rate, got, err)
+		}
+	}
+
+	// Quiet passages more than 10 LU below the rest are gated out
+	gated := sineTone(48000, 2, 1000, -45, 10)
+	gated = append(gated, sineTone(48000, 2, 1000, -23, 20)...)
+	gated = append(gated, sineTone(48000, 2, 1000, -45, 10)...)
+	w := &Waveform{samples: gated, info: streamInfo{sampleRate: 48000, channels: 2}}
+	if got, err := w.IntegratedLoudness(); err != nil || math.Abs(got+23) > 0.1 {
+		t.Errorf("Expected the quiet passages to be gated out, got %.2f LUFS (%v)", got, err)
+	}
+
+	for name, samples := range map[string][]int16{
+		"silence": make([]int16, 48000),
+		"short":   sineTone(48000, 1, 1000, -23, 0.3),
+	} {
+		w := &Waveform{samples: samples, info: streamInfo{sampleRate: 48000, channels: 1}}
+		if got, err := w.IntegratedLoudness(); err != nil || !math.IsInf(got, -1) {
+			t.Errorf("Expected %s to measure -Inf, got %.2f (%v)", name, got, err)
+		}
+	}
+
+	// Without samples there is nothing to measure, which is not the same as silence
+	w = &Waveform{Peaks: []float64{0.5}, info: streamInfo{sampleRate: 48000, channels: 2}}
+	if _, err := w.IntegratedLoudness(); err == nil || !strings.Contains(err.Error(), "RetainSamples") {
+		t.Errorf("Expected an error pointing at RetainSamples without samples, got %v", err)
+	}
+}
+
+func TestModeLUFSTrue(t *testing.T) {
+	info := streamInfo{sampleRate: 48000, channels: 1}
+	level := func(freq float64) []float64 {
+		t.Helper()
+		return computePeaks(context.Background(), sineTone(48000, 1, freq, -6, 2), info, 20, ModeLUFSTrue, nil, true, nil)
+	}
+
+	// K-weighting cuts the deep lows and lifts the highs
+	low, mid, high := level(20), level(1000), level(8000)
+	if low[10] >= mid[10] || high[10] <= mid[10] {
+		t.Errorf("Expected 20Hz < 1kHz < 8kHz after K-weighting, got %.3f, %.3f and %.3f", low[10], mid[10], high[10])
+	}
+
+	// The filters carry their state across bars, so a steady tone gives steady bars
+	for i := 1; i < len(mid); i++ {
+		if math.Abs(mid[i]-mid[10]) > 0.001 {
+			t.Errorf("Expected bar %d of a steady tone to match the others, got %.4f and %.4f", i, mid[i], mid[10])
 		}
 	}
-	return false
 }
@@ -0,0 +1,80 @@
+package waveform
+
+import (
+	"runtime"
+	"sync"
+)
+
+// workerLimiter caps the number of goroutines crunching samples across all
+// concurrent waveform generations, so servers handling many requests at once
+// don't oversubscribe the CPU
+type workerLimiter struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+}
+
+func newWorkerLimiter(limit int) *workerLimiter {
+	l := &workerLimiter{limit: limit}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire blocks until a worker slot is available
+func (l *workerLimiter) acquire() {
+	l.mu.Lock()
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+	l.mu.Unlock()
+}
+
+// release frees a worker slot
+func (l *workerLimiter) release() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+	l.cond.Signal()
+}
+
+// setLimit changes the number of slots and wakes any waiters that now fit
+func (l *workerLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	l.limit = limit
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+func (l *workerLimiter) getLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// workers is the package-wide pool shared by every concurrent downsample
+var workers = newWorkerLimiter(runtime.NumCPU())
+
+// SetMaxWorkers caps the total number of worker goroutines used for concurrent
+// processing across all calls. A value of 0 or less resets it to runtime.NumCPU().
+func SetMaxWorkers(n int) {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	workers.setLimit(n)
+}
+
+// MaxWorkers returns the current cap on concurrent worker goroutines
+func MaxWorkers() int {
+	return workers.getLimit()
+}
+
+// workersPerCall returns how many workers a single call should split its work into
+func workersPerCall() int {
+	n := runtime.NumCPU()
+	if limit := MaxWorkers(); limit < n {
+		n = limit
+	}
+	return n
+}